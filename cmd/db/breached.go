@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/96malhar/snippetbox/internal/validation"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func breachedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "breached",
+		Short: "Work with the lists of breached passwords the Snippetbox app rejects",
+	}
+	cmd.AddCommand(breachedBuild())
+	return cmd
+}
+
+func breachedBuild() *cobra.Command {
+	var output string
+	var falsePositiveRate float64
+	var minCount int
+	cmd := &cobra.Command{
+		Use:   "build FILE...",
+		Short: "Builds a bloom filter of breached passwords for BREACHED_PASSWORDS_FILE",
+		Long: "Builds a bloom filter of breached passwords for BREACHED_PASSWORDS_FILE. Each line of the " +
+			"files is either a password, or the SHA-1 hash of one as found in the Pwned Passwords lists of " +
+			"Have I Been Pwned, optionally followed by a colon and the number of times it was seen.",
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// The files are read twice, to size the filter and then to fill it, so
+			// that lists much bigger than the memory can be used.
+			n := 0
+			for _, path := range args {
+				must(eachBreachedPassword(path, minCount, func([sha1.Size]byte) { n++ }))
+			}
+
+			filter := validation.NewBloomFilter(n, falsePositiveRate)
+			for _, path := range args {
+				must(eachBreachedPassword(path, minCount, filter.AddHash))
+			}
+
+			w, err := os.Create(output)
+			must(err)
+			_, err = filter.WriteTo(w)
+			must(err)
+			must(w.Close())
+			infoLog.Printf("Wrote %d passwords to %s", n, output)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the filter to")
+	cmd.Flags().Float64Var(&falsePositiveRate, "false-positive-rate", 1e-6, "rate of passwords wrongly found in the filter")
+	cmd.Flags().IntVar(&minCount, "min-count", 0, "only add the hashes seen at least this many times")
+	must(cmd.MarkFlagRequired("output"))
+	return cmd
+}
+
+// eachBreachedPassword calls fn with the SHA-1 hash of each password listed in
+// the file at path.
+func eachBreachedPassword(path string, minCount int, fn func([sha1.Size]byte)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		hash, count, found := strings.Cut(line, ":")
+		if sum, err := hex.DecodeString(hash); err == nil && len(sum) == sha1.Size {
+			if n, err := strconv.Atoi(count); found && err == nil && n < minCount {
+				continue
+			}
+			fn([sha1.Size]byte(sum))
+			continue
+		}
+
+		fn(sha1.Sum([]byte(line)))
+	}
+	return scanner.Err()
+}
@@ -3,11 +3,16 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/migrate"
+	"github.com/96malhar/snippetbox/internal/store"
 	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
 	"log"
 	"os"
 	"runtime/debug"
+	"strconv"
+	"time"
 )
 
 var dbUser, dbName, dbPassword string
@@ -24,10 +29,10 @@ func init() {
 
 func main() {
 	cmd := &cobra.Command{
-		Use:   "migrate",
+		Use:   "db",
 		Short: "Control the database lifecycle for the Snippetbox app",
 	}
-	cmd.AddCommand(setupDB(), teardown())
+	cmd.AddCommand(setupDB(), teardown(), migrateCmd(), promoteAdmin(), auditCmd(), breachedCmd())
 	must(cmd.Execute())
 }
 
@@ -72,6 +77,175 @@ func teardown() *cobra.Command {
 	return cmd
 }
 
+func migrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Migrates the schema of the database of SNIPPETBOX_DB_DSN",
+		Long: "Migrates the schema of the database of SNIPPETBOX_DB_DSN with the migrations built into this " +
+			"command. Each migration runs in a transaction, and concurrent runs wait for each other.",
+	}
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "up",
+			Short: "Applies every pending migration",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				logSteps(newMigrator().Up(cmd.Context()))
+			},
+		},
+		&cobra.Command{
+			Use:   "down",
+			Short: "Reverts the last applied migration",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				logSteps(newMigrator().Down(cmd.Context()))
+			},
+		},
+		&cobra.Command{
+			Use:   "to VERSION",
+			Short: "Applies or reverts migrations until the database is at VERSION, 0 reverts them all",
+			Args:  cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				logSteps(newMigrator().To(cmd.Context(), parseVersion(args[0])))
+			},
+		},
+		&cobra.Command{
+			Use:   "status",
+			Short: "Prints the version of the database and the pending migrations",
+			Args:  cobra.NoArgs,
+			Run: func(cmd *cobra.Command, args []string) {
+				status, err := newMigrator().Status(cmd.Context())
+				must(err)
+
+				infoLog.Printf("Database is at version %d", status.Version)
+				if status.Dirty {
+					infoLog.Printf("Migration %d failed half-way, fix the database and run migrate force", status.Version)
+				}
+				for _, m := range status.Pending {
+					infoLog.Printf("Pending %d_%s", m.Version, m.Name)
+				}
+			},
+		},
+		&cobra.Command{
+			Use:   "force VERSION",
+			Short: "Sets the version of the database without running any migration, and clears its dirty flag",
+			Args:  cobra.ExactArgs(1),
+			Run: func(cmd *cobra.Command, args []string) {
+				version := parseVersion(args[0])
+				must(newMigrator().Force(cmd.Context(), version))
+				infoLog.Printf("Forced version %d", version)
+			},
+		},
+	)
+	return cmd
+}
+
+func newMigrator() *migrate.Migrator {
+	db, backend, err := store.Open(os.Getenv("SNIPPETBOX_DB_DSN"))
+	must(err)
+	m, err := migrate.New(db, backend)
+	must(err)
+	return m
+}
+
+func parseVersion(s string) int {
+	version, err := strconv.Atoi(s)
+	if err != nil || version < 0 {
+		must(fmt.Errorf("invalid version %q", s))
+	}
+	return version
+}
+
+// logSteps logs the migrations which were applied or reverted, then exits if
+// err is set.
+func logSteps(steps []migrate.Step, err error) {
+	for _, step := range steps {
+		if step.Up {
+			infoLog.Printf("Applied %d_%s", step.Migration.Version, step.Migration.Name)
+		} else {
+			infoLog.Printf("Reverted %d_%s", step.Migration.Version, step.Migration.Name)
+		}
+	}
+	if len(steps) == 0 && err == nil {
+		infoLog.Printf("No change")
+	}
+	must(err)
+}
+
+func promoteAdmin() *cobra.Command {
+	var email string
+	cmd := &cobra.Command{
+		Use:   "promote-admin",
+		Short: "Gives the admin role to an existing user of the Snippetbox app",
+		Long: "Gives the admin role to an existing user of the Snippetbox app. This is how the first admin " +
+			"is created, further roles can be managed from the admin console.",
+		Run: func(cmd *cobra.Command, args []string) {
+			db, _, err := store.Open(os.Getenv("SNIPPETBOX_DB_DSN"))
+			must(err)
+
+			result, err := db.Exec("UPDATE users SET role = 'admin' WHERE email = $1", email)
+			must(err)
+
+			n, err := result.RowsAffected()
+			must(err)
+			if n == 0 {
+				must(fmt.Errorf("no user with email %s found", email))
+			}
+			infoLog.Printf("Promoted %s to admin", email)
+		},
+	}
+	cmd.Flags().StringVar(&email, "email", "", "email address of the user to promote")
+	must(cmd.MarkFlagRequired("email"))
+	return cmd
+}
+
+func auditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Work with the audit log of the Snippetbox app",
+	}
+	cmd.AddCommand(auditExport())
+	return cmd
+}
+
+func auditExport() *cobra.Command {
+	var filter audit.Filter
+	var since, until, output string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Writes the audit log out as JSON Lines, oldest event first",
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			if since != "" {
+				filter.Since, err = time.Parse(time.RFC3339, since)
+				must(err)
+			}
+			if until != "" {
+				filter.Until, err = time.Parse(time.RFC3339, until)
+				must(err)
+			}
+
+			w := os.Stdout
+			if output != "" {
+				w, err = os.Create(output)
+				must(err)
+				defer w.Close()
+			}
+
+			db, _, err := store.Open(os.Getenv("SNIPPETBOX_DB_DSN"))
+			must(err)
+
+			must(audit.NewLog(db).Export(w, filter))
+		},
+	}
+	cmd.Flags().StringVar(&filter.Type, "type", "", "only export events of this type")
+	cmd.Flags().IntVar(&filter.ActorID, "actor", 0, "only export events caused by the user with this ID")
+	cmd.Flags().StringVar(&since, "since", "", "only export events at or after this RFC 3339 time")
+	cmd.Flags().StringVar(&until, "until", "", "only export events before this RFC 3339 time")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write to instead of standard output")
+	return cmd
+}
+
 func must(err error) {
 	if err != nil {
 		trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
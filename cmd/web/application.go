@@ -2,23 +2,72 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/metrics"
+	"github.com/96malhar/snippetbox/internal/realip"
+	"github.com/96malhar/snippetbox/internal/secrets"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/totp"
+	"github.com/96malhar/snippetbox/internal/validation"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"html/template"
 	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type application struct {
-	logger         *slog.Logger
-	snippetStore   snippetStoreInterface
-	userStore      userStoreInterface
-	templateCache  map[string]*template.Template
-	formDecoder    *form.Decoder
-	sessionManager *scs.SessionManager
+	logger           *slog.Logger
+	snippetStore     store.Snippets
+	userStore        store.Users
+	userSessionStore store.UserSessions
+	teamStore        store.Teams
+	moderationStore  store.Moderation
+	auditLog         auditLogInterface
+	templateCache    map[string]*template.Template
+	formDecoder      *form.Decoder
+	sessionManager   *scs.SessionManager
+	datetimeHandler  interface {
+		GetCurrentTimeUTC() time.Time
+	}
+	// oidcProvider is nil unless login with an OpenID Connect provider is configured.
+	oidcProvider          oidcProviderInterface
+	passwordLoginDisabled bool
+	backgroundTasks       []backgroundTask
+	hstsHeader            string
+	// baseURL is the public URL of the server, or empty if it is taken from the
+	// requests.
+	baseURL             string
+	certificate         certificateInterface
+	healthStore         store.Health
+	readinessTimeout    time.Duration
+	sessionTableMaxRows int
+	shutdownDelay       time.Duration
+	shuttingDown        atomic.Bool
+	metrics             *metrics.Metrics
+	tracer              trace.Tracer
+	realIP              *realip.Resolver
+	secretScanner       *secrets.Scanner
+	passwordPolicy      *validation.PasswordPolicy
+	// rateLimiter is nil when rate limiting is turned off.
+	rateLimiter rateLimiterInterface
+	// proofOfWork is nil when anonymous forms don't ask for a proof of work.
+	proofOfWork *validation.ProofOfWork
+	// inFlight is the number of requests being handled, which measures the load
+	// of the server.
+	inFlight atomic.Int64
 }
 
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
@@ -28,19 +77,60 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		trace  = string(debug.Stack())
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri, "trace", trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	attrs := append([]any{"method", method, "uri", uri, "trace", trace}, logAttrs(r.Context())...)
+	app.logger.Error(err.Error(), attrs...)
+
+	// Queries which ran out of time point to an overloaded database rather than
+	// to a bug, so tell clients to try again later.
+	if errors.Is(err, context.DeadlineExceeded) {
+		app.errorPage(w, r, http.StatusServiceUnavailable)
+		return
+	}
+	app.errorPage(w, r, http.StatusInternalServerError)
+}
+
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.errorPage(w, r, status)
+}
+
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusNotFound)
+}
+
+// errorPage writes the status text, followed by the request ID so that users can
+// quote it when reporting the error.
+func (app *application) errorPage(w http.ResponseWriter, r *http.Request, status int) {
+	message := http.StatusText(status)
+	if id := requestIDFromContext(r.Context()); id != "" {
+		message += "\n\nRequest ID: " + id
+	}
+	http.Error(w, message, status)
 }
 
-func (app *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+// requestIDFromContext returns the ID set by the requestID middleware, or an
+// empty string if it didn't run.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
 }
 
-func (app *application) notFound(w http.ResponseWriter) {
-	app.clientError(w, http.StatusNotFound)
+// logAttrs returns the attributes which tie a log line to the request and to its
+// trace.
+func logAttrs(ctx context.Context) []any {
+	var attrs []any
+	if id := requestIDFromContext(ctx); id != "" {
+		attrs = append(attrs, "request_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+	}
+	return attrs
 }
 
 func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data templateData) {
+	_, span := app.tracer.Start(r.Context(), "app.render", trace.WithAttributes(attribute.String("page", page)))
+	defer span.End()
+
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
@@ -52,6 +142,8 @@ func (app *application) render(w http.ResponseWriter, r *http.Request, status in
 
 	err := ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		app.serverError(w, r, err)
 		return
 	}
@@ -97,3 +189,337 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 
 	return isAuthenticated
 }
+
+// authenticatedUser returns the user making the request, or nil if the request
+// is not authenticated.
+func (app *application) authenticatedUser(r *http.Request) *store.User {
+	user, ok := r.Context().Value(authenticatedUserContextKey).(*store.User)
+	if !ok {
+		return nil
+	}
+
+	return user
+}
+
+// startUserSession renews the session token to prevent session fixation, marks the
+// session as belonging to the given user, records it in the user's list of
+// active sessions and audits the successful login.
+func (app *application) startUserSession(r *http.Request, userID int) error {
+	err := app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+
+	token := app.sessionManager.Token(r.Context())
+	err = app.userSessionStore.Insert(userID, token, r.UserAgent(), clientIP(r))
+	if err != nil {
+		return err
+	}
+
+	app.audit(r, audit.EventLoginSuccess, userID, nil)
+	app.metrics.Login(true)
+	return nil
+}
+
+// completeLogin logs in a user whose identity has been established, either by
+// starting an authenticated session right away or, for users with two-factor
+// authentication, by asking for a code first.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, userID int) {
+	user, err := app.userStore.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if user.Disabled {
+		app.audit(r, audit.EventLoginFailure, userID, map[string]any{"reason": "account disabled"})
+		app.metrics.Login(false)
+		app.renderLoginFailed(w, r, http.StatusForbidden, "Your account has been disabled.")
+		return
+	}
+
+	// Remember that the first step succeeded and ask for a code before starting
+	// an authenticated session.
+	if user.TOTPEnabled {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sessionManager.Put(r.Context(), "pendingTwoFactorUserID", userID)
+		app.sessionManager.Put(r.Context(), "pendingTwoFactorSince", app.datetimeHandler.GetCurrentTimeUTC().Unix())
+		app.sessionManager.Remove(r.Context(), "pendingTwoFactorAttempts")
+		http.Redirect(w, r, "/user/login/verify", http.StatusSeeOther)
+		return
+	}
+
+	err = app.startUserSession(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.redirectAfterLogin(w, r)
+}
+
+// The limits of the second step of a login. Once a user has entered too many
+// wrong codes or taken too long, they have to enter their password again.
+const (
+	maxTwoFactorAttempts = 5
+	twoFactorTimeout     = 5 * time.Minute
+)
+
+// pendingTwoFactorUser returns the ID of the user who passed the first step of a
+// login and has yet to enter a code, or 0 if there is none. A second step which
+// timed out is dropped.
+func (app *application) pendingTwoFactorUser(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "pendingTwoFactorUserID")
+	if id == 0 {
+		return 0
+	}
+
+	since := time.Unix(app.sessionManager.GetInt64(r.Context(), "pendingTwoFactorSince"), 0)
+	if app.datetimeHandler.GetCurrentTimeUTC().Sub(since) > twoFactorTimeout {
+		app.dropPendingTwoFactor(r)
+		return 0
+	}
+	return id
+}
+
+// dropPendingTwoFactor forgets the second step of a login.
+func (app *application) dropPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "pendingTwoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "pendingTwoFactorSince")
+	app.sessionManager.Remove(r.Context(), "pendingTwoFactorAttempts")
+}
+
+// useTOTPCode checks a TOTP code of a user and records its time step, so that
+// the code and the codes before it are only ever accepted once.
+func (app *application) useTOTPCode(ctx context.Context, userID int, secret, code string) (bool, error) {
+	counter, ok := totp.Counter(secret, code, app.datetimeHandler.GetCurrentTimeUTC())
+	if !ok {
+		return false, nil
+	}
+	return app.userStore.UseTOTPCounter(ctx, userID, counter)
+}
+
+// checkCredentials returns the id of the user with the given email and password.
+// A failure to replace their outdated password hash is logged rather than
+// returned, as it doesn't stop them from logging in.
+func (app *application) checkCredentials(r *http.Request, email, password string) (int, error) {
+	id, err := app.userStore.Authenticate(r.Context(), email, password)
+	if errors.Is(err, store.ErrRehash) {
+		app.logger.Warn("failed to replace password hash", append([]any{"user", id, "error", err.Error()}, logAttrs(r.Context())...)...)
+		return id, nil
+	}
+	return id, err
+}
+
+// redirectAfterLogin sends a freshly logged-in user to the page they originally
+// asked for, or to the create snippet page if there is none.
+func (app *application) redirectAfterLogin(w http.ResponseWriter, r *http.Request) {
+	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
+	if path != "" {
+		http.Redirect(w, r, path, http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// clientIP returns the IP address of the client which made the request, as found
+// by the resolveClient middleware when it ran.
+func clientIP(r *http.Request) string {
+	if client, ok := r.Context().Value(clientContextKey).(realip.Client); ok {
+		return client.IP
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// isSecure tells whether the client sent the request over HTTPS, either to the
+// server or to the trusted proxy which forwarded it.
+func isSecure(r *http.Request) bool {
+	if client, ok := r.Context().Value(clientContextKey).(realip.Client); ok {
+		return client.Secure
+	}
+	return r.TLS != nil
+}
+
+// absoluteURL returns the URL of path on this server, for links given to users
+// to open later. It starts with the configured base URL, or else with the
+// scheme and host the request was sent to.
+func (app *application) absoluteURL(r *http.Request, path string) string {
+	if app.baseURL != "" {
+		return strings.TrimSuffix(app.baseURL, "/") + path
+	}
+	scheme := "http"
+	if isSecure(r) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// newChallenge returns a proof-of-work challenge for the client of r to solve
+// before submitting a form anonymously, or nil if it doesn't need to.
+func (app *application) newChallenge(r *http.Request) (*validation.Challenge, error) {
+	if app.proofOfWork == nil || app.isAuthenticated(r) {
+		return nil, nil
+	}
+
+	challenge, err := app.proofOfWork.Issue(clientIP(r))
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// checkProofOfWork adds an error to v unless the client of r solved the
+// proof-of-work challenge it was given, when it needed one.
+func (app *application) checkProofOfWork(r *http.Request, v *validation.Validator, challenge, solution string) {
+	if app.proofOfWork == nil || app.isAuthenticated(r) {
+		return
+	}
+
+	err := app.proofOfWork.Verify(clientIP(r), challenge, solution)
+	if err != nil {
+		app.logger.Warn("proof of work rejected", append([]any{"ip", clientIP(r), "error", err.Error()}, logAttrs(r.Context())...)...)
+	}
+
+	switch {
+	case errors.Is(err, validation.ErrChallengeExpired):
+		v.CheckNonField(false, "This form has expired, please submit it again.")
+	case errors.Is(err, validation.ErrChallengeTooEasy):
+		v.CheckNonField(false, "Many forms were submitted recently, please submit this one again.")
+	case err != nil:
+		v.CheckNonField(false, "We couldn't check that you're not a robot. Please make sure JavaScript is enabled and submit the form again.")
+	}
+}
+
+// minPasswordLength is the minimum number of characters of the passwords users
+// choose.
+const minPasswordLength = 8
+
+// passwordHints tell users how to make a password which is too easy to guess
+// stronger, by the pattern which makes it weak.
+var passwordHints = map[validation.Pattern]string{
+	validation.PatternDictionary: "avoid common words, names and passwords, even with capitals, digits or symbols.",
+	validation.PatternSequence:   "avoid sequences like abc or 9876.",
+	validation.PatternRepeat:     "avoid repeats like aaa or abcabc.",
+	validation.PatternKeyboard:   "avoid rows of keys like qwerty or asdf.",
+	validation.PatternDate:       "avoid dates and years.",
+	"":                           "make it longer.",
+}
+
+// checkPassword adds an error for the given field to v unless password meets the
+// password policy. name and email are those of the user choosing the password.
+func (app *application) checkPassword(v *validation.Validator, field, password, name, email string) {
+	err := app.passwordPolicy.Check(password, name, email)
+
+	var weak *validation.WeakPasswordError
+	switch {
+	case err == nil:
+	case errors.Is(err, validation.ErrPasswordTooShort):
+		v.CheckField(false, field, fmt.Sprintf("This field must be at least %d characters long", app.passwordPolicy.MinLength))
+	case errors.Is(err, validation.ErrPasswordPersonal):
+		v.CheckField(false, field, "This password must not contain your name or email address")
+	case errors.Is(err, validation.ErrPasswordBreached):
+		v.CheckField(false, field, "This password has appeared in a data breach, please choose another one")
+	case errors.As(err, &weak):
+		v.CheckField(false, field, "This password is too easy to guess: "+passwordHints[weak.Strength.Pattern])
+	}
+}
+
+// revokeUserSession deletes the scs session data for the given token, which signs
+// out the device holding it, and removes the token from the user's list of
+// active sessions.
+func (app *application) revokeUserSession(token string) error {
+	err := app.sessionManager.Store.Delete(token)
+	if err != nil {
+		return err
+	}
+
+	return app.userSessionStore.Delete(token)
+}
+
+// revokeUserSessions signs a user out of every device, except the one holding
+// the session token except if it isn't empty.
+func (app *application) revokeUserSessions(userID int, except string) error {
+	sessions, err := app.userSessionStore.GetAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, us := range sessions {
+		if us.Token == except {
+			continue
+		}
+
+		err = app.revokeUserSession(us.Token)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audit records a security-relevant event caused by the request in the audit log.
+// actorID is 0 when the event isn't tied to a known user. Failing to record an
+// event is logged but doesn't fail the request.
+func (app *application) audit(r *http.Request, eventType string, actorID int, payload map[string]any) {
+	e := audit.Event{
+		Type:      eventType,
+		ActorID:   actorID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			app.logger.Error("failed to encode audit event payload", "type", eventType, "error", err.Error())
+			return
+		}
+		e.Payload = b
+	}
+
+	err := app.auditLog.Record(e)
+	if err != nil {
+		app.logger.Error("failed to record audit event", "type", eventType, "error", err.Error())
+	}
+}
+
+// snippetTeamRole returns the role of the user making the request in the team of
+// a snippet, or an empty role if the snippet is public or the user isn't a
+// member of its team.
+func (app *application) snippetTeamRole(r *http.Request, snippet *store.Snippet) (store.TeamRole, error) {
+	user := app.authenticatedUser(r)
+	if snippet.TeamID == 0 || user == nil {
+		return "", nil
+	}
+
+	role, err := app.teamStore.Role(snippet.TeamID, user.ID)
+	if err != nil && !errors.Is(err, store.ErrNoRecord) {
+		return "", err
+	}
+	return role, nil
+}
+
+// canViewSnippet returns true if the user making the request may see a snippet.
+// Public snippets are visible to everyone, team snippets only to the members of
+// their team.
+func (app *application) canViewSnippet(r *http.Request, snippet *store.Snippet) (bool, error) {
+	if snippet.TeamID == 0 {
+		return true, nil
+	}
+
+	role, err := app.snippetTeamRole(r, snippet)
+	return role != "", err
+}
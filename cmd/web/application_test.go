@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
@@ -19,6 +20,14 @@ func TestApplication_ServerError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
 }
 
+func TestApplication_ServerError_Timeout(t *testing.T) {
+	app := newTestApplication(t)
+	rr := httptest.NewRecorder()
+	app.serverError(rr, NewTestRequest(), fmt.Errorf("get snippet: %w", context.DeadlineExceeded))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
 func TestApplication_ClientError(t *testing.T) {
 	statusCodes := []int{http.StatusBadRequest, http.StatusUnprocessableEntity}
 	app := newTestApplication(t)
@@ -26,7 +35,7 @@ func TestApplication_ClientError(t *testing.T) {
 	for _, code := range statusCodes {
 		t.Run(http.StatusText(code), func(t *testing.T) {
 			rr := httptest.NewRecorder()
-			app.clientError(rr, code)
+			app.clientError(rr, NewTestRequest(), code)
 
 			assert.Equal(t, code, rr.Result().StatusCode)
 		})
@@ -36,7 +45,7 @@ func TestApplication_ClientError(t *testing.T) {
 func TestApplication_NotFound(t *testing.T) {
 	app := newTestApplication(t)
 	rr := httptest.NewRecorder()
-	app.notFound(rr)
+	app.notFound(rr, NewTestRequest())
 
 	assert.Equal(t, http.StatusNotFound, rr.Code)
 }
@@ -116,6 +125,41 @@ func TestApplication_IsAuthenticated(t *testing.T) {
 	}
 }
 
+func TestApplication_AbsoluteURL(t *testing.T) {
+	testcases := []struct {
+		name    string
+		baseURL string
+		target  string
+		want    string
+	}{
+		{
+			name:   "HTTPS request",
+			target: "https://example.org/team/platform-team/invite",
+			want:   "https://example.org/teams/join/token",
+		},
+		{
+			name:   "Plain HTTP request",
+			target: "http://localhost:4000/team/platform-team/invite",
+			want:   "http://localhost:4000/teams/join/token",
+		},
+		{
+			name:    "Base URL",
+			baseURL: "https://snippetbox.example.com/",
+			target:  "http://10.0.0.1:4000/team/platform-team/invite",
+			want:    "https://snippetbox.example.com/teams/join/token",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.baseURL = tc.baseURL
+			req := httptest.NewRequest(http.MethodPost, tc.target, nil)
+			assert.Equal(t, tc.want, app.absoluteURL(req, "/teams/join/token"))
+		})
+	}
+}
+
 func TestApplication_DecodePostForm(t *testing.T) {
 	app := newTestApplication(t)
 
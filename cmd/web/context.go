@@ -2,4 +2,10 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+const (
+	isAuthenticatedContextKey   = contextKey("isAuthenticated")
+	authenticatedUserContextKey = contextKey("authenticatedUser")
+	requestIDContextKey         = contextKey("requestID")
+	accessLogContextKey         = contextKey("accessLog")
+	clientContextKey            = contextKey("client")
+)
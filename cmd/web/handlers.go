@@ -1,27 +1,59 @@
 package main
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/oidc"
+	"github.com/96malhar/snippetbox/internal/secrets"
 	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/totp"
 	"github.com/96malhar/snippetbox/internal/validation"
 	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// totpIssuer is the name authenticator apps show next to the codes for Snippetbox.
+const totpIssuer = "Snippetbox"
+
 type snippetCreateForm struct {
 	Title                string `form:"title"`
 	Content              string `form:"content"`
 	Expires              int    `form:"expires"`
+	Team                 int    `form:"team"`
+	Secrets              string `form:"secrets"`
+	SecretsFound         bool   `form:"-"`
+	validation.Validator `form:"-"`
+}
+
+type snippetEditForm struct {
+	Title                string `form:"title"`
+	Content              string `form:"content"`
+	Secrets              string `form:"secrets"`
+	SecretsFound         bool   `form:"-"`
 	validation.Validator `form:"-"`
 }
 
+// What users can choose to do with the secrets found in a snippet, once they
+// have been warned about them.
+const (
+	secretsPublish = "publish"
+	secretsRedact  = "redact"
+)
+
 // Create a new userSignupForm struct.
 type userSignupForm struct {
 	Name                 string `form:"name"`
 	Email                string `form:"email"`
 	Password             string `form:"password"`
+	Challenge            string `form:"pow_challenge"`
+	Solution             string `form:"pow_solution"`
 	validation.Validator `form:"-"`
 }
 
@@ -32,8 +64,98 @@ type userLoginForm struct {
 	validation.Validator `form:"-"`
 }
 
+type twoFactorForm struct {
+	Code                 string `form:"code"`
+	validation.Validator `form:"-"`
+}
+
+type passwordChangeForm struct {
+	CurrentPassword      string `form:"current_password"`
+	NewPassword          string `form:"new_password"`
+	Confirmation         string `form:"confirmation"`
+	validation.Validator `form:"-"`
+}
+
+type passwordResetForm struct {
+	NewPassword          string `form:"new_password"`
+	Confirmation         string `form:"confirmation"`
+	validation.Validator `form:"-"`
+}
+
+type sessionRevokeForm struct {
+	ID int `form:"id"`
+}
+
+type adminUserForm struct {
+	ID   int    `form:"id"`
+	Role string `form:"role"`
+}
+
+type adminSnippetForm struct {
+	ID int `form:"id"`
+}
+
+type snippetReportForm struct {
+	Reason               string `form:"reason"`
+	Details              string `form:"details"`
+	Challenge            string `form:"pow_challenge"`
+	Solution             string `form:"pow_solution"`
+	validation.Validator `form:"-"`
+}
+
+type teamCreateForm struct {
+	Name                 string `form:"name"`
+	validation.Validator `form:"-"`
+}
+
+type teamInviteForm struct {
+	Email                string `form:"email"`
+	validation.Validator `form:"-"`
+}
+
+type teamInviteAcceptForm struct {
+	ID int `form:"id"`
+}
+
+type teamMemberForm struct {
+	UserID int    `form:"user_id"`
+	Role   string `form:"role"`
+}
+
+type moderationForm struct {
+	SnippetID int `form:"snippet_id"`
+}
+
+// reportReasons are the reasons a visitor can pick from when reporting a snippet.
+var reportReasons = []string{"spam", "secret", "abuse", "other"}
+
+// moderationPageSize is the maximum number of past actions shown in the
+// moderation queue.
+const moderationPageSize = 20
+
+type auditFilterForm struct {
+	Type                 string `form:"type"`
+	ActorID              int    `form:"actor"`
+	Since                string `form:"since"`
+	Until                string `form:"until"`
+	validation.Validator `form:"-"`
+}
+
+// auditDateLayout is the format of the dates the audit log can be filtered by.
+const auditDateLayout = "2006-01-02"
+
+// adminStats holds the counts shown on the admin dashboard.
+type adminStats struct {
+	Users          int
+	Snippets       int
+	ActiveSnippets int
+}
+
+// adminPageSize is the maximum number of rows shown on the admin list pages.
+const adminPageSize = 50
+
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippetStore.Latest()
+	snippets, err := app.snippetStore.Latest(r.Context())
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -49,35 +171,188 @@ func (app *application) about(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "about.tmpl", data)
 }
 
-func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+// snippetFromURL returns the snippet with the ID in the URL of the request. It
+// sends a 404 response and returns false if there is no such snippet or it
+// belongs to a team which the user making the request isn't a member of.
+func (app *application) snippetFromURL(w http.ResponseWriter, r *http.Request) (*store.Snippet, bool) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || id < 1 {
-		app.notFound(w)
-		return
+		app.notFound(w, r)
+		return nil, false
 	}
 
-	snippet, err := app.snippetStore.Get(id)
+	snippet, err := app.snippetStore.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, store.ErrNoRecord) {
-			app.notFound(w)
+			app.notFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
+		return nil, false
+	}
+
+	ok, err := app.canViewSnippet(r, snippet)
+	if err != nil {
+		app.serverError(w, r, err)
+		return nil, false
+	}
+	if !ok {
+		app.notFound(w, r)
+		return nil, false
+	}
+
+	return snippet, true
+}
+
+func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
 		return
 	}
 
 	data := app.newTemplateData(r)
+
+	// Hidden snippets are only visible to moderators, who need to see what
+	// they took down.
+	if snippet.Hidden && !data.IsModerator {
+		app.notFound(w, r)
+		return
+	}
+
+	if snippet.TeamID != 0 {
+		team, err := app.teamStore.Get(snippet.TeamID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Team = team
+		// Only members can see team snippets, and all of them can edit them.
+		data.CanEditSnippet = !snippet.Hidden
+	}
+
+	challenge, err := app.newChallenge(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data.Snippet = snippet
+	data.Form = snippetReportForm{}
+	data.Challenge = challenge
 	app.render(w, r, http.StatusOK, "view.tmpl", data)
 }
 
+func (app *application) snippetReportPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+	if snippet.Hidden {
+		app.notFound(w, r)
+		return
+	}
+	id := snippet.ID
+
+	var form snippetReportForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	app.checkProofOfWork(r, &form.Validator, form.Challenge, form.Solution)
+	form.CheckField(validation.PermittedValue(form.Reason, reportReasons...), "reason", "Please choose a reason")
+	form.CheckField(validation.MaxChars(form.Details, 500), "details", "This field cannot be more than 500 characters long")
+
+	if !form.Valid() {
+		challenge, err := app.newChallenge(r)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		data.Challenge = challenge
+		app.render(w, r, http.StatusUnprocessableEntity, "view.tmpl", data)
+		return
+	}
+
+	err = app.moderationStore.Report(id, form.Reason, form.Details)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Thanks, a moderator will review your report.")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
+}
+
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
-	data := app.newTemplateData(r)
-	data.Form = snippetCreateForm{
+	app.renderSnippetCreate(w, r, http.StatusOK, snippetCreateForm{
 		Expires: 365,
+	})
+}
+
+// renderSnippetCreate renders the create snippet page, which offers the teams of
+// the user as visibilities.
+func (app *application) renderSnippetCreate(w http.ResponseWriter, r *http.Request, status int, form snippetCreateForm) {
+	teams, err := app.teamStore.ForUser(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.Teams = teams
+	app.render(w, r, status, "create.tmpl", data)
+}
+
+// checkSecrets scans the content of a snippet for secrets. Unless the user chose
+// to publish them anyway or to redact them, the secrets found are reported as an
+// error of the content field. It returns the content to save, and whether
+// secrets were reported.
+func (app *application) checkSecrets(v *validation.Validator, content, choice string) (string, bool) {
+	findings := app.secretScanner.Scan(content)
+	switch {
+	case len(findings) == 0 || choice == secretsPublish:
+		return content, false
+	case choice == secretsRedact:
+		return secrets.Redact(content, findings), false
+	}
+
+	v.CheckField(false, "content", secretsWarning(findings))
+	return content, true
+}
+
+// secretsWarning tells which kinds of secrets were found on which lines.
+func secretsWarning(findings []secrets.Finding) string {
+	var kinds []string
+	lines := map[string][]string{}
+	for _, f := range findings {
+		if _, ok := lines[f.Description]; !ok {
+			kinds = append(kinds, f.Description)
+		}
+		lines[f.Description] = append(lines[f.Description], strconv.Itoa(f.Line))
 	}
 
-	app.render(w, r, http.StatusOK, "create.tmpl", data)
+	found := make([]string, len(kinds))
+	for i, kind := range kinds {
+		if len(lines[kind]) == 1 {
+			found[i] = fmt.Sprintf("%s on line %s", kind, lines[kind][0])
+		} else {
+			found[i] = fmt.Sprintf("%s on lines %s", kind, strings.Join(lines[kind], ", "))
+		}
+	}
+	return "This looks like it contains secrets: " + strings.Join(found, "; ") +
+		". Remove them, or choose to redact them or to publish anyway."
 }
 
 func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request) {
@@ -85,36 +360,142 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
+	userID := app.authenticatedUser(r).ID
+
 	form.CheckField(validation.NotBlank(form.Title), "title", "This field cannot be blank")
 	form.CheckField(validation.NotBlank(form.Content), "content", "This field cannot be blank")
 	form.CheckField(validation.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
 	form.CheckField(validation.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1, 7 or 365")
+	form.Content, form.SecretsFound = app.checkSecrets(&form.Validator, form.Content, form.Secrets)
+
+	if form.Team != 0 {
+		_, err = app.teamStore.Role(form.Team, userID)
+		if err != nil && !errors.Is(err, store.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err == nil, "team", "You can only share snippets with your own teams")
+	}
 
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl", data)
+		app.renderSnippetCreate(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
 
-	id, err := app.snippetStore.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippetStore.Insert(r.Context(), form.Title, form.Content, form.Expires, form.Team)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	payload := map[string]any{"snippet_id": id}
+	if form.Team != 0 {
+		payload["team_id"] = form.Team
+	}
+	app.audit(r, audit.EventSnippetCreate, userID, payload)
+
 	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+// editableSnippet returns the snippet with the ID in the URL if the user making
+// the request may edit it. Only team snippets can be edited, by any member of
+// their team.
+func (app *application) editableSnippet(w http.ResponseWriter, r *http.Request) (*store.Snippet, bool) {
+	snippet, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+	if snippet.Hidden {
+		app.notFound(w, r)
+		return nil, false
+	}
+	if snippet.TeamID == 0 {
+		app.clientError(w, r, http.StatusForbidden)
+		return nil, false
+	}
+	return snippet, true
+}
+
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = snippetEditForm{
+		Title:   snippet.Title,
+		Content: snippet.Content,
+	}
+	app.render(w, r, http.StatusOK, "edit.tmpl", data)
+}
+
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.editableSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetEditForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validation.NotBlank(form.Title), "title", "This field cannot be blank")
+	form.CheckField(validation.NotBlank(form.Content), "content", "This field cannot be blank")
+	form.CheckField(validation.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.Content, form.SecretsFound = app.checkSecrets(&form.Validator, form.Content, form.Secrets)
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.tmpl", data)
+		return
+	}
+
+	err = app.snippetStore.Update(r.Context(), snippet.ID, form.Title, form.Content)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, audit.EventSnippetUpdate, app.authenticatedUser(r).ID, map[string]any{"snippet_id": snippet.ID, "team_id": snippet.TeamID})
+
+	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully updated!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
+	app.renderSignup(w, r, http.StatusOK, userSignupForm{})
+}
+
+// renderSignup renders the signup page, with a new proof-of-work challenge since
+// the previous one can't be used again.
+func (app *application) renderSignup(w http.ResponseWriter, r *http.Request, status int, form userSignupForm) {
+	challenge, err := app.newChallenge(r)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
-	data.Form = userSignupForm{}
-	app.render(w, r, http.StatusOK, "signup.tmpl", data)
+	data.Form = form
+	data.Challenge = challenge
+	app.render(w, r, status, "signup.tmpl", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
@@ -122,41 +503,40 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
 	// Validate the form contents using our helper functions.
+	app.checkProofOfWork(r, &form.Validator, form.Challenge, form.Solution)
 	form.CheckField(validation.NotBlank(form.Name), "name", "This field cannot be blank")
 	form.CheckField(validation.NotBlank(form.Email), "email", "This field cannot be blank")
 	form.CheckField(validation.Matches(form.Email, validation.EmailRX), "email", "This field must be a valid email address")
 	form.CheckField(validation.NotBlank(form.Password), "password", "This field cannot be blank")
-	form.CheckField(validation.MinChars(form.Password, 8), "password", "This field must be at least 8 characters long")
+	app.checkPassword(&form.Validator, "password", form.Password, form.Name, form.Email)
 
 	// If there are any errors, redisplay the signup form along with a 422
 	// status code.
 	if !form.Valid() {
-		data := app.newTemplateData(r)
-		data.Form = form
-		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
+		app.renderSignup(w, r, http.StatusUnprocessableEntity, form)
 		return
 	}
 
 	// Try to create a new user record in the database. If the email already
 	// exists then add an error message to the form and re-display it.
-	err = app.userStore.Insert(form.Name, form.Email, form.Password)
+	id, err := app.userStore.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, store.ErrDuplicateEmail) {
 			form.CheckField(false, "email", "Email address is already in use")
-			data := app.newTemplateData(r)
-			data.Form = form
-			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl", data)
+			app.renderSignup(w, r, http.StatusUnprocessableEntity, form)
 			return
 		}
 		app.serverError(w, r, err)
 		return
 	}
 
+	app.audit(r, audit.EventSignup, id, map[string]any{"email": form.Email})
+
 	app.sessionManager.Put(r.Context(), "flash", "Your signup was successful. Please log in.")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
@@ -172,7 +552,7 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 	err := app.decodePostForm(r, &form)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
@@ -187,9 +567,11 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := app.userStore.Authenticate(form.Email, form.Password)
+	id, err := app.checkCredentials(r, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, store.ErrInvalidCredentials) {
+			app.audit(r, audit.EventLoginFailure, 0, map[string]any{"email": form.Email, "reason": "invalid credentials"})
+			app.metrics.Login(false)
 			form.CheckNonField(false, "Email or password is incorrect")
 			data := app.newTemplateData(r)
 			data.Form = form
@@ -200,54 +582,1219 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.sessionManager.RenewToken(r.Context())
+	app.completeLogin(w, r, id)
+}
+
+// userPasswordReset shows the form to choose a new password to the holder of a
+// reset link created by an admin.
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	_, err := app.userStore.PasswordResetUser(r.Context(), token)
 	if err != nil {
-		app.serverError(w, r, err)
+		app.invalidPasswordReset(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = passwordResetForm{}
+	data.PasswordResetLink = "/user/reset/" + token
+	app.render(w, r, http.StatusOK, "reset.tmpl", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	user, err := app.userStore.PasswordResetUser(r.Context(), token)
+	if err != nil {
+		app.invalidPasswordReset(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	var form passwordResetForm
 
-	path := app.sessionManager.PopString(r.Context(), "redirectPathAfterLogin")
-	if path != "" {
-		http.Redirect(w, r, path, http.StatusSeeOther)
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
-}
+	form.CheckField(validation.NotBlank(form.NewPassword), "new_password", "This field cannot be blank")
+	app.checkPassword(&form.Validator, "new_password", form.NewPassword, user.Name, user.Email)
+	form.CheckField(form.Confirmation == form.NewPassword, "confirmation", "The passwords don't match")
 
-func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
-	err := app.sessionManager.RenewToken(r.Context())
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.PasswordResetLink = "/user/reset/" + token
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl", data)
+		return
+	}
+
+	userID, err := app.userStore.ResetPassword(r.Context(), token, form.NewPassword)
+	if err != nil {
+		app.invalidPasswordReset(w, r, err)
+		return
+	}
+
+	// Whoever knew the old password may still be signed in somewhere.
+	err = app.revokeUserSessions(userID, "")
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	app.audit(r, audit.EventPasswordChange, userID, map[string]any{"reset": true})
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed. Please log in.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func ping(w http.ResponseWriter, r *http.Request) {
-	w.Write([]byte("OK"))
+// invalidPasswordReset tells the user that their reset link can't be used, or
+// reports err if it isn't about the link.
+func (app *application) invalidPasswordReset(w http.ResponseWriter, r *http.Request, err error) {
+	if !errors.Is(err, store.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "This password reset link has expired or has already been used.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
-	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+// userLoginOIDC sends the user to the OpenID Connect provider to log in. The state,
+// nonce and PKCE code verifier are kept in the session to check the response.
+func (app *application) userLoginOIDC(w http.ResponseWriter, r *http.Request) {
+	if app.oidcProvider == nil {
+		app.notFound(w, r)
+		return
+	}
 
-	user, err := app.userStore.Get(userID)
+	state, err := oidc.RandomString()
 	if err != nil {
-		if errors.Is(err, store.ErrNoRecord) {
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-		} else {
-			app.serverError(w, r, err)
-		}
+		app.serverError(w, r, err)
 		return
 	}
 
-	data := app.newTemplateData(r)
-	data.User = user
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
-	app.render(w, r, http.StatusOK, "account.tmpl", data)
+	verifier := oidc.GenerateVerifier()
+
+	app.sessionManager.Put(r.Context(), "oidcState", state)
+	app.sessionManager.Put(r.Context(), "oidcNonce", nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", verifier)
+
+	http.Redirect(w, r, app.oidcProvider.AuthCodeURL(state, nonce, verifier), http.StatusSeeOther)
+}
+
+func (app *application) userLoginOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidcProvider == nil {
+		app.notFound(w, r)
+		return
+	}
+
+	state := app.sessionManager.PopString(r.Context(), "oidcState")
+	nonce := app.sessionManager.PopString(r.Context(), "oidcNonce")
+	verifier := app.sessionManager.PopString(r.Context(), "oidcVerifier")
+
+	query := r.URL.Query()
+	if state == "" || query.Get("state") != state {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("error") != "" {
+		app.logger.Warn("OIDC login failed", "error", query.Get("error"), "description", query.Get("error_description"))
+		app.audit(r, audit.EventLoginFailure, 0, map[string]any{"method": "oidc", "reason": query.Get("error")})
+		app.metrics.Login(false)
+		app.renderLoginFailed(w, r, http.StatusUnauthorized, "Single sign-on failed. Please try again.")
+		return
+	}
+
+	identity, err := app.oidcProvider.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		app.logger.Warn("OIDC login failed", "error", err.Error())
+		app.audit(r, audit.EventLoginFailure, 0, map[string]any{"method": "oidc", "reason": err.Error()})
+		app.metrics.Login(false)
+		if errors.Is(err, oidc.ErrEmailNotVerified) {
+			app.renderLoginFailed(w, r, http.StatusUnauthorized, "Your identity provider hasn't verified your email address.")
+		} else {
+			app.renderLoginFailed(w, r, http.StatusUnauthorized, "Single sign-on failed. Please try again.")
+		}
+		return
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = identity.Email
+	}
+
+	id, err := app.userStore.ProvisionIdentity(r.Context(), identity.Issuer, identity.Subject, name, identity.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, id)
+}
+
+// renderLoginFailed re-displays the login page with an error which isn't caused by
+// the contents of the login form.
+func (app *application) renderLoginFailed(w http.ResponseWriter, r *http.Request, status int, message string) {
+	form := userLoginForm{}
+	form.CheckNonField(false, message)
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	app.render(w, r, status, "login.tmpl", data)
+}
+
+func (app *application) userLoginVerify(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUser(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = twoFactorForm{}
+	app.render(w, r, http.StatusOK, "loginverify.tmpl", data)
+}
+
+// userLoginVerifyPost completes the login of a user with two-factor authentication
+// by checking either a TOTP code or one of their recovery codes. After too many
+// wrong codes the user is sent back to the login page.
+func (app *application) userLoginVerifyPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUser(r)
+	if id == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validation.NotBlank(form.Code), "code", "This field cannot be blank")
+
+	if form.Valid() {
+		user, err := app.userStore.Get(r.Context(), id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		ok, err := app.useTOTPCode(r.Context(), id, user.TOTPSecret, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if !ok {
+			ok, err = app.userStore.UseRecoveryCode(r.Context(), id, totp.NormalizeRecoveryCode(form.Code))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+		}
+		form.CheckNonField(ok, "Authentication code is incorrect")
+		if !ok {
+			app.audit(r, audit.EventLoginFailure, id, map[string]any{"reason": "incorrect two-factor code"})
+			app.metrics.Login(false)
+
+			attempts := app.sessionManager.GetInt(r.Context(), "pendingTwoFactorAttempts") + 1
+			if attempts >= maxTwoFactorAttempts {
+				app.dropPendingTwoFactor(r)
+				app.sessionManager.Put(r.Context(), "flash", "Too many incorrect codes. Please log in again.")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+			app.sessionManager.Put(r.Context(), "pendingTwoFactorAttempts", attempts)
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "loginverify.tmpl", data)
+		return
+	}
+
+	app.dropPendingTwoFactor(r)
+
+	err = app.startUserSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.redirectAfterLogin(w, r)
+}
+
+func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	err := app.userSessionStore.Delete(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, audit.EventLogout, app.sessionManager.GetInt(r.Context(), "authenticatedUserID"), nil)
+
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+	app.sessionManager.Put(r.Context(), "flash", "You've been logged out successfully!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func ping(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("OK"))
+}
+
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.userStore.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+
+	app.render(w, r, http.StatusOK, "account.tmpl", data)
+}
+
+func (app *application) accountSessions(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	sessions, err := app.userSessionStore.GetAllForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.UserSessions = sessions
+	token := app.sessionManager.Token(r.Context())
+	for _, us := range sessions {
+		if us.Token == token {
+			data.CurrentUserSessionID = us.ID
+		}
+	}
+
+	app.render(w, r, http.StatusOK, "sessions.tmpl", data)
+}
+
+// accountSessionRevokePost signs a single device of the user out by deleting its
+// scs session token.
+func (app *application) accountSessionRevokePost(w http.ResponseWriter, r *http.Request) {
+	var form sessionRevokeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	session, err := app.userSessionStore.Get(form.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Don't reveal the existence of sessions which belong to other users.
+	if session.UserID != userID {
+		app.notFound(w, r)
+		return
+	}
+
+	// Signing out the current device is the same as logging out.
+	if session.Token == app.sessionManager.Token(r.Context()) {
+		app.userLogoutPost(w, r)
+		return
+	}
+
+	err = app.revokeUserSession(session.Token)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The device has been signed out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+// accountSessionsRevokeOthersPost signs the user out of every device except the
+// one making the request.
+func (app *application) accountSessionsRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	err := app.revokeUserSessions(userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "All other devices have been signed out.")
+	http.Redirect(w, r, "/account/sessions", http.StatusSeeOther)
+}
+
+func (app *application) accountPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = passwordChangeForm{}
+	app.render(w, r, http.StatusOK, "password.tmpl", data)
+}
+
+// accountPasswordPost changes the password of the user, who has to confirm it's
+// them with their current password, and signs them out of every other device.
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form passwordChangeForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	user := app.authenticatedUser(r)
+
+	form.CheckField(validation.NotBlank(form.CurrentPassword), "current_password", "This field cannot be blank")
+	form.CheckField(validation.NotBlank(form.NewPassword), "new_password", "This field cannot be blank")
+	app.checkPassword(&form.Validator, "new_password", form.NewPassword, user.Name, user.Email)
+	form.CheckField(form.Confirmation == form.NewPassword, "confirmation", "The passwords don't match")
+
+	if form.Valid() {
+		_, err = app.checkCredentials(r, user.Email, form.CurrentPassword)
+		if err != nil {
+			if !errors.Is(err, store.ErrInvalidCredentials) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.CheckField(false, "current_password", "Password is incorrect")
+		}
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl", data)
+		return
+	}
+
+	err = app.userStore.UpdatePassword(r.Context(), user.ID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.revokeUserSessions(user.ID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.audit(r, audit.EventPasswordChange, user.ID, nil)
+
+	app.sessionManager.Put(r.Context(), "flash", "Your password has been changed and your other devices have been signed out.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactor(w, r, http.StatusOK, twoFactorForm{})
+}
+
+// renderTwoFactor shows the two-factor authentication settings of the user. Users
+// without two-factor authentication are shown a QR code for a new secret, which
+// is kept in the session until they confirm it with a valid code.
+func (app *application) renderTwoFactor(w http.ResponseWriter, r *http.Request, status int, form twoFactorForm) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.userStore.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.Form = form
+
+	if !user.TOTPEnabled {
+		secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+		if secret == "" {
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			app.sessionManager.Put(r.Context(), "pendingTOTPSecret", secret)
+		}
+
+		png, err := qrcode.Encode(totp.URI(totpIssuer, user.Email, secret), qrcode.Medium, 256)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data.TOTPSecret = secret
+		data.TOTPQRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	app.render(w, r, status, "twofactor.tmpl", data)
+}
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	secret := app.sessionManager.GetString(r.Context(), "pendingTOTPSecret")
+	if secret == "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	form.CheckField(validation.NotBlank(form.Code), "code", "This field cannot be blank")
+	counter, ok := totp.Counter(secret, form.Code, app.datetimeHandler.GetCurrentTimeUTC())
+	form.CheckField(ok, "code", "Authentication code is incorrect")
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes(10)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.userStore.EnableTOTP(r.Context(), userID, secret, recoveryCodes)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The code just entered can't be used to log in too.
+	_, err = app.userStore.UseTOTPCounter(r.Context(), userID, counter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "pendingTOTPSecret")
+
+	// The recovery codes are rendered directly instead of after a redirect so
+	// that they never have to be stored anywhere in plain text.
+	data := app.newTemplateData(r)
+	data.RecoveryCodes = recoveryCodes
+	app.render(w, r, http.StatusOK, "recoverycodes.tmpl", data)
+}
+
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form twoFactorForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+
+	user, err := app.userStore.Get(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validation.NotBlank(form.Code), "code", "This field cannot be blank")
+	if form.Valid() {
+		ok, err := app.useTOTPCode(r.Context(), userID, user.TOTPSecret, form.Code)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(ok, "code", "Authentication code is incorrect")
+	}
+
+	if !form.Valid() {
+		app.renderTwoFactor(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	err = app.userStore.DisableTOTP(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Two-factor authentication has been disabled.")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	var stats adminStats
+	var err error
+
+	stats.Users, err = app.userStore.Count(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	stats.Snippets, stats.ActiveSnippets, err = app.snippetStore.Count(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.AdminStats = stats
+	app.render(w, r, http.StatusOK, "admin.tmpl", data)
+}
+
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	app.renderAdminUsers(w, r, r.URL.Query().Get("q"), "")
+}
+
+// renderAdminUsers renders the users matching query, along with the password
+// reset link the admin just created, if any.
+func (app *application) renderAdminUsers(w http.ResponseWriter, r *http.Request, query, resetLink string) {
+	users, err := app.userStore.Search(r.Context(), query, adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Users = users
+	data.Query = query
+	data.PasswordResetLink = resetLink
+	app.render(w, r, http.StatusOK, "adminusers.tmpl", data)
+}
+
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if form.ID == app.authenticatedUser(r).ID {
+		app.sessionManager.Put(r.Context(), "flash", "You can't disable your own account.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.userStore.SetDisabled(r.Context(), form.ID, true)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// Disabled users are no longer authenticated anyway, but signing them out
+	// everywhere cleans up their sessions right away.
+	err = app.revokeUserSessions(form.ID, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The account has been disabled.")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.userStore.SetDisabled(r.Context(), form.ID, false)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The account has been enabled.")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	role := store.Role(form.Role)
+	if !role.Valid() {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// Stop admins from locking themselves out of the admin console.
+	if form.ID == app.authenticatedUser(r).ID {
+		app.sessionManager.Put(r.Context(), "flash", "You can't change your own role.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.userStore.SetRole(r.Context(), form.ID, role)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "The role has been changed.")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// adminUserResetPasswordPost creates a link for a user to choose a new password
+// with. There is no mailer, so the admin has to pass it on to them.
+func (app *application) adminUserResetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form adminUserForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.userStore.CreatePasswordReset(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, audit.EventPasswordReset, app.authenticatedUser(r).ID, map[string]any{"user_id": form.ID})
+
+	// The link is only shown once, because only a hash of its token is kept.
+	link := app.absoluteURL(r, "/user/reset/"+token)
+	app.renderAdminUsers(w, r, "", link)
+}
+
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	snippets, err := app.snippetStore.List(r.Context(), adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+	app.render(w, r, http.StatusOK, "adminsnippets.tmpl", data)
+}
+
+func (app *application) adminSnippetExpirePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippetStore.Expire(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, audit.EventSnippetUpdate, app.authenticatedUser(r).ID, map[string]any{"snippet_id": form.ID, "expired": true})
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been expired.", form.ID))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (app *application) adminSnippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	var form adminSnippetForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = app.snippetStore.Delete(r.Context(), form.ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.audit(r, audit.EventSnippetDelete, app.authenticatedUser(r).ID, map[string]any{"snippet_id": form.ID})
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Snippet #%d has been deleted.", form.ID))
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
+
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	var form auditFilterForm
+
+	err := app.formDecoder.Decode(&form, r.URL.Query())
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	filter := audit.Filter{Type: form.Type, ActorID: form.ActorID}
+
+	form.CheckField(form.Type == "" || validation.PermittedValue(form.Type, audit.EventTypes...), "type", "Unknown event type")
+	if form.Since != "" {
+		filter.Since, err = time.Parse(auditDateLayout, form.Since)
+		form.CheckField(err == nil, "since", "This field must be a date like 2024-01-31")
+	}
+	if form.Until != "" {
+		filter.Until, err = time.Parse(auditDateLayout, form.Until)
+		form.CheckField(err == nil, "until", "This field must be a date like 2024-01-31")
+		// Include the whole of the last day.
+		filter.Until = filter.Until.AddDate(0, 0, 1)
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = form
+	data.EventTypes = audit.EventTypes
+
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "adminaudit.tmpl", data)
+		return
+	}
+
+	data.AuditEvents, err = app.auditLog.List(filter, adminPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.render(w, r, http.StatusOK, "adminaudit.tmpl", data)
+}
+
+func (app *application) teams(w http.ResponseWriter, r *http.Request) {
+	app.renderTeams(w, r, http.StatusOK, teamCreateForm{})
+}
+
+// renderTeams renders the list of the teams of the user, along with the invites
+// addressed to them and the form to create a team.
+func (app *application) renderTeams(w http.ResponseWriter, r *http.Request, status int, form teamCreateForm) {
+	userID := app.authenticatedUser(r).ID
+
+	teams, err := app.teamStore.ForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	invites, err := app.teamStore.InvitesForUser(userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Teams = teams
+	data.TeamInvites = invites
+	data.Form = form
+	app.render(w, r, status, "teams.tmpl", data)
+}
+
+func (app *application) teamCreatePost(w http.ResponseWriter, r *http.Request) {
+	var form teamCreateForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validation.NotBlank(form.Name), "name", "This field cannot be blank")
+	form.CheckField(validation.MaxChars(form.Name, 100), "name", "This field cannot be more than 100 characters long")
+	form.CheckField(!validation.NotBlank(form.Name) || store.TeamSlug(form.Name) != "", "name", "This field must contain letters or digits")
+
+	if !form.Valid() {
+		app.renderTeams(w, r, http.StatusUnprocessableEntity, form)
+		return
+	}
+
+	team, err := app.teamStore.Create(form.Name, app.authenticatedUser(r).ID)
+	if err != nil {
+		if errors.Is(err, store.ErrDuplicateTeam) {
+			form.CheckField(false, "name", "A team with this name already exists")
+			app.renderTeams(w, r, http.StatusUnprocessableEntity, form)
+			return
+		}
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your team has been created.")
+	http.Redirect(w, r, "/team/"+team.Slug, http.StatusSeeOther)
+}
+
+// teamFromURL returns the team with the slug in the URL of the request and the
+// role of the user making the request in it. It sends a 404 response and
+// returns false if there is no such team or the user isn't a member of it.
+func (app *application) teamFromURL(w http.ResponseWriter, r *http.Request) (*store.Team, store.TeamRole, bool) {
+	team, err := app.teamStore.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	role, err := app.teamStore.Role(team.ID, app.authenticatedUser(r).ID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, "", false
+	}
+
+	return team, role, true
+}
+
+func (app *application) teamView(w http.ResponseWriter, r *http.Request) {
+	team, role, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.renderTeam(w, r, http.StatusOK, team, role, teamInviteForm{}, "")
+}
+
+// renderTeam renders the page of a team with its members and snippets. Owners
+// also see the pending invites, the form to invite someone and the link of the
+// invite they just created, if any.
+func (app *application) renderTeam(w http.ResponseWriter, r *http.Request, status int, team *store.Team, role store.TeamRole, form teamInviteForm, inviteLink string) {
+	members, err := app.teamStore.Members(team.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	snippets, err := app.snippetStore.ListForTeam(r.Context(), team.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Team = team
+	data.TeamMembers = members
+	data.Snippets = snippets
+	data.IsTeamOwner = role == store.TeamRoleOwner
+	data.User = app.authenticatedUser(r)
+	data.Form = form
+	data.InviteLink = inviteLink
+
+	if data.IsTeamOwner {
+		data.TeamInvites, err = app.teamStore.PendingInvites(team.ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.render(w, r, status, "team.tmpl", data)
+}
+
+func (app *application) teamInvitePost(w http.ResponseWriter, r *http.Request) {
+	team, role, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+	if role != store.TeamRoleOwner {
+		app.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	var form teamInviteForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(form.Email == "" || validation.Matches(form.Email, validation.EmailRX), "email", "This field must be a valid email address")
+
+	if !form.Valid() {
+		app.renderTeam(w, r, http.StatusUnprocessableEntity, team, role, form, "")
+		return
+	}
+
+	token, err := app.teamStore.Invite(team.ID, form.Email, app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// The link is only shown once, because only a hash of its token is kept.
+	link := app.absoluteURL(r, "/teams/join/"+token)
+	app.renderTeam(w, r, http.StatusOK, team, role, teamInviteForm{}, link)
+}
+
+func (app *application) teamLeavePost(w http.ResponseWriter, r *http.Request) {
+	team, _, ok := app.teamFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.teamStore.RemoveMember(team.ID, app.authenticatedUser(r).ID)
+	if err != nil {
+		if errors.Is(err, store.ErrLastOwner) {
+			app.sessionManager.Put(r.Context(), "flash", "You can't leave a team you are the only owner of. Make another member an owner first.")
+			http.Redirect(w, r, "/team/"+team.Slug, http.StatusSeeOther)
+		} else if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("You have left %s.", team.Name))
+	http.Redirect(w, r, "/teams", http.StatusSeeOther)
+}
+
+// teamMemberFromForm decodes the form of an owner acting on a member of the team
+// in the URL. It sends an error response and returns false if the user isn't an
+// owner of the team or the form is invalid.
+func (app *application) teamMemberFromForm(w http.ResponseWriter, r *http.Request) (*store.Team, teamMemberForm, bool) {
+	var form teamMemberForm
+
+	team, role, ok := app.teamFromURL(w, r)
+	if !ok {
+		return nil, form, false
+	}
+	if role != store.TeamRoleOwner {
+		app.clientError(w, r, http.StatusForbidden)
+		return nil, form, false
+	}
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return nil, form, false
+	}
+
+	return team, form, true
+}
+
+// teamMemberUpdated sends an owner back to the page of the team after they
+// changed a member, or tells them why the change was refused.
+func (app *application) teamMemberUpdated(w http.ResponseWriter, r *http.Request, team *store.Team, err error, flash string) {
+	if err != nil {
+		if errors.Is(err, store.ErrLastOwner) {
+			flash = "A team with members needs an owner. Make another member an owner first."
+		} else if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+			return
+		} else {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/team/"+team.Slug, http.StatusSeeOther)
+}
+
+func (app *application) teamMemberRolePost(w http.ResponseWriter, r *http.Request) {
+	team, form, ok := app.teamMemberFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	role := store.TeamRole(form.Role)
+	if !role.Valid() {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err := app.teamStore.SetRole(team.ID, form.UserID, role)
+	app.teamMemberUpdated(w, r, team, err, "The role of the member has been changed.")
+}
+
+func (app *application) teamMemberRemovePost(w http.ResponseWriter, r *http.Request) {
+	team, form, ok := app.teamMemberFromForm(w, r)
+	if !ok {
+		return
+	}
+
+	// Owners leave the team like any other member.
+	if form.UserID == app.authenticatedUser(r).ID {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err := app.teamStore.RemoveMember(team.ID, form.UserID)
+	app.teamMemberUpdated(w, r, team, err, "The member has been removed from the team.")
+}
+
+func (app *application) teamJoin(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.InviteLink = "/teams/join/" + chi.URLParam(r, "token")
+	app.render(w, r, http.StatusOK, "teamjoin.tmpl", data)
+}
+
+func (app *application) teamJoinPost(w http.ResponseWriter, r *http.Request) {
+	team, err := app.teamStore.AcceptInvite(chi.URLParam(r, "token"), app.authenticatedUser(r).ID)
+	app.joinedTeam(w, r, team, err)
+}
+
+func (app *application) teamInviteAcceptPost(w http.ResponseWriter, r *http.Request) {
+	var form teamInviteAcceptForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	team, err := app.teamStore.AcceptEmailInvite(form.ID, app.authenticatedUser(r).ID)
+	app.joinedTeam(w, r, team, err)
+}
+
+// joinedTeam sends the user to the team they accepted an invite to, or tells
+// them that the invite can't be used.
+func (app *application) joinedTeam(w http.ResponseWriter, r *http.Request, team *store.Team, err error) {
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.sessionManager.Put(r.Context(), "flash", "This invite has expired, has already been used or is meant for someone else.")
+			http.Redirect(w, r, "/teams", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Welcome to %s!", team.Name))
+	http.Redirect(w, r, "/team/"+team.Slug, http.StatusSeeOther)
+}
+
+func (app *application) moderationQueue(w http.ResponseWriter, r *http.Request) {
+	reports, err := app.moderationStore.OpenReports()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	actions, err := app.moderationStore.Actions(moderationPageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Reports = reports
+	data.ModerationActions = actions
+	app.render(w, r, http.StatusOK, "moderation.tmpl", data)
+}
+
+func (app *application) moderationDismissPost(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, app.moderationStore.Dismiss, "", "Reports about snippet #%d have been dismissed.")
+}
+
+func (app *application) moderationHidePost(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, app.moderationStore.Hide, audit.EventSnippetUpdate, "Snippet #%d has been hidden.")
+}
+
+func (app *application) moderationDeletePost(w http.ResponseWriter, r *http.Request) {
+	app.moderate(w, r, app.moderationStore.Delete, audit.EventSnippetDelete, "Snippet #%d has been deleted.")
+}
+
+// moderate applies a moderation action to the snippet named in the form on
+// behalf of the logged-in moderator, records the given audit event if the
+// snippet was changed and returns to the queue.
+func (app *application) moderate(w http.ResponseWriter, r *http.Request, action func(snippetID, moderatorID int) error, eventType, flash string) {
+	var form moderationForm
+
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	moderatorID := app.authenticatedUser(r).ID
+
+	err = action(form.SnippetID, moderatorID)
+	if err != nil {
+		if errors.Is(err, store.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if eventType != "" {
+		app.audit(r, eventType, moderatorID, map[string]any{"snippet_id": form.SnippetID, "moderated": true})
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf(flash, form.SnippetID))
+	http.Redirect(w, r, "/moderation", http.StatusSeeOther)
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/migrate"
+	"github.com/96malhar/snippetbox/internal/store"
+	"net/http"
+	"time"
+)
+
+// schemaVersion is the version of the last migration, which the database needs
+// to be at for the application to work. Both backends have the same versions.
+var schemaVersion = migrate.MustLatest(store.Postgres)
+
+// The statuses reported by the health endpoints.
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type certificateHealth struct {
+	NotAfter         time.Time `json:"not_after"`
+	ExpiresInSeconds int64     `json:"expires_in_seconds"`
+}
+
+type health struct {
+	Status      string             `json:"status"`
+	Certificate *certificateHealth `json:"certificate,omitempty"`
+}
+
+// healthz tells whether the process is alive. It doesn't check any dependency,
+// but does report when the TLS certificate it currently serves expires.
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	h := health{Status: statusOK}
+	if app.certificate != nil {
+		notAfter := app.certificate.NotAfter()
+		h.Certificate = &certificateHealth{
+			NotAfter:         notAfter,
+			ExpiresInSeconds: int64(notAfter.Sub(app.datetimeHandler.GetCurrentTimeUTC()).Seconds()),
+		}
+	}
+
+	writeHealth(w, http.StatusOK, h)
+}
+
+// checkFailure is an expected reason for a readiness check to fail. Unlike other
+// errors, its message is included in the response.
+type checkFailure string
+
+func (f checkFailure) Error() string {
+	return string(f)
+}
+
+// readinessCheck checks a dependency of the application. It returns a short
+// description of what it found, or an error if the dependency isn't usable.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) (string, error)
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Detail    string  `json:"detail,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readiness struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks"`
+}
+
+// readyz tells whether the application can serve requests. It responds with a 503
+// Service Unavailable status if any of the checks fails, including once the
+// server started shutting down.
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), app.readinessTimeout)
+	defer cancel()
+
+	res := readiness{Status: statusOK, Checks: map[string]*checkResult{}}
+	for _, c := range app.readinessChecks() {
+		start := time.Now()
+		detail, err := c.check(ctx)
+		result := &checkResult{
+			Status:    statusOK,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Detail:    detail,
+		}
+
+		if err != nil {
+			res.Status = statusFail
+			result.Status = statusFail
+
+			var failure checkFailure
+			switch {
+			case errors.As(err, &failure):
+				result.Error = failure.Error()
+			case errors.Is(err, context.DeadlineExceeded):
+				result.Error = "timed out"
+			default:
+				// Errors of the database driver can reveal details of the
+				// infrastructure, so they are only logged.
+				result.Error = "check failed"
+				app.logger.Error("readiness check failed", "check", c.name, "error", err.Error())
+			}
+		}
+		res.Checks[c.name] = result
+	}
+
+	status := http.StatusOK
+	if res.Status != statusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, res)
+}
+
+func (app *application) readinessChecks() []readinessCheck {
+	checks := []readinessCheck{
+		{name: "shutdown", check: func(ctx context.Context) (string, error) {
+			if app.shuttingDown.Load() {
+				return "", checkFailure("server is shutting down")
+			}
+			return "", nil
+		}},
+		{name: "database", check: func(ctx context.Context) (string, error) {
+			return "", app.healthStore.Ping(ctx)
+		}},
+		{name: "migrations", check: func(ctx context.Context) (string, error) {
+			version, dirty, err := app.healthStore.MigrationVersion(ctx)
+			if errors.Is(err, store.ErrNoRecord) {
+				return "", checkFailure("no migration has been applied")
+			}
+			if err != nil {
+				return "", err
+			}
+
+			detail := fmt.Sprintf("version %d", version)
+			if dirty {
+				return detail, checkFailure(fmt.Sprintf("migration %d failed and needs to be fixed", version))
+			}
+			if version < schemaVersion {
+				return detail, checkFailure(fmt.Sprintf("version %d is required", schemaVersion))
+			}
+			return detail, nil
+		}},
+		{name: "templates", check: func(ctx context.Context) (string, error) {
+			detail := fmt.Sprintf("%d templates", len(app.templateCache))
+			if len(app.templateCache) == 0 {
+				return detail, checkFailure("template cache is empty")
+			}
+			return detail, nil
+		}},
+	}
+
+	if app.sessionTableMaxRows > 0 {
+		checks = append(checks, readinessCheck{name: "sessions", check: func(ctx context.Context) (string, error) {
+			count, err := app.healthStore.SessionCount(ctx)
+			if err != nil {
+				return "", err
+			}
+
+			detail := fmt.Sprintf("%d of %d rows", count, app.sessionTableMaxRows)
+			if count >= app.sessionTableMaxRows {
+				return detail, checkFailure("session table has reached its limit")
+			}
+			return detail, nil
+		}})
+	}
+
+	return checks
+}
+
+func writeHealth(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
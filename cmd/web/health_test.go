@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/store/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fixedCertificate is a certificate which never changes.
+type fixedCertificate time.Time
+
+func (c fixedCertificate) NotAfter() time.Time {
+	return time.Time(c)
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name        string
+		certificate certificateInterface
+		wantBody    string
+	}{
+		{
+			name:     "Plain HTTP",
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:        "TLS",
+			certificate: fixedCertificate(time.Date(2023, time.January, 31, 10, 0, 0, 0, time.UTC)),
+			wantBody:    `{"status":"ok","certificate":{"not_after":"2023-01-31T10:00:00Z","expires_in_seconds":2592000}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.certificate = tc.certificate
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			resp := ts.get(t, "/healthz")
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+			assert.JSONEq(t, tc.wantBody, getString(t, resp.Body))
+		})
+	}
+}
+
+// slowHealthStore is a database which doesn't answer until the context is done.
+type slowHealthStore struct {
+	mocks.MockHealthStore
+}
+
+func (s *slowHealthStore) Ping(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReadyz(t *testing.T) {
+	templateCache, err := newTemplateCache()
+	require.NoError(t, err)
+	templates := fmt.Sprintf("%d templates", len(templateCache))
+	current := fmt.Sprintf("version %d", schemaVersion)
+	previous := fmt.Sprintf("version %d", schemaVersion-1)
+	required := fmt.Sprintf("version %d is required", schemaVersion)
+	dirty := fmt.Sprintf("migration %d failed and needs to be fixed", schemaVersion)
+
+	tests := []struct {
+		name                string
+		healthStore         store.Health
+		sessionTableMaxRows int
+		emptyTemplateCache  bool
+		shuttingDown        bool
+		wantCode            int
+		wantChecks          map[string]checkResult
+	}{
+		{
+			name:        "Ready",
+			healthStore: mocks.NewMockHealthStore(schemaVersion),
+			wantCode:    http.StatusOK,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusOK, Detail: current},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:        "Database down",
+			healthStore: &mocks.MockHealthStore{Err: errors.New("dial tcp 10.0.0.5:5432: connection refused")},
+			wantCode:    http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusFail, Error: "check failed"},
+				"migrations": {Status: statusFail, Error: "check failed"},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:        "Database timeout",
+			healthStore: &slowHealthStore{MockHealthStore: mocks.MockHealthStore{Version: schemaVersion}},
+			wantCode:    http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusFail, Error: "timed out"},
+				"migrations": {Status: statusFail, Error: "timed out"},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:        "Migrations behind",
+			healthStore: mocks.NewMockHealthStore(schemaVersion - 1),
+			wantCode:    http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusFail, Detail: previous, Error: required},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:        "Dirty migration",
+			healthStore: &mocks.MockHealthStore{Version: schemaVersion, Dirty: true},
+			wantCode:    http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusFail, Detail: current, Error: dirty},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:        "No migrations",
+			healthStore: mocks.NewMockHealthStore(0),
+			wantCode:    http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusFail, Error: "no migration has been applied"},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+		{
+			name:               "Empty template cache",
+			healthStore:        mocks.NewMockHealthStore(schemaVersion),
+			emptyTemplateCache: true,
+			wantCode:           http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusOK, Detail: current},
+				"templates":  {Status: statusFail, Detail: "0 templates", Error: "template cache is empty"},
+			},
+		},
+		{
+			name:                "Session table headroom",
+			healthStore:         &mocks.MockHealthStore{Version: schemaVersion, Sessions: 99},
+			sessionTableMaxRows: 100,
+			wantCode:            http.StatusOK,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusOK, Detail: current},
+				"templates":  {Status: statusOK, Detail: templates},
+				"sessions":   {Status: statusOK, Detail: "99 of 100 rows"},
+			},
+		},
+		{
+			name:                "Session table full",
+			healthStore:         &mocks.MockHealthStore{Version: schemaVersion, Sessions: 100},
+			sessionTableMaxRows: 100,
+			wantCode:            http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusOK},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusOK, Detail: current},
+				"templates":  {Status: statusOK, Detail: templates},
+				"sessions":   {Status: statusFail, Detail: "100 of 100 rows", Error: "session table has reached its limit"},
+			},
+		},
+		{
+			name:         "Shutting down",
+			healthStore:  mocks.NewMockHealthStore(schemaVersion),
+			shuttingDown: true,
+			wantCode:     http.StatusServiceUnavailable,
+			wantChecks: map[string]checkResult{
+				"shutdown":   {Status: statusFail, Error: "server is shutting down"},
+				"database":   {Status: statusOK},
+				"migrations": {Status: statusOK, Detail: current},
+				"templates":  {Status: statusOK, Detail: templates},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.healthStore = tc.healthStore
+			app.readinessTimeout = 50 * time.Millisecond
+			app.sessionTableMaxRows = tc.sessionTableMaxRows
+			app.shuttingDown.Store(tc.shuttingDown)
+			if tc.emptyTemplateCache {
+				app.templateCache = nil
+			}
+
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			resp := ts.get(t, "/readyz")
+			defer resp.Body.Close()
+			assert.Equal(t, tc.wantCode, resp.StatusCode)
+
+			var body readiness
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			wantStatus := statusOK
+			if tc.wantCode != http.StatusOK {
+				wantStatus = statusFail
+			}
+			assert.Equal(t, wantStatus, body.Status)
+
+			checks := map[string]checkResult{}
+			for name, c := range body.Checks {
+				assert.GreaterOrEqual(t, c.LatencyMS, float64(0))
+				c.LatencyMS = 0
+				checks[name] = *c
+			}
+			assert.Equal(t, tc.wantChecks, checks)
+		})
+	}
+}
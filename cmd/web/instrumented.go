@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/metrics"
+	"github.com/96malhar/snippetbox/internal/store"
+	"time"
+)
+
+// storeObserver records how long the methods of a store take.
+type storeObserver struct {
+	metrics *metrics.Metrics
+	store   string
+}
+
+// observe starts timing a call of method. The returned function must be called
+// once the call returns.
+func (o storeObserver) observe(method string) func() {
+	start := time.Now()
+	return func() {
+		o.metrics.ObserveQuery(o.store, method, time.Since(start))
+	}
+}
+
+// instrumentStores wraps the stores of the application so that the duration of
+// every call is recorded.
+func (app *application) instrumentStores() {
+	app.snippetStore = &instrumentedSnippetStore{next: app.snippetStore, storeObserver: storeObserver{app.metrics, "snippets"}}
+	app.userStore = &instrumentedUserStore{next: app.userStore, storeObserver: storeObserver{app.metrics, "users"}}
+	app.userSessionStore = &instrumentedUserSessionStore{next: app.userSessionStore, storeObserver: storeObserver{app.metrics, "user_sessions"}}
+	app.teamStore = &instrumentedTeamStore{next: app.teamStore, storeObserver: storeObserver{app.metrics, "teams"}}
+	app.moderationStore = &instrumentedModerationStore{next: app.moderationStore, storeObserver: storeObserver{app.metrics, "moderation"}}
+	app.auditLog = &instrumentedAuditLog{next: app.auditLog, storeObserver: storeObserver{app.metrics, "audit"}}
+}
+
+type instrumentedSnippetStore struct {
+	next store.Snippets
+	storeObserver
+}
+
+func (s *instrumentedSnippetStore) Insert(ctx context.Context, title string, content string, expirationDays int, teamID int) (int, error) {
+	defer s.observe("Insert")()
+	return s.next.Insert(ctx, title, content, expirationDays, teamID)
+}
+
+func (s *instrumentedSnippetStore) Get(ctx context.Context, id int) (*store.Snippet, error) {
+	defer s.observe("Get")()
+	return s.next.Get(ctx, id)
+}
+
+func (s *instrumentedSnippetStore) Latest(ctx context.Context) ([]*store.Snippet, error) {
+	defer s.observe("Latest")()
+	return s.next.Latest(ctx)
+}
+
+func (s *instrumentedSnippetStore) List(ctx context.Context, limit int) ([]*store.Snippet, error) {
+	defer s.observe("List")()
+	return s.next.List(ctx, limit)
+}
+
+func (s *instrumentedSnippetStore) ListForTeam(ctx context.Context, teamID int) ([]*store.Snippet, error) {
+	defer s.observe("ListForTeam")()
+	return s.next.ListForTeam(ctx, teamID)
+}
+
+func (s *instrumentedSnippetStore) Update(ctx context.Context, id int, title, content string) error {
+	defer s.observe("Update")()
+	return s.next.Update(ctx, id, title, content)
+}
+
+func (s *instrumentedSnippetStore) Count(ctx context.Context) (total int, active int, err error) {
+	defer s.observe("Count")()
+	return s.next.Count(ctx)
+}
+
+func (s *instrumentedSnippetStore) Expire(ctx context.Context, id int) error {
+	defer s.observe("Expire")()
+	return s.next.Expire(ctx, id)
+}
+
+func (s *instrumentedSnippetStore) Delete(ctx context.Context, id int) error {
+	defer s.observe("Delete")()
+	return s.next.Delete(ctx, id)
+}
+
+type instrumentedUserStore struct {
+	next store.Users
+	storeObserver
+}
+
+func (s *instrumentedUserStore) Insert(ctx context.Context, name, email, password string) (int, error) {
+	defer s.observe("Insert")()
+	return s.next.Insert(ctx, name, email, password)
+}
+
+func (s *instrumentedUserStore) Authenticate(ctx context.Context, email, password string) (int, error) {
+	defer s.observe("Authenticate")()
+	return s.next.Authenticate(ctx, email, password)
+}
+
+func (s *instrumentedUserStore) ProvisionIdentity(ctx context.Context, issuer, subject, name, email string) (int, error) {
+	defer s.observe("ProvisionIdentity")()
+	return s.next.ProvisionIdentity(ctx, issuer, subject, name, email)
+}
+
+func (s *instrumentedUserStore) Get(ctx context.Context, id int) (*store.User, error) {
+	defer s.observe("Get")()
+	return s.next.Get(ctx, id)
+}
+
+func (s *instrumentedUserStore) Search(ctx context.Context, query string, limit int) ([]*store.User, error) {
+	defer s.observe("Search")()
+	return s.next.Search(ctx, query, limit)
+}
+
+func (s *instrumentedUserStore) Count(ctx context.Context) (int, error) {
+	defer s.observe("Count")()
+	return s.next.Count(ctx)
+}
+
+func (s *instrumentedUserStore) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	defer s.observe("SetDisabled")()
+	return s.next.SetDisabled(ctx, id, disabled)
+}
+
+func (s *instrumentedUserStore) SetRole(ctx context.Context, id int, role store.Role) error {
+	defer s.observe("SetRole")()
+	return s.next.SetRole(ctx, id, role)
+}
+
+func (s *instrumentedUserStore) EnableTOTP(ctx context.Context, id int, secret string, recoveryCodes []string) error {
+	defer s.observe("EnableTOTP")()
+	return s.next.EnableTOTP(ctx, id, secret, recoveryCodes)
+}
+
+func (s *instrumentedUserStore) DisableTOTP(ctx context.Context, id int) error {
+	defer s.observe("DisableTOTP")()
+	return s.next.DisableTOTP(ctx, id)
+}
+
+func (s *instrumentedUserStore) UseRecoveryCode(ctx context.Context, id int, code string) (bool, error) {
+	defer s.observe("UseRecoveryCode")()
+	return s.next.UseRecoveryCode(ctx, id, code)
+}
+
+func (s *instrumentedUserStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	defer s.observe("UseTOTPCounter")()
+	return s.next.UseTOTPCounter(ctx, id, counter)
+}
+
+func (s *instrumentedUserStore) UpdatePassword(ctx context.Context, id int, password string) error {
+	defer s.observe("UpdatePassword")()
+	return s.next.UpdatePassword(ctx, id, password)
+}
+
+func (s *instrumentedUserStore) CreatePasswordReset(ctx context.Context, id int) (string, error) {
+	defer s.observe("CreatePasswordReset")()
+	return s.next.CreatePasswordReset(ctx, id)
+}
+
+func (s *instrumentedUserStore) PasswordResetUser(ctx context.Context, token string) (*store.User, error) {
+	defer s.observe("PasswordResetUser")()
+	return s.next.PasswordResetUser(ctx, token)
+}
+
+func (s *instrumentedUserStore) ResetPassword(ctx context.Context, token, password string) (int, error) {
+	defer s.observe("ResetPassword")()
+	return s.next.ResetPassword(ctx, token, password)
+}
+
+type instrumentedUserSessionStore struct {
+	next store.UserSessions
+	storeObserver
+}
+
+func (s *instrumentedUserSessionStore) Insert(userID int, token, userAgent, ip string) error {
+	defer s.observe("Insert")()
+	return s.next.Insert(userID, token, userAgent, ip)
+}
+
+func (s *instrumentedUserSessionStore) Touch(token string) error {
+	defer s.observe("Touch")()
+	return s.next.Touch(token)
+}
+
+func (s *instrumentedUserSessionStore) Get(id int) (*store.UserSession, error) {
+	defer s.observe("Get")()
+	return s.next.Get(id)
+}
+
+func (s *instrumentedUserSessionStore) GetAllForUser(userID int) ([]*store.UserSession, error) {
+	defer s.observe("GetAllForUser")()
+	return s.next.GetAllForUser(userID)
+}
+
+func (s *instrumentedUserSessionStore) Delete(token string) error {
+	defer s.observe("Delete")()
+	return s.next.Delete(token)
+}
+
+type instrumentedTeamStore struct {
+	next store.Teams
+	storeObserver
+}
+
+func (s *instrumentedTeamStore) Create(name string, ownerID int) (*store.Team, error) {
+	defer s.observe("Create")()
+	return s.next.Create(name, ownerID)
+}
+
+func (s *instrumentedTeamStore) Get(id int) (*store.Team, error) {
+	defer s.observe("Get")()
+	return s.next.Get(id)
+}
+
+func (s *instrumentedTeamStore) GetBySlug(slug string) (*store.Team, error) {
+	defer s.observe("GetBySlug")()
+	return s.next.GetBySlug(slug)
+}
+
+func (s *instrumentedTeamStore) ForUser(userID int) ([]*store.Team, error) {
+	defer s.observe("ForUser")()
+	return s.next.ForUser(userID)
+}
+
+func (s *instrumentedTeamStore) Members(teamID int) ([]*store.TeamMember, error) {
+	defer s.observe("Members")()
+	return s.next.Members(teamID)
+}
+
+func (s *instrumentedTeamStore) Role(teamID, userID int) (store.TeamRole, error) {
+	defer s.observe("Role")()
+	return s.next.Role(teamID, userID)
+}
+
+func (s *instrumentedTeamStore) Invite(teamID int, email string, invitedBy int) (string, error) {
+	defer s.observe("Invite")()
+	return s.next.Invite(teamID, email, invitedBy)
+}
+
+func (s *instrumentedTeamStore) PendingInvites(teamID int) ([]*store.TeamInvite, error) {
+	defer s.observe("PendingInvites")()
+	return s.next.PendingInvites(teamID)
+}
+
+func (s *instrumentedTeamStore) InvitesForUser(userID int) ([]*store.TeamInvite, error) {
+	defer s.observe("InvitesForUser")()
+	return s.next.InvitesForUser(userID)
+}
+
+func (s *instrumentedTeamStore) AcceptInvite(token string, userID int) (*store.Team, error) {
+	defer s.observe("AcceptInvite")()
+	return s.next.AcceptInvite(token, userID)
+}
+
+func (s *instrumentedTeamStore) AcceptEmailInvite(inviteID, userID int) (*store.Team, error) {
+	defer s.observe("AcceptEmailInvite")()
+	return s.next.AcceptEmailInvite(inviteID, userID)
+}
+
+func (s *instrumentedTeamStore) RemoveMember(teamID, userID int) error {
+	defer s.observe("RemoveMember")()
+	return s.next.RemoveMember(teamID, userID)
+}
+
+func (s *instrumentedTeamStore) SetRole(teamID, userID int, role store.TeamRole) error {
+	defer s.observe("SetRole")()
+	return s.next.SetRole(teamID, userID, role)
+}
+
+type instrumentedModerationStore struct {
+	next store.Moderation
+	storeObserver
+}
+
+func (s *instrumentedModerationStore) Report(snippetID int, reason, details string) error {
+	defer s.observe("Report")()
+	return s.next.Report(snippetID, reason, details)
+}
+
+func (s *instrumentedModerationStore) OpenReports() ([]*store.Report, error) {
+	defer s.observe("OpenReports")()
+	return s.next.OpenReports()
+}
+
+func (s *instrumentedModerationStore) Dismiss(snippetID, moderatorID int) error {
+	defer s.observe("Dismiss")()
+	return s.next.Dismiss(snippetID, moderatorID)
+}
+
+func (s *instrumentedModerationStore) Hide(snippetID, moderatorID int) error {
+	defer s.observe("Hide")()
+	return s.next.Hide(snippetID, moderatorID)
+}
+
+func (s *instrumentedModerationStore) Delete(snippetID, moderatorID int) error {
+	defer s.observe("Delete")()
+	return s.next.Delete(snippetID, moderatorID)
+}
+
+func (s *instrumentedModerationStore) Actions(limit int) ([]*store.ModerationAction, error) {
+	defer s.observe("Actions")()
+	return s.next.Actions(limit)
+}
+
+type instrumentedAuditLog struct {
+	next auditLogInterface
+	storeObserver
+}
+
+func (s *instrumentedAuditLog) Record(e audit.Event) error {
+	defer s.observe("Record")()
+	return s.next.Record(e)
+}
+
+func (s *instrumentedAuditLog) List(f audit.Filter, limit int) ([]*audit.Event, error) {
+	defer s.observe("List")()
+	return s.next.List(f, limit)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// scrape returns the metrics served by the admin listener.
+func scrape(t *testing.T, app *application) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	app.adminRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	app.sessionManager.Store = app.metrics.SessionStore(app.sessionManager.Store)
+	app.instrumentStores()
+
+	// Opening a pool doesn't connect to the database, which is enough for its
+	// statistics to be exported.
+	db, err := sql.Open("postgres", "postgres://localhost/snippetbox")
+	require.NoError(t, err)
+	defer db.Close()
+	app.metrics.RegisterDB(db, "snippetbox")
+
+	_, err = app.snippetStore.Insert(context.Background(), "Title", "Content", 7, 0)
+	require.NoError(t, err)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for _, path := range []string{"/snippet/view/1", "/snippet/view/1", "/snippet/view/99", "/does/not/exist"} {
+		ts.get(t, path).Body.Close()
+	}
+
+	form := url.Values{"email": {"nobody@example.com"}, "password": {"wrong"}}
+	ts.postForm(t, "/user/login", form).Body.Close()
+	loginAs(t, app, ts, "Alice", store.RoleUser)
+	ts.get(t, "/").Body.Close()
+
+	metrics := scrape(t, app)
+
+	wantSeries := []string{
+		`snippetbox_http_requests_total{method="GET",route="/snippet/view/{id}",status="200"} 2`,
+		`snippetbox_http_requests_total{method="GET",route="/snippet/view/{id}",status="404"} 1`,
+		`snippetbox_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`snippetbox_http_requests_total{method="POST",route="/user/login",status="303"} 1`,
+		`snippetbox_http_requests_total{method="POST",route="/user/login",status="422"} 1`,
+		`snippetbox_http_request_duration_seconds_count{method="GET",route="/snippet/view/{id}",status="200"} 2`,
+		`snippetbox_store_query_duration_seconds_count{method="Get",store="snippets"} 3`,
+		`snippetbox_store_query_duration_seconds_count{method="Authenticate",store="users"} 2`,
+		`snippetbox_store_query_duration_seconds_count{method="Record",store="audit"}`,
+		`snippetbox_session_loads_total{result="found"}`,
+		`snippetbox_logins_total{result="failure"} 1`,
+		`snippetbox_logins_total{result="success"} 1`,
+		`go_sql_open_connections{db_name="snippetbox"} 0`,
+	}
+	for _, series := range wantSeries {
+		assert.Contains(t, metrics, series)
+	}
+
+	// Routes are labelled by pattern, never by path.
+	assert.NotContains(t, metrics, `route="/snippet/view/1"`)
+	assert.NotContains(t, metrics, `route="/does/not/exist"`)
+}
+
+func TestInstrumentRequests_UnknownMethod(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest("BREW", ts.URL+"/", nil)
+	require.NoError(t, err)
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	metrics := scrape(t, app)
+	assert.Contains(t, metrics, `method="other"`)
+	assert.NotContains(t, metrics, `method="BREW"`)
+}
@@ -1,16 +1,27 @@
 package main
 
-import "github.com/96malhar/snippetbox/internal/store"
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/oidc"
+	"github.com/96malhar/snippetbox/internal/ratelimit"
+	"time"
+)
 
-type snippetStoreInterface interface {
-	Insert(title string, content string, expirationDays int) (int, error)
-	Get(id int) (*store.Snippet, error)
-	Latest() ([]*store.Snippet, error)
+type auditLogInterface interface {
+	Record(e audit.Event) error
+	List(f audit.Filter, limit int) ([]*audit.Event, error)
 }
 
-type userStoreInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
-	Get(id int) (*store.User, error)
+type rateLimiterInterface interface {
+	Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error)
+}
+
+type certificateInterface interface {
+	NotAfter() time.Time
+}
+
+type oidcProviderInterface interface {
+	AuthCodeURL(state, nonce, verifier string) string
+	Exchange(ctx context.Context, code, verifier, nonce string) (*oidc.Identity, error)
 }
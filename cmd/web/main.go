@@ -1,13 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/audit"
+	"github.com/96malhar/snippetbox/internal/config"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"github.com/96malhar/snippetbox/internal/metrics"
+	"github.com/96malhar/snippetbox/internal/migrate"
+	"github.com/96malhar/snippetbox/internal/oidc"
+	"github.com/96malhar/snippetbox/internal/ratelimit"
+	"github.com/96malhar/snippetbox/internal/realip"
+	"github.com/96malhar/snippetbox/internal/secrets"
 	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/tlsreload"
+	"github.com/96malhar/snippetbox/internal/tracing"
+	"github.com/96malhar/snippetbox/internal/validation"
 	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
-	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -15,39 +34,134 @@ import (
 )
 
 func main() {
-	app := newApplication()
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+		os.Exit(2)
+	}
+
+	if cfg.PrintConfig {
+		if err = cfg.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if err = cfg.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter, "snippetbox", os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	app, db := newApplication(cfg)
 
 	tlsConfig := &tls.Config{
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = ":4000"
-	}
 	srv := &http.Server{
-		Addr:         serverPort,
+		Addr:         cfg.Addr,
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
 		Handler:      app.routes(),
-		TLSConfig:    tlsConfig,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 	}
 
-	app.logger.Info("starting server", "addr", srv.Addr)
-	err := srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	app.logger.Error(err.Error())
-	os.Exit(1)
+	var listeners []listener
+	if cfg.PlainHTTP {
+		listeners = append(listeners, listener{srv: srv, listen: srv.ListenAndServe})
+	} else {
+		certificate, err := tlsreload.New(cfg.TLSCertFile, cfg.TLSKeyFile, app.logger)
+		if err != nil {
+			app.logger.Error(err.Error())
+			os.Exit(1)
+		}
+		app.certificate = certificate
+		app.backgroundTasks = append(app.backgroundTasks, func(ctx context.Context) {
+			certificate.Watch(ctx, cfg.TLSReloadInterval)
+		})
+
+		tlsConfig.GetCertificate = certificate.GetCertificate
+		srv.TLSConfig = tlsConfig
+		listeners = append(listeners, listener{srv: srv, listen: func() error {
+			return srv.ListenAndServeTLS("", "")
+		}})
+	}
+
+	if cfg.RedirectAddr != "" {
+		redirectSrv := &http.Server{
+			Addr:         cfg.RedirectAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      app.redirectRoutes(cfg.Addr),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		listeners = append(listeners, listener{srv: redirectSrv, listen: redirectSrv.ListenAndServe})
+	}
+
+	if cfg.AdminAddr != "" {
+		adminSrv := &http.Server{
+			Addr:         cfg.AdminAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  time.Minute,
+			ReadTimeout:  5 * time.Second,
+			WriteTimeout: 10 * time.Second,
+		}
+		listeners = append(listeners, listener{srv: adminSrv, listen: adminSrv.ListenAndServe})
+	}
+
+	err = app.serve(cfg.ShutdownTimeout, listeners...)
+
+	app.logger.Info("closing database")
+	if closeErr := db.Close(); closeErr != nil {
+		app.logger.Error(closeErr.Error())
+	}
+
+	app.logger.Info("flushing spans")
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		app.logger.Error(flushErr.Error())
+	}
+	cancel()
+
+	if err != nil {
+		app.logger.Error(err.Error())
+		os.Exit(1)
+	}
+	app.logger.Info("shutdown complete")
 }
 
-func newApplication() *application {
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	db, err := openDB()
+// newApplication builds the application from the config. It also returns the
+// database connection pool, which is closed once the server has shut down.
+func newApplication(cfg *config.Config) (*application, *sql.DB) {
+	logger := newLogger(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	db, backend, err := openDB(cfg.DSN)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+	if backend != store.Postgres && cfg.RateLimiter == config.RateLimiterPostgres {
+		logger.Error(fmt.Sprintf("the %s rate limiter needs a Postgres database, use %s instead",
+			config.RateLimiterPostgres, config.RateLimiterMemory))
+		os.Exit(1)
+	}
+	if cfg.DBMigrate {
+		if err := migrateDB(db, backend, logger); err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
 
 	templateCache, err := newTemplateCache()
 	if err != nil {
@@ -55,36 +169,216 @@ func newApplication() *application {
 		os.Exit(1)
 	}
 
+	appMetrics := metrics.New()
+	appMetrics.RegisterDB(db, "snippetbox")
+
+	sessionStore := newSessionStore(db, backend)
 	sessionManager := scs.New()
-	sessionManager.Store = postgresstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Store = appMetrics.SessionStore(sessionStore)
+	sessionManager.Lifetime = cfg.SessionLifetime
 	sessionManager.Cookie.Secure = true
 
+	realIP, err := realip.New(cfg.TrustedProxies, cfg.ProxyHeader)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	secretScanner, err := secrets.Load(cfg.SecretRulesFile)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	breachedPasswords, err := validation.LoadBloomFilter(cfg.BreachedPasswordsFile)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var oidcProvider oidcProviderInterface
+	if cfg.OIDC.IssuerURL != "" {
+		oidcProvider, err = oidc.NewProvider(context.Background(), cfg.OIDC)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	userSessionStore := store.NewUserSessionStore(db)
+
 	app := &application{
-		logger:         logger,
-		snippetStore:   store.NewSnippetStore(db),
-		userStore:      store.NewUserStore(db),
-		templateCache:  templateCache,
-		formDecoder:    form.NewDecoder(),
-		sessionManager: sessionManager,
+		logger:                logger,
+		snippetStore:          store.NewSnippetStore(db, cfg.QueryTimeout),
+		userStore:             store.NewUserStore(db, passwordHasher(cfg), cfg.QueryTimeout),
+		userSessionStore:      userSessionStore,
+		teamStore:             store.NewTeamStore(db),
+		moderationStore:       store.NewModerationStore(db),
+		auditLog:              audit.NewLog(db),
+		templateCache:         templateCache,
+		formDecoder:           form.NewDecoder(),
+		sessionManager:        sessionManager,
+		datetimeHandler:       &datetime.Handler{},
+		oidcProvider:          oidcProvider,
+		passwordLoginDisabled: cfg.PasswordLoginDisabled,
+		hstsHeader:            hstsHeader(cfg),
+		baseURL:               cfg.BaseURL,
+		healthStore:           store.NewHealthStore(db),
+		readinessTimeout:      cfg.ReadinessTimeout,
+		sessionTableMaxRows:   cfg.SessionTableMaxRows,
+		shutdownDelay:         cfg.ShutdownDelay,
+		metrics:               appMetrics,
+		tracer:                otel.Tracer("github.com/96malhar/snippetbox/cmd/web"),
+		realIP:                realIP,
+		secretScanner:         secretScanner,
+		passwordPolicy: &validation.PasswordPolicy{
+			MinLength:  minPasswordLength,
+			MinEntropy: float64(cfg.PasswordMinEntropy),
+			Breached:   breachedPasswords,
+		},
+		backgroundTasks: []backgroundTask{
+			// The session store deletes expired sessions in a goroutine of its own.
+			func(ctx context.Context) {
+				<-ctx.Done()
+				sessionStore.StopCleanup()
+			},
+			// The index of the sessions of users follows, as deleted sessions
+			// leave their entries behind.
+			func(ctx context.Context) {
+				userSessionStore.Cleanup(ctx, sessionCleanupInterval, func(err error) {
+					logger.Error("failed to delete user sessions", "error", err.Error())
+				})
+			},
+		},
 	}
 
-	return app
+	switch cfg.RateLimiter {
+	case config.RateLimiterMemory:
+		limiter := ratelimit.NewMemoryLimiter()
+		app.rateLimiter = limiter
+		app.backgroundTasks = append(app.backgroundTasks, func(ctx context.Context) {
+			limiter.Cleanup(ctx, time.Minute)
+		})
+	case config.RateLimiterPostgres:
+		limiter := ratelimit.NewPostgresLimiter(db)
+		app.rateLimiter = limiter
+		app.backgroundTasks = append(app.backgroundTasks, func(ctx context.Context) {
+			limiter.Cleanup(ctx, time.Minute, func(err error) {
+				logger.Error("failed to evict rate limits", "error", err.Error())
+			})
+		})
+	}
+
+	if cfg.PoWDifficulty > 0 {
+		key := []byte(cfg.PoWSecret)
+		if len(key) == 0 {
+			logger.Warn("pow-secret is not set, so the proof-of-work challenges are signed with a random key which other instances don't share")
+			key = make([]byte, 32)
+			if _, err = rand.Read(key); err != nil {
+				logger.Error(err.Error())
+				os.Exit(1)
+			}
+		}
+		proofOfWork := validation.NewProofOfWork(key, cfg.PoWDifficulty, func() int {
+			return int(app.inFlight.Load())
+		})
+		app.proofOfWork = proofOfWork
+		app.backgroundTasks = append(app.backgroundTasks, func(ctx context.Context) {
+			proofOfWork.Cleanup(ctx, time.Minute)
+		})
+	}
+
+	app.instrumentStores()
+
+	return app, db
+}
+
+// passwordHasher returns the hasher of new passwords picked by the config.
+func passwordHasher(cfg *config.Config) store.PasswordHasher {
+	if cfg.PasswordHasher == config.PasswordHasherBcrypt {
+		return &store.BcryptHasher{Cost: cfg.BcryptCost}
+	}
+	return &store.Argon2idHasher{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+	}
 }
 
-func openDB() (*sql.DB, error) {
-	dsn := os.Getenv("SNIPPETBOX_DB_DSN")
-	if dsn == "" {
-		panic("SNIPPETBOX_DB_DSN environment variable not set")
+// migrateDB applies the pending migrations of the database. Instances starting
+// at the same time take turns, so only one of them applies each migration.
+func migrateDB(db *sql.DB, backend store.Backend, logger *slog.Logger) error {
+	m, err := migrate.New(db, backend)
+	if err != nil {
+		return err
+	}
+	steps, err := m.Up(context.Background())
+	for _, step := range steps {
+		logger.Info("applied migration", "version", step.Migration.Version, "name", step.Migration.Name)
 	}
-	db, err := sql.Open("postgres", dsn)
+	return err
+}
+
+// openDB opens a connection pool which traces the queries it runs, to the
+// backend picked by the scheme of dsn.
+func openDB(dsn string) (*sql.DB, store.Backend, error) {
+	backend, driver, name, err := store.ParseDSN(dsn)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	if err = db.Ping(); err != nil {
-		return nil, err
+	system := tracing.DBSystemPostgreSQL
+	if backend == store.SQLite {
+		system = tracing.DBSystemSQLite
+	}
+	db := sql.OpenDB(tracing.Connector(driver, name, system))
+	if err := db.Ping(); err != nil {
+		return nil, "", err
 	}
 
-	return db, nil
+	return db, backend, nil
+}
+
+// sessionCleanupInterval is how often the scs stores delete expired sessions.
+const sessionCleanupInterval = 5 * time.Minute
+
+// sessionStore is an scs store which deletes expired sessions in the background
+// until StopCleanup is called.
+type sessionStore interface {
+	scs.Store
+	StopCleanup()
+}
+
+// newSessionStore returns the scs store keeping the sessions in the sessions
+// table of the backend.
+func newSessionStore(db *sql.DB, backend store.Backend) sessionStore {
+	if backend == store.SQLite {
+		return sqlite3store.NewWithCleanupInterval(db, sessionCleanupInterval)
+	}
+	return postgresstore.NewWithCleanupInterval(db, sessionCleanupInterval)
+}
+
+// newLogger returns a logger writing lines of the given format, either
+// config.LogFormatText or config.LogFormatJSON, to w.
+func newLogger(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == config.LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// hstsHeader returns the value of the Strict-Transport-Security header, or an
+// empty string if it shouldn't be sent. With plain HTTP, it is only sent to
+// clients which reached a trusted proxy over HTTPS.
+func hstsHeader(cfg *config.Config) string {
+	if (cfg.PlainHTTP && len(cfg.TrustedProxies) == 0) || cfg.HSTSMaxAge == 0 {
+		return ""
+	}
+
+	header := fmt.Sprintf("max-age=%d", int(cfg.HSTSMaxAge.Seconds()))
+	if cfg.HSTSIncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	return header
 }
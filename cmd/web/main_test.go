@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/alexedwards/scs/sqlite3store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOpenDB_SQLite(t *testing.T) {
+	db, backend, err := openDB("sqlite://" + filepath.Join(t.TempDir(), "snippetbox.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, store.SQLite, backend)
+
+	migration, err := os.ReadFile("../../migrations/sqlite/000003_create_sessions_table.up.sql")
+	require.NoError(t, err)
+	_, err = db.Exec(string(migration))
+	require.NoError(t, err)
+
+	// The sessions follow the database to SQLite.
+	sessions := newSessionStore(db, backend)
+	defer sessions.StopCleanup()
+	require.IsType(t, &sqlite3store.SQLite3Store{}, sessions)
+
+	require.NoError(t, sessions.Commit("token", []byte("data"), time.Now().Add(time.Hour)))
+	data, found, err := sessions.Find("token")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("data"), data)
+
+	require.NoError(t, sessions.Commit("expired", []byte("data"), time.Now().Add(-time.Hour)))
+	_, found, err = sessions.Find("expired")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMigrateDB(t *testing.T) {
+	db, backend, err := openDB("sqlite://" + filepath.Join(t.TempDir(), "snippetbox.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	require.NoError(t, migrateDB(db, backend, logger))
+	assert.Contains(t, logs.String(), "version=1 name=create_snippets_table")
+
+	version, dirty, err := store.NewHealthStore(db).MigrationVersion(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, schemaVersion, version)
+	assert.False(t, dirty)
+
+	// A migrated database is left as it is.
+	logs.Reset()
+	require.NoError(t, migrateDB(db, backend, logger))
+	assert.Empty(t, logs.String())
+}
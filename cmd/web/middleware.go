@@ -2,13 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/96malhar/snippetbox/internal/ratelimit"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
 )
 
-func secureHeaders(next http.Handler) http.Handler {
+func (app *application) secureHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		// Browsers ignore HSTS headers received over plain HTTP, and sending one
+		// from a server which can't be reached over HTTPS would lock users out.
+		if app.hstsHeader != "" && isSecure(r) {
+			w.Header().Set("Strict-Transport-Security", app.hstsHeader)
+		}
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' data:")
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -18,17 +40,223 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) logRequest(next http.Handler) http.Handler {
+// instrumentRequests records the number and the duration of requests by route
+// pattern, method and status code, and counts the requests in flight.
+func (app *application) instrumentRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ip     = r.RemoteAddr
-			proto  = r.Proto
-			method = r.Method
-			uri    = r.URL.RequestURI()
+		start := time.Now()
+		app.inFlight.Add(1)
+		defer app.inFlight.Add(-1)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		app.metrics.ObserveRequest(routePattern(r), requestMethod(r), responseStatus(ww), time.Since(start))
+	})
+}
+
+// traceRequests starts a span for every request, which continues the trace of the
+// client if it sent a traceparent header. The span is named after the route
+// pattern rather than the path, so that requests to the same route are grouped.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := app.tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		route := routePattern(r)
+		method := requestMethod(r)
+		status := responseStatus(ww)
+		span.SetName(method + " " + route)
+		span.SetAttributes(
+			semconv.HTTPRoute(route),
+			semconv.HTTPRequestMethodKey.String(method),
+			semconv.HTTPResponseStatusCode(status),
 		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
-		next.ServeHTTP(w, r)
+// routePattern returns the pattern of the route which matched the request. The
+// route context is filled in while routing, so the pattern is only known once the
+// request has been handled.
+func routePattern(r *http.Request) string {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || rctx.RoutePattern() == "" {
+		return "unmatched"
+	}
+	return rctx.RoutePattern()
+}
+
+// requestMethod returns the method of the request. Any string can be sent as the
+// method, so unknown ones are grouped together to keep the number of series and
+// span names bounded.
+func requestMethod(r *http.Request) string {
+	if !slices.Contains(knownMethods, r.Method) {
+		return "other"
+	}
+	return r.Method
+}
+
+var knownMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace,
+}
+
+// responseStatus returns the status code written to ww, which is 200 if the
+// handler wrote the body without calling WriteHeader, or nothing at all.
+func responseStatus(ww middleware.WrapResponseWriter) int {
+	if ww.Status() == 0 {
+		return http.StatusOK
+	}
+	return ww.Status()
+}
+
+// requestIDHeader carries the ID which ties the log lines of a request together,
+// and to the logs of the proxies in front of the server.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request IDs accepted from clients. Anything longer or
+// with other characters is replaced, so that clients can't forge log lines.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// requestID keeps the X-Request-ID header sent by the client or generates a new
+// ID, stores it in the request context and echoes it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			b := make([]byte, 16)
+			if _, err := rand.Read(b); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			id = hex.EncodeToString(b)
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// resolveClient stores the client of the request in its context, looking past the
+// trusted proxies. Requests which a trusted proxy received over plain HTTP are
+// redirected to HTTPS, since the session cookie is only sent over HTTPS.
+func (app *application) resolveClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := app.realIP.Client(r)
+		if client.Proto == "http" {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), clientContextKey, client)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// rateLimit rejects the requests of clients which exceeded the policy of the
+// route group called name with a 429 Too Many Requests status. Authenticated users
+// are limited by user when the policy has a per-user limit, other clients by IP
+// address. The limiter failing lets requests through, so that an outage of the
+// database doesn't lock every user out.
+func (app *application) rateLimit(name string, policy ratelimit.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if app.rateLimiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var key string
+			var limit ratelimit.Limit
+			if user := app.authenticatedUser(r); user != nil && !policy.PerUser.IsZero() {
+				key, limit = fmt.Sprintf("%s:user:%d", name, user.ID), policy.PerUser
+			} else if !policy.PerIP.IsZero() {
+				key, limit = fmt.Sprintf("%s:ip:%s", name, rateLimitIP(clientIP(r))), policy.PerIP
+			} else {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			res, err := app.rateLimiter.Take(r.Context(), key, limit)
+			if err != nil {
+				app.logger.Error("rate limiter failed", append([]any{"error", err.Error()}, logAttrs(r.Context())...)...)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			window := time.Duration(limit.Burst) * limit.Every
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Burst, int(window.Seconds())))
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(int(res.Reset.Seconds())))
+
+			if !res.Allowed {
+				app.logger.Warn("rate limit exceeded", append([]any{"limit", name, "key", key}, logAttrs(r.Context())...)...)
+				w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())))
+				app.clientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitIP returns the address anonymous clients are limited by. IPv6 clients
+// are usually given a whole /64, so they share one bucket per /64 instead of
+// getting one for every address they can pick.
+func rateLimitIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap().WithZone("")
+	if !addr.Is6() {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return ip
+	}
+	return prefix.String()
+}
+
+// accessLogEntry collects what is only known to the middlewares and handlers
+// which run after logRequest.
+type accessLogEntry struct {
+	userID int
+}
+
+// logRequest writes a line to the access log once the request has been handled.
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessLogEntry{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(context.WithValue(r.Context(), accessLogContextKey, entry)))
+
+		attrs := []any{
+			"ip", clientIP(r),
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"route", routePattern(r),
+			"status", responseStatus(ww),
+			"bytes", ww.BytesWritten(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, "user_id", entry.userID)
+		}
+		app.logger.Info("handled request", append(attrs, logAttrs(r.Context())...)...)
 	})
 }
 
@@ -61,8 +289,9 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 
 // authenticate middleware retrieves the userId from the request context and
 // checks whether the authenticatedUserID actually exists in the DB records.
-// If the userId exists in the DB, it sets "isAuthenticatedContextKey" to true in request context
-// before executing the next handler.
+// If the userId exists in the DB and the account isn't disabled, it sets
+// "isAuthenticatedContextKey" to true and "authenticatedUserContextKey" to the
+// user in request context before executing the next handler.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
@@ -71,17 +300,45 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		exists, err := app.userStore.Exists(id)
-		if err != nil {
+		user, err := app.userStore.Get(r.Context(), id)
+		if err != nil && !errors.Is(err, store.ErrNoRecord) {
 			app.serverError(w, r, err)
 			return
 		}
 
-		if exists {
+		if user != nil && !user.Disabled {
+			err = app.userSessionStore.Touch(app.sessionManager.Token(r.Context()))
+			if err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+
+			if entry, ok := r.Context().Value(accessLogContextKey).(*accessLogEntry); ok {
+				entry.userID = user.ID
+			}
+
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserContextKey, user)
 			r = r.WithContext(ctx)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// requireRole only lets users through who have at least the given role. It must
+// run after requireAuthentication.
+func (app *application) requireRole(role store.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.authenticatedUser(r)
+			if user == nil || !user.Role.Includes(role) {
+				app.logger.Warn("Role required", "role", role, "method", r.Method, "uri", r.URL.RequestURI())
+				app.clientError(w, r, http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,15 +3,31 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"github.com/96malhar/snippetbox/internal/config"
+	"github.com/96malhar/snippetbox/internal/ratelimit"
+	"github.com/96malhar/snippetbox/internal/realip"
 	"github.com/96malhar/snippetbox/internal/store"
 	"github.com/96malhar/snippetbox/internal/store/mocks"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -28,11 +44,12 @@ func TestSecureHeaders(t *testing.T) {
 		w.Write([]byte("OK"))
 	})
 
-	secureHeaders(next).ServeHTTP(rr, r)
+	app := newTestApplication(t)
+	app.secureHeaders(next).ServeHTTP(rr, r)
 	rs := rr.Result()
 
 	expectedHeaderValues := map[string]string{
-		"Content-Security-Policy": "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com",
+		"Content-Security-Policy": "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; img-src 'self' data:",
 		"Referrer-Policy":         "origin-when-cross-origin",
 		"X-Content-Type-Options":  "nosniff",
 		"X-Frame-Options":         "deny",
@@ -54,6 +71,490 @@ func TestSecureHeaders(t *testing.T) {
 	assert.Equal(t, "OK", string(body))
 }
 
+func TestSecureHeaders_HSTS(t *testing.T) {
+	tests := []struct {
+		name       string
+		hstsHeader string
+		tls        bool
+		wantHeader string
+	}{
+		{
+			name:       "TLS",
+			hstsHeader: "max-age=31536000",
+			tls:        true,
+			wantHeader: "max-age=31536000",
+		},
+		{
+			name:       "Plain HTTP",
+			hstsHeader: "max-age=31536000",
+		},
+		{
+			name: "Disabled",
+			tls:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.hstsHeader = tc.hstsHeader
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			rr := httptest.NewRecorder()
+			app.secureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, r)
+
+			assert.Equal(t, tc.wantHeader, rr.Result().Header.Get("Strict-Transport-Security"))
+		})
+	}
+}
+
+func TestTraceRequests(t *testing.T) {
+	const (
+		traceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentSpanID = "00f067aa0ba902b7"
+	)
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		traceparent  string
+		wantSpanName string
+		wantStatus   int
+		wantRender   bool
+		wantLogged   bool
+	}{
+		{
+			name:         "Named after the route pattern",
+			method:       http.MethodGet,
+			path:         "/snippet/view/1",
+			wantSpanName: "GET /snippet/view/{id}",
+			wantStatus:   http.StatusOK,
+			wantRender:   true,
+			wantLogged:   true,
+		},
+		{
+			name:         "Continues the trace of the client",
+			method:       http.MethodGet,
+			path:         "/snippet/view/1",
+			traceparent:  "00-" + traceID + "-" + parentSpanID + "-01",
+			wantSpanName: "GET /snippet/view/{id}",
+			wantStatus:   http.StatusOK,
+			wantRender:   true,
+			wantLogged:   true,
+		},
+		{
+			name:         "Unmatched route",
+			method:       http.MethodGet,
+			path:         "/does/not/exist",
+			wantSpanName: "GET unmatched",
+			wantStatus:   http.StatusNotFound,
+		},
+		{
+			name:         "Unknown method",
+			method:       "BREW",
+			path:         "/",
+			wantSpanName: "other unmatched",
+			wantStatus:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			defer provider.Shutdown(context.Background())
+
+			var logs bytes.Buffer
+			app := newTestApplication(t)
+			app.tracer = provider.Tracer("test")
+			app.logger = slog.New(slog.NewTextHandler(&logs, nil))
+			_, err := app.snippetStore.Insert(context.Background(), "Title", "Content", 7, 0)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.traceparent != "" {
+				r.Header.Set("traceparent", tc.traceparent)
+			}
+			rr := httptest.NewRecorder()
+			app.routes().ServeHTTP(rr, r)
+			require.Equal(t, tc.wantStatus, rr.Code)
+
+			spans := map[string]sdktrace.ReadOnlySpan{}
+			for _, span := range recorder.Ended() {
+				spans[span.Name()] = span
+			}
+
+			server, ok := spans[tc.wantSpanName]
+			require.True(t, ok, "no span named %q", tc.wantSpanName)
+			assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+			assert.Contains(t, server.Attributes(), semconv.HTTPResponseStatusCode(tc.wantStatus))
+
+			if tc.traceparent != "" {
+				assert.Equal(t, traceID, server.SpanContext().TraceID().String())
+				assert.Equal(t, parentSpanID, server.Parent().SpanID().String())
+			} else {
+				assert.False(t, server.Parent().IsValid())
+			}
+
+			render, ok := spans["app.render"]
+			assert.Equal(t, tc.wantRender, ok)
+			if ok {
+				assert.Equal(t, server.SpanContext().SpanID(), render.Parent().SpanID())
+				assert.Contains(t, render.Attributes(), attribute.String("page", "view.tmpl"))
+			}
+
+			if tc.wantLogged {
+				assert.Contains(t, logs.String(), "trace_id="+server.SpanContext().TraceID().String())
+			}
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		wantID string
+	}{
+		{
+			name: "Generated",
+		},
+		{
+			name:   "Kept",
+			header: "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+			wantID: "f47ac10b-58cc-4372-a567-0e02b2c3d479",
+		},
+		{
+			name:   "Too long",
+			header: strings.Repeat("a", 65),
+		},
+		{
+			name:   "Forged log line",
+			header: "abc msg=forged",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			var ctxID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = requestIDFromContext(r.Context())
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				r.Header.Set("X-Request-ID", tc.header)
+			}
+			rr := httptest.NewRecorder()
+			app.requestID(next).ServeHTTP(rr, r)
+
+			id := rr.Header().Get("X-Request-ID")
+			assert.Equal(t, id, ctxID)
+			if tc.wantID != "" {
+				assert.Equal(t, tc.wantID, id)
+			} else {
+				assert.Regexp(t, "^[0-9a-f]{32}$", id)
+			}
+		})
+	}
+}
+
+func TestLogRequest(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApplication(t)
+	app.logger = newLogger(&logs, config.LogFormatJSON, slog.LevelInfo)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	userID := loginAs(t, app, ts, "Alice", store.RoleUser)
+	logs.Reset()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/99", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "req-1")
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, string(body), "Request ID: req-1")
+
+	var line map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line), "expected a single log line, got %s", logs.String())
+	assert.Equal(t, "handled request", line["msg"])
+	assert.Equal(t, "GET", line["method"])
+	assert.Equal(t, "/snippet/view/99", line["uri"])
+	assert.Equal(t, "/snippet/view/{id}", line["route"])
+	assert.Equal(t, float64(http.StatusNotFound), line["status"])
+	assert.Equal(t, float64(len(body)), line["bytes"])
+	assert.Equal(t, float64(userID), line["user_id"])
+	assert.Equal(t, "req-1", line["request_id"])
+	assert.Contains(t, line, "latency_ms")
+}
+
+func TestLogRequest_Panic(t *testing.T) {
+	var logs bytes.Buffer
+	app := newTestApplication(t)
+	app.logger = newLogger(&logs, config.LogFormatJSON, slog.LevelInfo)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	app.requestID(app.logRequest(app.recoverPanic(next))).ServeHTTP(rr, r)
+
+	id := rr.Header().Get("X-Request-ID")
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, rr.Body.String(), "Request ID: "+id)
+
+	dec := json.NewDecoder(&logs)
+	var serverError, access map[string]any
+	require.NoError(t, dec.Decode(&serverError))
+	require.NoError(t, dec.Decode(&access))
+
+	assert.Equal(t, "boom", serverError["msg"])
+	assert.Equal(t, id, serverError["request_id"])
+	assert.Equal(t, "handled request", access["msg"])
+	assert.Equal(t, float64(http.StatusInternalServerError), access["status"])
+	assert.NotContains(t, access, "user_id")
+}
+
+func TestNewLogger_Level(t *testing.T) {
+	var logs bytes.Buffer
+	logger := newLogger(&logs, config.LogFormatText, slog.LevelWarn)
+	logger.Info("hidden")
+	logger.Warn("shown")
+
+	assert.NotContains(t, logs.String(), "hidden")
+	assert.Contains(t, logs.String(), "level=WARN msg=shown")
+}
+
+func TestResolveClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		headers      map[string]string
+		wantCode     int
+		wantIP       string
+		wantHSTS     bool
+		wantLocation string
+	}{
+		{
+			name:       "Direct connection",
+			remoteAddr: "203.0.113.7:51234",
+			wantCode:   http.StatusOK,
+			wantIP:     "203.0.113.7",
+		},
+		{
+			name:       "Spoofed headers from untrusted client",
+			remoteAddr: "203.0.113.7:51234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Forwarded-Proto": "https"},
+			wantCode:   http.StatusOK,
+			wantIP:     "203.0.113.7",
+		},
+		{
+			name:       "Trusted proxy over HTTPS",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7", "X-Forwarded-Proto": "https"},
+			wantCode:   http.StatusOK,
+			wantIP:     "203.0.113.7",
+			wantHSTS:   true,
+		},
+		{
+			name:         "Trusted proxy over plain HTTP",
+			remoteAddr:   "10.0.0.2:40000",
+			headers:      map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "http"},
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://snippetbox.example.com/snippet/view/1?x=y",
+		},
+		{
+			name:       "Trusted proxy without protocol",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			wantCode:   http.StatusOK,
+			wantIP:     "203.0.113.7",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.hstsHeader = "max-age=31536000"
+			realIP, err := realip.New([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, realip.HeaderXForwardedFor)
+			require.NoError(t, err)
+			app.realIP = realIP
+
+			var ip string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = clientIP(r)
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "http://snippetbox.example.com/snippet/view/1?x=y", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for name, v := range tc.headers {
+				r.Header.Set(name, v)
+			}
+			rr := httptest.NewRecorder()
+			app.resolveClient(app.secureHeaders(next)).ServeHTTP(rr, r)
+
+			assert.Equal(t, tc.wantCode, rr.Code)
+			assert.Equal(t, tc.wantIP, ip)
+			assert.Equal(t, tc.wantHSTS, rr.Header().Get("Strict-Transport-Security") != "")
+			assert.Equal(t, tc.wantLocation, rr.Header().Get("Location"))
+		})
+	}
+}
+
+// recordingLimiter records the keys of the buckets tokens are taken from.
+type recordingLimiter struct {
+	*ratelimit.MemoryLimiter
+	keys []string
+	err  error
+}
+
+func (l *recordingLimiter) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	l.keys = append(l.keys, key)
+	if l.err != nil {
+		return ratelimit.Result{}, l.err
+	}
+	return l.MemoryLimiter.Take(ctx, key, limit)
+}
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	limiter := &recordingLimiter{MemoryLimiter: ratelimit.NewMemoryLimiter()}
+	app.rateLimiter = limiter
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	form := url.Values{"email": {"nobody@example.com"}, "password": {"wrong"}}
+	for i := 0; i < loginRateLimit.PerIP.Burst; i++ {
+		resp := ts.postForm(t, "/user/login", form)
+		resp.Body.Close()
+		require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+		assert.Equal(t, "10", resp.Header.Get("RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(loginRateLimit.PerIP.Burst-i-1), resp.Header.Get("RateLimit-Remaining"))
+		assert.Equal(t, "10;w=60", resp.Header.Get("RateLimit-Policy"))
+	}
+
+	resp := ts.postForm(t, "/user/login", form)
+	body := getString(t, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Contains(t, body, "Too Many Requests")
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 6, retryAfter, 1)
+
+	// Other routes are only subject to the global limit.
+	resp = ts.get(t, "/user/login")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "100", resp.Header.Get("RateLimit-Limit"))
+
+	assert.Contains(t, limiter.keys, "global:ip:127.0.0.1")
+	assert.Contains(t, limiter.keys, "login:ip:127.0.0.1")
+}
+
+func TestRateLimit_Keys(t *testing.T) {
+	policy := ratelimit.Policy{
+		PerIP:   ratelimit.Limit{Burst: 1, Every: time.Hour},
+		PerUser: ratelimit.Limit{Burst: 2, Every: time.Hour},
+	}
+
+	tests := []struct {
+		name       string
+		policy     ratelimit.Policy
+		user       *store.User
+		remoteAddr string
+		wantKey    string
+	}{
+		{
+			name:    "Anonymous",
+			policy:  policy,
+			wantKey: "test:ip:192.0.2.1",
+		},
+		{
+			name:       "Anonymous IPv6",
+			policy:     policy,
+			remoteAddr: "[2001:db8:1:2:aaaa:bbbb:cccc:dddd]:51234",
+			wantKey:    "test:ip:2001:db8:1:2::/64",
+		},
+		{
+			name:       "Anonymous IPv4-mapped IPv6",
+			policy:     policy,
+			remoteAddr: "[::ffff:192.0.2.1]:51234",
+			wantKey:    "test:ip:192.0.2.1",
+		},
+		{
+			name:    "Authenticated",
+			policy:  policy,
+			user:    &store.User{ID: 7},
+			wantKey: "test:user:7",
+		},
+		{
+			name:    "Authenticated without per-user limit",
+			policy:  ratelimit.Policy{PerIP: policy.PerIP},
+			user:    &store.User{ID: 7},
+			wantKey: "test:ip:192.0.2.1",
+		},
+		{
+			name:   "Anonymous without per-IP limit",
+			policy: ratelimit.Policy{PerUser: policy.PerUser},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			limiter := &recordingLimiter{MemoryLimiter: ratelimit.NewMemoryLimiter()}
+			app.rateLimiter = limiter
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = "192.0.2.1:51234"
+			if tc.remoteAddr != "" {
+				r.RemoteAddr = tc.remoteAddr
+			}
+			if tc.user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), authenticatedUserContextKey, tc.user))
+			}
+			rr := httptest.NewRecorder()
+			app.rateLimit("test", tc.policy)(http.HandlerFunc(ping)).ServeHTTP(rr, r)
+
+			assert.Equal(t, http.StatusOK, rr.Code)
+			if tc.wantKey == "" {
+				assert.Empty(t, limiter.keys)
+			} else {
+				assert.Equal(t, []string{tc.wantKey}, limiter.keys)
+			}
+		})
+	}
+}
+
+func TestRateLimit_LimiterFailure(t *testing.T) {
+	app := newTestApplication(t)
+	app.rateLimiter = &recordingLimiter{err: errors.New("connection refused")}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	rr := httptest.NewRecorder()
+	app.rateLimit("test", loginRateLimit)(http.HandlerFunc(ping)).ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code, "requests are let through")
+	assert.Empty(t, rr.Header().Get("RateLimit-Limit"))
+}
+
 func TestRequireAuthentication(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -127,7 +628,7 @@ func TestAuthenticate(t *testing.T) {
 
 	testcases := []struct {
 		name                string
-		userStore           userStoreInterface
+		userStore           store.Users
 		wantIsAuthenticated string
 	}{
 		{
@@ -140,6 +641,11 @@ func TestAuthenticate(t *testing.T) {
 			userStore:           mocks.NewMockUserStore(),
 			wantIsAuthenticated: "false",
 		},
+		{
+			name:                "User is disabled",
+			userStore:           mocks.NewMockUserStore(&store.User{ID: 1, Name: "John", Disabled: true}),
+			wantIsAuthenticated: "false",
+		},
 	}
 
 	for _, tc := range testcases {
@@ -155,3 +661,64 @@ func TestAuthenticate(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *store.User
+		role       store.Role
+		wantStatus int
+	}{
+		{
+			name:       "Admin accessing admin area",
+			user:       &store.User{ID: 1, Role: store.RoleAdmin},
+			role:       store.RoleAdmin,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Admin accessing moderator area",
+			user:       &store.User{ID: 1, Role: store.RoleAdmin},
+			role:       store.RoleModerator,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Moderator accessing admin area",
+			user:       &store.User{ID: 1, Role: store.RoleModerator},
+			role:       store.RoleAdmin,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "User accessing moderator area",
+			user:       &store.User{ID: 1, Role: store.RoleUser},
+			role:       store.RoleModerator,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "No user in context",
+			role:       store.RoleUser,
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+
+			ctx := context.Background()
+			if tc.user != nil {
+				ctx = context.WithValue(ctx, authenticatedUserContextKey, tc.user)
+			}
+
+			rr := httptest.NewRecorder()
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+			require.NoError(t, err)
+
+			app.requireRole(tc.role)(next).ServeHTTP(rr, r)
+			assert.Equal(t, tc.wantStatus, rr.Code)
+		})
+	}
+}
@@ -1,24 +1,50 @@
 package main
 
 import (
+	"github.com/96malhar/snippetbox/internal/ratelimit"
+	"github.com/96malhar/snippetbox/internal/store"
 	"github.com/96malhar/snippetbox/ui"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"net"
 	"net/http"
+	"strings"
+	"time"
+)
+
+// The rate limits of the route groups. The global limit applies to every route
+// on top of the others.
+var (
+	// 10 requests per second with bursts of 100, enough to load a page and its
+	// static files many times over.
+	globalRateLimit = ratelimit.Policy{PerIP: ratelimit.Limit{Burst: 100, Every: 100 * time.Millisecond}}
+	// 10 login attempts per minute, to slow down password guessing.
+	loginRateLimit = ratelimit.Policy{PerIP: ratelimit.Limit{Burst: 10, Every: 6 * time.Second}}
+	// 5 accounts per hour.
+	signupRateLimit = ratelimit.Policy{PerIP: ratelimit.Limit{Burst: 5, Every: 12 * time.Minute}}
+	// 30 snippets created, edited or reported per hour.
+	writeRateLimit = ratelimit.Policy{
+		PerIP:   ratelimit.Limit{Burst: 10, Every: 2 * time.Minute},
+		PerUser: ratelimit.Limit{Burst: 10, Every: 2 * time.Minute},
+	}
 )
 
 func (app *application) routes() http.Handler {
 	r := chi.NewRouter()
+	r.Use(app.requestID, app.resolveClient, app.traceRequests, app.instrumentRequests)
+	r.Use(app.rateLimit("global", globalRateLimit))
 	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-		app.notFound(w)
+		app.notFound(w, r)
 	})
 
 	fileServer := http.FileServer(http.FS(ui.Files))
 	r.Method(http.MethodGet, "/static/*", fileServer)
 	r.Get("/ping", ping)
+	r.Get("/healthz", app.healthz)
+	r.Get("/readyz", app.readyz)
 
 	standardMiddlewares := []func(handler http.Handler) http.Handler{
-		app.recoverPanic, middleware.StripSlashes, app.logRequest, middleware.GetHead, secureHeaders,
+		app.logRequest, app.recoverPanic, middleware.StripSlashes, middleware.GetHead, app.secureHeaders,
 	}
 
 	r.Group(func(r chi.Router) {
@@ -27,20 +53,111 @@ func (app *application) routes() http.Handler {
 		r.Get("/", app.home)
 		r.Get("/about", app.about)
 		r.Get("/snippet/view/{id}", app.snippetView)
-		r.Get("/user/signup", app.userSignup)
-		r.Post("/user/signup", app.userSignupPost)
+		r.With(app.rateLimit("write", writeRateLimit)).Post("/snippet/report/{id}", app.snippetReportPost)
 		r.Get("/user/login", app.userLogin)
-		r.Post("/user/login", app.userLoginPost)
+		if !app.passwordLoginDisabled {
+			r.Get("/user/signup", app.userSignup)
+			r.With(app.rateLimit("signup", signupRateLimit)).Post("/user/signup", app.userSignupPost)
+			r.With(app.rateLimit("login", loginRateLimit)).Post("/user/login", app.userLoginPost)
+			r.Get("/user/reset/{token}", app.userPasswordReset)
+			r.With(app.rateLimit("login", loginRateLimit)).Post("/user/reset/{token}", app.userPasswordResetPost)
+		}
+		r.Get("/user/login/oidc", app.userLoginOIDC)
+		r.Get("/user/login/oidc/callback", app.userLoginOIDCCallback)
+		r.Get("/user/login/verify", app.userLoginVerify)
+		r.With(app.rateLimit("login", loginRateLimit)).Post("/user/login/verify", app.userLoginVerifyPost)
 	})
 
 	r.Group(func(r chi.Router) {
 		r.Use(standardMiddlewares...)
 		r.Use(app.sessionManager.LoadAndSave, app.authenticate, app.requireAuthentication)
 		r.Get("/snippet/create", app.snippetCreate)
-		r.Post("/snippet/create", app.snippetCreatePost)
+		r.With(app.rateLimit("write", writeRateLimit)).Post("/snippet/create", app.snippetCreatePost)
+		r.Get("/snippet/edit/{id}", app.snippetEdit)
+		r.With(app.rateLimit("write", writeRateLimit)).Post("/snippet/edit/{id}", app.snippetEditPost)
 		r.Post("/user/logout", app.userLogoutPost)
 		r.Get("/account/view", app.accountView)
+		if !app.passwordLoginDisabled {
+			r.Get("/account/password", app.accountPassword)
+			r.Post("/account/password", app.accountPasswordPost)
+		}
+		r.Get("/account/sessions", app.accountSessions)
+		r.Post("/account/sessions/revoke", app.accountSessionRevokePost)
+		r.Post("/account/sessions/revoke-others", app.accountSessionsRevokeOthersPost)
+		r.Get("/account/2fa", app.accountTwoFactor)
+		r.Post("/account/2fa/enable", app.accountTwoFactorEnablePost)
+		r.Post("/account/2fa/disable", app.accountTwoFactorDisablePost)
+		r.Get("/teams", app.teams)
+		r.Post("/teams/create", app.teamCreatePost)
+		r.Get("/teams/join/{token}", app.teamJoin)
+		r.Post("/teams/join/{token}", app.teamJoinPost)
+		r.Post("/teams/invites/accept", app.teamInviteAcceptPost)
+		r.Get("/team/{slug}", app.teamView)
+		r.Post("/team/{slug}/invite", app.teamInvitePost)
+		r.Post("/team/{slug}/leave", app.teamLeavePost)
+		r.Post("/team/{slug}/members/role", app.teamMemberRolePost)
+		r.Post("/team/{slug}/members/remove", app.teamMemberRemovePost)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(standardMiddlewares...)
+		r.Use(app.sessionManager.LoadAndSave, app.authenticate, app.requireAuthentication, app.requireRole(store.RoleAdmin))
+		r.Get("/admin", app.adminDashboard)
+		r.Get("/admin/users", app.adminUsers)
+		r.Post("/admin/users/disable", app.adminUserDisablePost)
+		r.Post("/admin/users/enable", app.adminUserEnablePost)
+		r.Post("/admin/users/role", app.adminUserRolePost)
+		if !app.passwordLoginDisabled {
+			r.Post("/admin/users/reset-password", app.adminUserResetPasswordPost)
+		}
+		r.Get("/admin/snippets", app.adminSnippets)
+		r.Post("/admin/snippets/expire", app.adminSnippetExpirePost)
+		r.Post("/admin/snippets/delete", app.adminSnippetDeletePost)
+		r.Get("/admin/audit", app.adminAudit)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(standardMiddlewares...)
+		r.Use(app.sessionManager.LoadAndSave, app.authenticate, app.requireAuthentication, app.requireRole(store.RoleModerator))
+		r.Get("/moderation", app.moderationQueue)
+		r.Post("/moderation/dismiss", app.moderationDismissPost)
+		r.Post("/moderation/hide", app.moderationHidePost)
+		r.Post("/moderation/delete", app.moderationDeletePost)
 	})
 
 	return r
 }
+
+// redirectRoutes returns the handler of the plain HTTP listener, which redirects
+// every request to the same URL on the HTTPS server listening at httpsAddr, except
+// for /ping so that health checks keep working over plain HTTP.
+func (app *application) redirectRoutes(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	r := chi.NewRouter()
+	r.Use(app.recoverPanic)
+	r.Get("/ping", ping)
+	r.Handle("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	return r
+}
+
+// adminRoutes returns the handler of the admin listener, which is meant to only
+// be reachable from inside the deployment.
+func (app *application) adminRoutes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(app.recoverPanic)
+	r.Method(http.MethodGet, "/metrics", app.metrics.Handler())
+	return r
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backgroundTask is a long-running job which runs alongside the server. It must
+// return once its context is cancelled.
+type backgroundTask func(ctx context.Context)
+
+// listener is an HTTP server along with the function which makes it accept
+// connections, like its ListenAndServe method.
+type listener struct {
+	srv    *http.Server
+	listen func() error
+}
+
+// serve starts the background tasks and the listeners, and runs until one of the
+// listeners fails or the process receives SIGINT or SIGTERM. On a signal the
+// listeners stop accepting connections and in-flight requests are given up to
+// shutdownTimeout to complete. Then the background tasks are stopped and waited
+// for.
+func (app *application) serve(shutdownTimeout time.Duration, listeners ...listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, task := range app.backgroundTasks {
+		wg.Add(1)
+		go func(task backgroundTask) {
+			defer wg.Done()
+			task(backgroundCtx)
+		}(task)
+	}
+	defer func() {
+		app.logger.Info("stopping background tasks")
+		stopBackground()
+		wg.Wait()
+		app.logger.Info("background tasks stopped")
+	}()
+
+	listenErrs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l listener) {
+			app.logger.Info("starting server", "addr", l.srv.Addr)
+			listenErrs <- l.listen()
+		}(l)
+	}
+
+	var err error
+	remaining := len(listeners)
+	select {
+	case err = <-listenErrs:
+		remaining--
+	case <-ctx.Done():
+		// Restore the default behaviour of the signals, so that a second one
+		// kills the process right away.
+		stop()
+	}
+
+	app.logger.Info("marking server as not ready")
+	app.shuttingDown.Store(true)
+	if app.shutdownDelay > 0 && err == nil {
+		app.logger.Info("waiting for load balancers to stop sending requests", "delay", app.shutdownDelay.String())
+		time.Sleep(app.shutdownDelay)
+	}
+
+	app.logger.Info("shutting down server", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	shutdownErrs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(srv *http.Server) {
+			err := srv.Shutdown(shutdownCtx)
+			if err != nil {
+				app.logger.Error("in-flight requests didn't complete in time, closing connections", "addr", srv.Addr)
+				srv.Close()
+			}
+			shutdownErrs <- err
+		}(l.srv)
+	}
+	for range listeners {
+		err = errors.Join(err, <-shutdownErrs)
+	}
+
+	for i := 0; i < remaining; i++ {
+		if listenErr := <-listenErrs; !errors.Is(listenErr, http.ErrServerClosed) {
+			err = errors.Join(err, listenErr)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	app.logger.Info("server stopped")
+	return nil
+}
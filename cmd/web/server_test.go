@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startSlowServer serves a handler which takes handlerDelay to respond, and sends
+// a request to it. It returns once the request is being handled, along with
+// channels receiving the response and the result of app.serve.
+func startSlowServer(t *testing.T, app *application, handlerDelay, shutdownTimeout time.Duration) (<-chan *http.Response, <-chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	srv := &http.Server{
+		Addr: l.Addr().String(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			time.Sleep(handlerDelay)
+			w.Write([]byte("done"))
+		}),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- app.serve(shutdownTimeout, listener{srv: srv, listen: func() error { return srv.Serve(l) }})
+	}()
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			close(responses)
+			return
+		}
+		responses <- res
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't received by the server")
+	}
+
+	return responses, serveErr
+}
+
+func sendSignal(t *testing.T, sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, p.Signal(sig))
+}
+
+func TestApplication_Serve(t *testing.T) {
+	t.Run("In-flight requests complete", func(t *testing.T) {
+		app := newTestApplication(t)
+		taskStopped := make(chan struct{})
+		app.backgroundTasks = []backgroundTask{
+			func(ctx context.Context) {
+				<-ctx.Done()
+				close(taskStopped)
+			},
+		}
+
+		responses, serveErr := startSlowServer(t, app, 200*time.Millisecond, 5*time.Second)
+		sendSignal(t, syscall.SIGTERM)
+
+		res, ok := <-responses
+		require.True(t, ok, "request failed")
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "done", string(body))
+
+		assert.NoError(t, <-serveErr)
+		select {
+		case <-taskStopped:
+		default:
+			t.Error("background task wasn't stopped")
+		}
+	})
+
+	t.Run("Shutdown timeout", func(t *testing.T) {
+		app := newTestApplication(t)
+
+		responses, serveErr := startSlowServer(t, app, 2*time.Second, 50*time.Millisecond)
+		sendSignal(t, syscall.SIGINT)
+
+		assert.ErrorIs(t, <-serveErr, context.DeadlineExceeded)
+		_, ok := <-responses
+		assert.False(t, ok, "request should have been cut off")
+	})
+
+	t.Run("Readiness fails before the listener closes", func(t *testing.T) {
+		app := newTestApplication(t)
+		app.shutdownDelay = 300 * time.Millisecond
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		srv := &http.Server{Handler: app.routes()}
+
+		serveErr := make(chan error, 1)
+		go func() {
+			serveErr <- app.serve(time.Second, listener{srv: srv, listen: func() error { return srv.Serve(l) }})
+		}()
+
+		readyz := func() int {
+			res, err := http.Get("http://" + l.Addr().String() + "/readyz")
+			if err != nil {
+				return 0
+			}
+			res.Body.Close()
+			return res.StatusCode
+		}
+		require.Eventually(t, func() bool { return readyz() == http.StatusOK }, 5*time.Second, 10*time.Millisecond)
+
+		sendSignal(t, syscall.SIGTERM)
+		assert.Eventually(t, func() bool { return readyz() == http.StatusServiceUnavailable }, 5*time.Second, 10*time.Millisecond)
+		assert.NoError(t, <-serveErr)
+	})
+
+	t.Run("Failing listener stops the others", func(t *testing.T) {
+		app := newTestApplication(t)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		srv := &http.Server{Handler: http.HandlerFunc(ping)}
+		failing := &http.Server{}
+		listenErr := errors.New("address already in use")
+
+		err = app.serve(time.Second,
+			listener{srv: srv, listen: func() error { return srv.Serve(l) }},
+			listener{srv: failing, listen: func() error { return listenErr }},
+		)
+		assert.ErrorIs(t, err, listenErr)
+	})
+}
+
+func TestRedirectRoutes(t *testing.T) {
+	tests := []struct {
+		name         string
+		httpsAddr    string
+		url          string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Default HTTPS port",
+			httpsAddr:    ":443",
+			url:          "http://snippetbox.example.com/snippet/view/1?page=2",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://snippetbox.example.com/snippet/view/1?page=2",
+		},
+		{
+			name:         "Custom HTTPS port",
+			httpsAddr:    ":4000",
+			url:          "http://localhost:8080/user/login",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://localhost:4000/user/login",
+		},
+		{
+			name:         "IPv6 host",
+			httpsAddr:    ":443",
+			url:          "http://[::1]:8080/",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://[::1]/",
+		},
+		{
+			name:      "Health check",
+			httpsAddr: ":4000",
+			url:       "http://localhost:8080/ping",
+			wantCode:  http.StatusOK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			rr := httptest.NewRecorder()
+			app.redirectRoutes(tc.httpsAddr).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tc.url, nil))
+
+			res := rr.Result()
+			assert.Equal(t, tc.wantCode, res.StatusCode)
+			assert.Equal(t, tc.wantLocation, res.Header.Get("Location"))
+		})
+	}
+}
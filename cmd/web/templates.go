@@ -1,7 +1,9 @@
 package main
 
 import (
+	"github.com/96malhar/snippetbox/internal/audit"
 	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/validation"
 	"github.com/96malhar/snippetbox/ui"
 	"html/template"
 	"io/fs"
@@ -15,13 +17,38 @@ import (
 // At the moment it only contains one field, but we'll add more
 // to it as the build progresses.
 type templateData struct {
-	Snippet         *store.Snippet
-	Snippets        []*store.Snippet
-	CurrentYear     int
-	Form            any
-	Flash           string
-	IsAuthenticated bool
-	User            *store.User
+	Snippet              *store.Snippet
+	Snippets             []*store.Snippet
+	CurrentYear          int
+	Form                 any
+	Flash                string
+	IsAuthenticated      bool
+	User                 *store.User
+	UserSessions         []*store.UserSession
+	CurrentUserSessionID int
+	TOTPSecret           string
+	TOTPQRCode           template.URL
+	RecoveryCodes        []string
+	OIDCEnabled          bool
+	PasswordLoginEnabled bool
+	IsAdmin              bool
+	AdminStats           adminStats
+	Users                []*store.User
+	Query                string
+	IsModerator          bool
+	Reports              []*store.Report
+	ModerationActions    []*store.ModerationAction
+	AuditEvents          []*audit.Event
+	EventTypes           []string
+	Team                 *store.Team
+	Teams                []*store.Team
+	TeamMembers          []*store.TeamMember
+	TeamInvites          []*store.TeamInvite
+	IsTeamOwner          bool
+	InviteLink           string
+	PasswordResetLink    string
+	CanEditSnippet       bool
+	Challenge            *validation.Challenge
 }
 
 func humanDate(t time.Time) string {
@@ -59,9 +86,15 @@ func newTemplateCache() (map[string]*template.Template, error) {
 }
 
 func (app *application) newTemplateData(r *http.Request) templateData {
+	user := app.authenticatedUser(r)
+
 	return templateData{
-		CurrentYear:     time.Now().Year(),
-		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
-		IsAuthenticated: app.isAuthenticated(r),
+		CurrentYear:          time.Now().Year(),
+		Flash:                app.sessionManager.PopString(r.Context(), "flash"),
+		IsAuthenticated:      app.isAuthenticated(r),
+		OIDCEnabled:          app.oidcProvider != nil,
+		PasswordLoginEnabled: !app.passwordLoginDisabled,
+		IsAdmin:              user != nil && user.Role.Includes(store.RoleAdmin),
+		IsModerator:          user != nil && user.Role.Includes(store.RoleModerator),
 	}
 }
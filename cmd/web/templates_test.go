@@ -48,6 +48,10 @@ func TestNewTemplateCache(t *testing.T) {
 
 	expectedCacheEntries := []string{
 		"create.tmpl", "home.tmpl", "login.tmpl", "signup.tmpl", "view.tmpl", "about.tmpl", "account.tmpl",
+		"sessions.tmpl", "loginverify.tmpl", "twofactor.tmpl", "recoverycodes.tmpl",
+		"admin.tmpl", "adminusers.tmpl", "adminsnippets.tmpl", "moderation.tmpl", "adminaudit.tmpl",
+		"edit.tmpl", "teams.tmpl", "team.tmpl", "teamjoin.tmpl",
+		"password.tmpl", "reset.tmpl",
 	}
 
 	assert.Equal(t, len(expectedCacheEntries), len(cache))
@@ -2,9 +2,16 @@ package main
 
 import (
 	"bytes"
+	auditMocks "github.com/96malhar/snippetbox/internal/audit/mocks"
+	datetimeMocks "github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/metrics"
+	"github.com/96malhar/snippetbox/internal/realip"
+	"github.com/96malhar/snippetbox/internal/secrets"
 	"github.com/96malhar/snippetbox/internal/store/mocks"
+	"github.com/96malhar/snippetbox/internal/validation"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"go.opentelemetry.io/otel/trace/noop"
 	"io"
 	"log/slog"
 	"net/http"
@@ -34,13 +41,43 @@ func newTestApplication(t *testing.T) *application {
 	sessionManager.Lifetime = 12 * time.Hour
 	sessionManager.Cookie.Secure = true
 
+	realIP, err := realip.New(nil, realip.HeaderXForwardedFor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secretScanner, err := secrets.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breachedPasswords, err := validation.LoadBloomFilter("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippetStore := mocks.NewMockSnippetStore()
+	userStore := mocks.NewMockUserStore()
+
 	return &application{
-		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
-		snippetStore:   mocks.NewMockSnippetStore(), // Use the mock.
-		userStore:      mocks.NewMockUserStore(),    // Use the mock.
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		snippetStore:     snippetStore,                               // Use the mock.
+		userStore:        userStore,                                  // Use the mock.
+		userSessionStore: mocks.NewMockUserSessionStore(),            // Use the mock.
+		teamStore:        mocks.NewMockTeamStore(userStore),          // Use the mock.
+		moderationStore:  mocks.NewMockModerationStore(snippetStore), // Use the mock.
+		auditLog:         auditMocks.NewMockLog(),                    // Use the mock.
+		templateCache:    templateCache,
+		formDecoder:      formDecoder,
+		sessionManager:   sessionManager,
+		datetimeHandler:  datetimeMocks.NewMockDateTimeHandler(time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC)),
+		healthStore:      mocks.NewMockHealthStore(schemaVersion), // Use the mock.
+		readinessTimeout: time.Second,
+		metrics:          metrics.New(),
+		tracer:           noop.NewTracerProvider().Tracer(""),
+		realIP:           realIP,
+		secretScanner:    secretScanner,
+		passwordPolicy:   &validation.PasswordPolicy{MinLength: minPasswordLength, MinEntropy: 30, Breached: breachedPasswords},
 	}
 }
 
@@ -72,6 +109,19 @@ func newTestServer(t *testing.T, h http.Handler) *testServer {
 	return &testServer{ts}
 }
 
+// newClient returns a client for the test server with its own cookie jar, which
+// behaves like a second device of the same user.
+func (ts *testServer) newClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := *ts.Client()
+	client.Jar = jar
+	return &client
+}
+
 // get makes a GET request to a given url path using the test server client, and returns the
 // response status code, headers and body.
 func (ts *testServer) get(t *testing.T, urlPath string) *http.Response {
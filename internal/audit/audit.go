@@ -0,0 +1,187 @@
+// Package audit records security-relevant events, like logins and deleted
+// snippets, in an append-only log so that they can be reviewed later.
+package audit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"io"
+	"strings"
+	"time"
+)
+
+// The types of events recorded in the audit log.
+const (
+	EventSignup         = "signup"
+	EventLoginSuccess   = "login.success"
+	EventLoginFailure   = "login.failure"
+	EventLogout         = "logout"
+	EventPasswordChange = "password.change"
+	EventPasswordReset  = "password.reset"
+	EventSnippetCreate  = "snippet.create"
+	EventSnippetUpdate  = "snippet.update"
+	EventSnippetDelete  = "snippet.delete"
+)
+
+// EventTypes lists every event type, in the order they are offered as filters.
+var EventTypes = []string{
+	EventSignup, EventLoginSuccess, EventLoginFailure, EventLogout, EventPasswordChange,
+	EventPasswordReset, EventSnippetCreate, EventSnippetUpdate, EventSnippetDelete,
+}
+
+// Event is a single entry of the audit log. ActorID is 0 when the event isn't
+// tied to a known user.
+type Event struct {
+	ID        int             `json:"id"`
+	Type      string          `json:"type"`
+	ActorID   int             `json:"actor_id,omitempty"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	Payload   json.RawMessage `json:"payload"`
+	Created   time.Time       `json:"created"`
+}
+
+// Filter narrows down the events returned by List and Export. Zero values
+// match every event.
+type Filter struct {
+	Type    string
+	ActorID int
+	Since   time.Time
+	Until   time.Time
+}
+
+// where returns the WHERE clause, if any, and its arguments for the filter.
+func (f Filter) where() (string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Type != "" {
+		add("type = $%d", f.Type)
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if !f.Since.IsZero() {
+		add("created >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created < $%d", f.Until)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Log is the audit log. It only ever inserts events, the table itself rejects
+// updates and deletes.
+type Log struct {
+	db              *sql.DB
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+}
+
+func NewLog(db *sql.DB) *Log {
+	return &Log{db: db, datetimeHandler: &datetime.Handler{}}
+}
+
+// Record appends an event to the log. The ID and Created fields of the event
+// are ignored, and an empty payload is stored as an empty JSON object.
+func (l *Log) Record(e Event) error {
+	stmt := `INSERT INTO audit_events (type, actor_id, ip, user_agent, payload, created)
+	VALUES($1, $2, $3, $4, $5, $6)`
+
+	var actorID sql.NullInt64
+	if e.ActorID != 0 {
+		actorID = sql.NullInt64{Int64: int64(e.ActorID), Valid: true}
+	}
+
+	payload := []byte(e.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	_, err := l.db.Exec(stmt, e.Type, actorID, e.IP, e.UserAgent, payload, l.datetimeHandler.GetCurrentTimeUTC())
+	return err
+}
+
+// List returns up to limit of the most recent events matching the filter.
+func (l *Log) List(f Filter, limit int) ([]*Event, error) {
+	where, args := f.where()
+	args = append(args, limit)
+	stmt := fmt.Sprintf(`SELECT id, type, actor_id, ip, user_agent, payload, created
+	FROM audit_events%s ORDER BY id DESC LIMIT $%d`, where, len(args))
+
+	rows, err := l.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Export writes every event matching the filter to w as JSON Lines, oldest
+// first. Events are streamed, so the log doesn't have to fit in memory.
+func (l *Log) Export(w io.Writer, f Filter) error {
+	where, args := f.where()
+	stmt := fmt.Sprintf(`SELECT id, type, actor_id, ip, user_agent, payload, created
+	FROM audit_events%s ORDER BY id`, where)
+
+	rows, err := l.db.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err = enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func scanEvent(rows *sql.Rows) (*Event, error) {
+	var e Event
+	var actorID sql.NullInt64
+	var payload []byte
+
+	err := rows.Scan(&e.ID, &e.Type, &actorID, &e.IP, &e.UserAgent, &payload, &e.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	e.ActorID = int(actorID.Int64)
+	e.Payload = payload
+	e.Created = e.Created.UTC()
+	return &e, nil
+}
@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestFilter_where(t *testing.T) {
+	since := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2022, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	testcases := []struct {
+		name      string
+		filter    Filter
+		wantWhere string
+		wantArgs  []any
+	}{
+		{
+			name: "Empty filter",
+		},
+		{
+			name:      "Type only",
+			filter:    Filter{Type: EventLogout},
+			wantWhere: " WHERE type = $1",
+			wantArgs:  []any{EventLogout},
+		},
+		{
+			name:      "All fields",
+			filter:    Filter{Type: EventLoginFailure, ActorID: 3, Since: since, Until: until},
+			wantWhere: " WHERE type = $1 AND actor_id = $2 AND created >= $3 AND created < $4",
+			wantArgs:  []any{EventLoginFailure, 3, since, until},
+		},
+		{
+			name:      "Time range",
+			filter:    Filter{Since: since, Until: until},
+			wantWhere: " WHERE created >= $1 AND created < $2",
+			wantArgs:  []any{since, until},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			where, args := tc.filter.where()
+			assert.Equal(t, tc.wantWhere, where)
+			assert.Equal(t, tc.wantArgs, args)
+		})
+	}
+}
+
+func TestLog_RecordAndList(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db := newTestDB(t)
+
+	l := NewLog(db)
+	mockCurrTime := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	l.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	err := l.Record(Event{Type: EventLogout, ActorID: 1, IP: "192.0.2.2", UserAgent: "Firefox"})
+	require.NoError(t, err)
+
+	events, err := l.List(Filter{}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 4)
+	assert.Equal(t, &Event{
+		ID:        4,
+		Type:      EventLogout,
+		ActorID:   1,
+		IP:        "192.0.2.2",
+		UserAgent: "Firefox",
+		Payload:   json.RawMessage("{}"),
+		Created:   mockCurrTime,
+	}, events[0])
+	assert.Equal(t, 1, events[3].ID)
+	assert.Equal(t, 0, events[3].ActorID)
+
+	events, err = l.List(Filter{ActorID: 1, Until: time.Date(2022, 1, 2, 0, 0, 0, 0, time.UTC)}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventLoginSuccess, events[0].Type)
+
+	events, err = l.List(Filter{}, 2)
+	require.NoError(t, err)
+	assert.Len(t, events, 2)
+}
+
+func TestLog_AppendOnly(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db := newTestDB(t)
+
+	_, err := db.Exec("UPDATE audit_events SET actor_id = 2 WHERE id = 1")
+	assert.ErrorContains(t, err, "append-only")
+
+	_, err = db.Exec("DELETE FROM audit_events")
+	assert.ErrorContains(t, err, "append-only")
+}
+
+func TestLog_Export(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db := newTestDB(t)
+
+	l := NewLog(db)
+
+	var buf bytes.Buffer
+	err := l.Export(&buf, Filter{Type: EventLoginFailure})
+	require.NoError(t, err)
+
+	want := `{"id":1,"type":"login.failure","ip":"192.0.2.1","user_agent":"curl/8.0",` +
+		`"payload":{"email":"john@example.com"},"created":"2022-01-01T10:00:00Z"}` + "\n"
+	assert.Equal(t, want, buf.String())
+
+	buf.Reset()
+	err = l.Export(&buf, Filter{})
+	require.NoError(t, err)
+	assert.Equal(t, 3, bytes.Count(buf.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,37 @@
+package mocks
+
+import (
+	"github.com/96malhar/snippetbox/internal/audit"
+	"time"
+)
+
+type MockLog struct {
+	events []*audit.Event
+}
+
+func (m *MockLog) Record(e audit.Event) error {
+	e.ID = len(m.events) + 1
+	if len(e.Payload) == 0 {
+		e.Payload = []byte("{}")
+	}
+	e.Created = time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+	m.events = append(m.events, &e)
+	return nil
+}
+
+// List filters by type and actor only, every mock event is recorded at the
+// same time.
+func (m *MockLog) List(f audit.Filter, limit int) ([]*audit.Event, error) {
+	var events []*audit.Event
+	for i := len(m.events) - 1; i >= 0 && len(events) < limit; i-- {
+		e := m.events[i]
+		if (f.Type == "" || e.Type == f.Type) && (f.ActorID == 0 || e.ActorID == f.ActorID) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func NewMockLog() *MockLog {
+	return &MockLog{}
+}
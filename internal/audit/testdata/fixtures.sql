@@ -0,0 +1,8 @@
+INSERT INTO audit_events (type, actor_id, ip, user_agent, payload, created)
+VALUES ('login.failure', NULL, '192.0.2.1', 'curl/8.0', '{"email": "john@example.com"}', '2022-01-01 10:00:00');
+
+INSERT INTO audit_events (type, actor_id, ip, user_agent, payload, created)
+VALUES ('login.success', 1, '192.0.2.1', 'curl/8.0', '{}', '2022-01-01 10:01:00');
+
+INSERT INTO audit_events (type, actor_id, ip, user_agent, payload, created)
+VALUES ('snippet.create', 1, '192.0.2.1', 'curl/8.0', '{"snippet_id": 1}', '2022-01-02 10:00:00');
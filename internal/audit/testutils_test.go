@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/96malhar/snippetbox/internal/migrate"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	randomSuffix := strings.Split(uuid.New().String(), "-")[0]
+	testDBName := fmt.Sprintf("snippetbox_test_%s", randomSuffix)
+
+	db := getDBConn(t, "postgres", "postgres", "postgres")
+	_, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", testDBName))
+	if err != nil {
+		t.Fatalf("Failed to create database %s. Err = %s", testDBName, err)
+	}
+	db.Close()
+
+	db = getDBConn(t, "postgres", "postgres", testDBName)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDBName)
+	})
+
+	// The schema comes from the migrations, like the application's does.
+	m, err := migrate.New(db, store.Postgres)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := os.ReadFile("./testdata/fixtures.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(string(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func dropDB(t *testing.T, dbName string) {
+	db := getDBConn(t, "postgres", "postgres", "postgres")
+	defer db.Close()
+	_, err := db.Exec(fmt.Sprintf("DROP DATABASE %s", dbName))
+	if err != nil {
+		t.Fatalf("Failed to drop database %s. Err = %s", dbName, err)
+	}
+}
+
+func getDBConn(t *testing.T, user, password, dbname string) *sql.DB {
+	dsn := fmt.Sprintf("host=localhost port=5432 user=%s password=%s sslmode=disable dbname=%s", user, password, dbname)
+	db, _ := sql.Open("postgres", dsn)
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to connect to postgres with DSN = %s\nError = %s", dsn, err)
+	}
+	return db
+}
@@ -0,0 +1,614 @@
+// Package config loads the settings of the web application. Every setting has a
+// default which can be overridden by a JSON config file, then by an environment
+// variable and finally by a command line flag.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/oidc"
+	"github.com/96malhar/snippetbox/internal/realip"
+	"github.com/96malhar/snippetbox/internal/tracing"
+	"github.com/96malhar/snippetbox/internal/validation"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"math"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of the web application.
+type Config struct {
+	Addr                  string
+	BaseURL               string
+	DSN                   string
+	QueryTimeout          time.Duration
+	DBMigrate             bool
+	PlainHTTP             bool
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSReloadInterval     time.Duration
+	RedirectAddr          string
+	AdminAddr             string
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	TrustedProxies        []netip.Prefix
+	ProxyHeader           string
+	SessionLifetime       time.Duration
+	PasswordHasher        string
+	BcryptCost            int
+	Argon2Memory          int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	PasswordMinEntropy    int
+	BreachedPasswordsFile string
+	ShutdownTimeout       time.Duration
+	ShutdownDelay         time.Duration
+	ReadinessTimeout      time.Duration
+	SessionTableMaxRows   int
+	RateLimiter           string
+	PoWDifficulty         int
+	PoWSecret             string
+	SecretRulesFile       string
+	TracingExporter       string
+	LogFormat             string
+	LogLevel              slog.Level
+	OIDC                  oidc.Config
+	PasswordLoginDisabled bool
+
+	// File is the path of the config file which was loaded, if any.
+	File string
+	// PrintConfig is true when the config should be printed instead of
+	// starting the server.
+	PrintConfig bool
+}
+
+// The formats of the log lines.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// The algorithms new passwords can be hashed with.
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+)
+
+// The places rate limits can be kept in.
+const (
+	RateLimiterOff      = "off"
+	RateLimiterMemory   = "memory"
+	RateLimiterPostgres = "postgres"
+)
+
+// Default returns the config used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Addr:              ":4000",
+		QueryTimeout:      5 * time.Second,
+		TLSCertFile:       "./tls/cert.pem",
+		TLSKeyFile:        "./tls/key.pem",
+		TLSReloadInterval: time.Minute,
+		AdminAddr:         "localhost:4001",
+		ProxyHeader:       realip.HeaderXForwardedFor,
+		SessionLifetime:   12 * time.Hour,
+		PasswordHasher:    PasswordHasherArgon2id,
+		BcryptCost:        12,
+		// The second recommended option of RFC 9106, for when 2 GiB of memory
+		// per hash is too much.
+		Argon2Memory:      64 * 1024,
+		Argon2Iterations:  3,
+		Argon2Parallelism: 4,
+		// About a billion guesses.
+		PasswordMinEntropy: 30,
+		ShutdownTimeout:    30 * time.Second,
+		ReadinessTimeout:   2 * time.Second,
+		RateLimiter:        RateLimiterMemory,
+		PoWDifficulty:      16,
+		LogFormat:          LogFormatText,
+		LogLevel:           slog.LevelInfo,
+	}
+}
+
+// setting describes how a field of Config is named in the config file, as a flag
+// and as an environment variable.
+type setting struct {
+	name   string
+	env    string
+	usage  string
+	value  func(c *Config) flag.Getter
+	redact func(s string) string
+}
+
+var settings = []setting{
+	{
+		name: "addr", env: "SERVER_PORT", usage: "network `address` of the server",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.Addr) },
+	},
+	{
+		name: "base-url", env: "BASE_URL", usage: "public `URL` of the server, like https://snippetbox.example.com, which the links given to users start with; taken from the requests if empty",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.BaseURL) },
+	},
+	{
+		name: "db-dsn", env: "SNIPPETBOX_DB_DSN", usage: "Postgres data source `name`, or sqlite://path to keep the data in an SQLite database",
+		value:  func(c *Config) flag.Getter { return (*stringValue)(&c.DSN) },
+		redact: redactDSN,
+	},
+	{
+		name: "db-query-timeout", env: "DB_QUERY_TIMEOUT", usage: "`duration` after which the snippet and user queries of a request give up with a 503",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.QueryTimeout) },
+	},
+	{
+		name: "db-migrate", env: "DB_MIGRATE", usage: "apply the pending migrations of the database at startup, like cmd/db migrate up",
+		value: func(c *Config) flag.Getter { return (*boolValue)(&c.DBMigrate) },
+	},
+	{
+		name: "plain-http", env: "PLAIN_HTTP", usage: "serve plain HTTP instead of HTTPS, e.g. behind a TLS-terminating proxy",
+		value: func(c *Config) flag.Getter { return (*boolValue)(&c.PlainHTTP) },
+	},
+	{
+		name: "tls-cert", env: "TLS_CERT_FILE", usage: "`path` of the TLS certificate",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.TLSCertFile) },
+	},
+	{
+		name: "tls-key", env: "TLS_KEY_FILE", usage: "`path` of the TLS private key",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.TLSKeyFile) },
+	},
+	{
+		name: "tls-reload-interval", env: "TLS_RELOAD_INTERVAL", usage: "`duration` between checks for a new TLS certificate, only SIGHUP reloads it if zero",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.TLSReloadInterval) },
+	},
+	{
+		name: "redirect-addr", env: "REDIRECT_ADDR", usage: "network `address` of a plain HTTP listener redirecting to HTTPS, disabled if empty",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.RedirectAddr) },
+	},
+	{
+		name: "admin-addr", env: "ADMIN_ADDR", usage: "network `address` of the plain HTTP admin listener serving /metrics, disabled if empty",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.AdminAddr) },
+	},
+	{
+		name: "hsts-max-age", env: "HSTS_MAX_AGE", usage: "`duration` browsers should only use HTTPS for, no HSTS header is sent if zero",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.HSTSMaxAge) },
+	},
+	{
+		name: "hsts-include-subdomains", env: "HSTS_INCLUDE_SUBDOMAINS", usage: "apply HSTS to subdomains too",
+		value: func(c *Config) flag.Getter { return (*boolValue)(&c.HSTSIncludeSubdomains) },
+	},
+	{
+		name: "trusted-proxies", env: "TRUSTED_PROXIES", usage: "comma-separated `CIDRs` of the reverse proxies whose forwarding headers are trusted",
+		value: func(c *Config) flag.Getter { return (*prefixesValue)(&c.TrustedProxies) },
+	},
+	{
+		name: "proxy-header", env: "PROXY_HEADER", usage: "`header` the trusted proxies list client addresses in: X-Forwarded-For or Forwarded",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.ProxyHeader) },
+	},
+	{
+		name: "session-lifetime", env: "SESSION_LIFETIME", usage: "how long a session lasts, as a `duration`",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.SessionLifetime) },
+	},
+	{
+		name: "password-hasher", env: "PASSWORD_HASHER", usage: "`algorithm` of new password hashes: argon2id or bcrypt; older hashes are replaced when their users log in",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.PasswordHasher) },
+	},
+	{
+		name: "bcrypt-cost", env: "BCRYPT_COST", usage: "bcrypt `cost` of new password hashes",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.BcryptCost) },
+	},
+	{
+		name: "argon2-memory", env: "ARGON2_MEMORY", usage: "memory used by Argon2id password hashes, in `KiB`",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.Argon2Memory) },
+	},
+	{
+		name: "argon2-iterations", env: "ARGON2_ITERATIONS", usage: "`number` of passes of Argon2id password hashes over their memory",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.Argon2Iterations) },
+	},
+	{
+		name: "argon2-parallelism", env: "ARGON2_PARALLELISM", usage: "`number` of threads of Argon2id password hashes",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.Argon2Parallelism) },
+	},
+	{
+		name: "password-min-entropy", env: "PASSWORD_MIN_ENTROPY", usage: "minimum estimated strength of new passwords, in `bits`",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.PasswordMinEntropy) },
+	},
+	{
+		name: "breached-passwords", env: "BREACHED_PASSWORDS_FILE", usage: "`path` of a bloom filter of breached passwords, built with cmd/db, which new passwords mustn't be in; the most common passwords if empty",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.BreachedPasswordsFile) },
+	},
+	{
+		name: "shutdown-timeout", env: "SHUTDOWN_TIMEOUT", usage: "`duration` in-flight requests are given to complete on shutdown",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.ShutdownTimeout) },
+	},
+	{
+		name: "shutdown-delay", env: "SHUTDOWN_DELAY", usage: "`duration` /readyz fails for before the listeners close on shutdown, so that load balancers stop sending requests",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.ShutdownDelay) },
+	},
+	{
+		name: "readiness-timeout", env: "READINESS_TIMEOUT", usage: "`duration` the checks of /readyz are given to complete",
+		value: func(c *Config) flag.Getter { return (*durationValue)(&c.ReadinessTimeout) },
+	},
+	{
+		name: "session-table-max-rows", env: "SESSION_TABLE_MAX_ROWS", usage: "`rows` in the sessions table at which /readyz fails, not checked if zero",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.SessionTableMaxRows) },
+	},
+	{
+		name: "rate-limiter", env: "RATE_LIMITER", usage: "`store` of the rate limits: memory, postgres to share them between instances of a Postgres database, or off",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.RateLimiter) },
+	},
+	{
+		name: "pow-difficulty", env: "POW_DIFFICULTY", usage: "leading zero `bits` of the proof-of-work asked of anonymous forms, more under load; disabled if zero",
+		value: func(c *Config) flag.Getter { return (*intValue)(&c.PoWDifficulty) },
+	},
+	{
+		name: "pow-secret", env: "POW_SECRET", usage: "`key` signing the proof-of-work challenges, which every instance must share; random if empty, which only suits a single instance",
+		value:  func(c *Config) flag.Getter { return (*stringValue)(&c.PoWSecret) },
+		redact: redactAll,
+	},
+	{
+		name: "secret-rules", env: "SECRET_RULES_FILE", usage: "`path` of a JSON file overriding the rules snippets are scanned for secrets with",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.SecretRulesFile) },
+	},
+	{
+		name: "tracing-exporter", env: "TRACING_EXPORTER", usage: "`exporter` the spans of requests are sent to: stdout, or otlp configured by the OTEL_EXPORTER_OTLP_* variables; not recorded if empty",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.TracingExporter) },
+	},
+	{
+		name: "log-format", env: "LOG_FORMAT", usage: "`format` of the log lines: text or json",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.LogFormat) },
+	},
+	{
+		name: "log-level", env: "LOG_LEVEL", usage: "minimum `level` of the log lines: debug, info, warn or error",
+		value: func(c *Config) flag.Getter { return (*levelValue)(&c.LogLevel) },
+	},
+	{
+		name: "oidc-issuer-url", env: "OIDC_ISSUER_URL", usage: "issuer `URL` of the OpenID Connect provider, enables OIDC login",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.OIDC.IssuerURL) },
+	},
+	{
+		name: "oidc-client-id", env: "OIDC_CLIENT_ID", usage: "OpenID Connect client `ID`",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.OIDC.ClientID) },
+	},
+	{
+		name: "oidc-client-secret", env: "OIDC_CLIENT_SECRET", usage: "OpenID Connect client `secret`",
+		value:  func(c *Config) flag.Getter { return (*stringValue)(&c.OIDC.ClientSecret) },
+		redact: redactAll,
+	},
+	{
+		name: "oidc-redirect-url", env: "OIDC_REDIRECT_URL", usage: "OpenID Connect redirect `URL`",
+		value: func(c *Config) flag.Getter { return (*stringValue)(&c.OIDC.RedirectURL) },
+	},
+	{
+		name: "password-login-disabled", env: "PASSWORD_LOGIN_DISABLED", usage: "only allow logging in with OpenID Connect",
+		value: func(c *Config) flag.Getter { return (*boolValue)(&c.PasswordLoginDisabled) },
+	},
+}
+
+// Load builds the config from the defaults, the config file passed with the
+// -config flag, the environment variables read with getenv and the flags in args,
+// in that order, and validates it unless -print-config is set. It returns
+// flag.ErrHelp if args asked for help.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	cfg := Default()
+
+	// The flags are parsed twice. The first time only finds out where the config
+	// file is and reports invalid flags, the second applies them on top of the
+	// file and the environment.
+	fs := newFlagSet(Default())
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	cfg.File = fs.Lookup("config").Value.String()
+
+	if cfg.File != "" {
+		if err := cfg.loadFile(cfg.File); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.value(cfg).Set(v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	fs = newFlagSet(cfg)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// An invalid config can still be printed, to help finding out what's wrong
+	// with it.
+	if cfg.PrintConfig {
+		return cfg, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("snippetbox", flag.ContinueOnError)
+	fs.StringVar(&cfg.File, "config", cfg.File, "`path` of a JSON config file")
+	fs.BoolVar(&cfg.PrintConfig, "print-config", cfg.PrintConfig, "print the config with secrets redacted and exit")
+	for _, s := range settings {
+		fs.Var(s.value(cfg), s.name, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	return fs
+}
+
+// loadFile applies the settings of a JSON config file, which is an object keyed
+// by flag name.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+
+	var values map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err = dec.Decode(&values); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	for _, s := range settings {
+		v, ok := values[s.name]
+		if !ok {
+			continue
+		}
+		delete(values, s.name)
+
+		var str string
+		switch v := v.(type) {
+		case string:
+			str = v
+		case json.Number:
+			str = v.String()
+		case bool:
+			str = strconv.FormatBool(v)
+		default:
+			return fmt.Errorf("config file %s: %s: unsupported value %v", path, s.name, v)
+		}
+
+		if err = s.value(c).Set(str); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, s.name, err)
+		}
+	}
+
+	for name := range values {
+		return fmt.Errorf("config file %s: unknown setting %q", path, name)
+	}
+	return nil
+}
+
+// Validate checks that the settings make sense together, and returns an error
+// listing every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Addr != "", "addr must not be empty (SERVER_PORT or -addr)")
+	check(c.DSN != "", "db-dsn must be set (SNIPPETBOX_DB_DSN or -db-dsn)")
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.User == nil &&
+			(u.Path == "" || u.Path == "/") && u.RawQuery == "" && u.Fragment == "",
+			"base-url must be an http or https URL without path, like https://snippetbox.example.com, got %q", c.BaseURL)
+	}
+	check(c.QueryTimeout > 0, "db-query-timeout must be positive, got %s", c.QueryTimeout)
+	if c.PlainHTTP {
+		check(c.RedirectAddr == "", "redirect-addr can't be used with plain-http")
+	} else {
+		check(c.RedirectAddr == "" || c.RedirectAddr != c.Addr, "redirect-addr must differ from addr")
+		check(c.TLSCertFile != "", "tls-cert must not be empty (TLS_CERT_FILE or -tls-cert)")
+		check(c.TLSKeyFile != "", "tls-key must not be empty (TLS_KEY_FILE or -tls-key)")
+	}
+	check(c.AdminAddr == "" || (c.AdminAddr != c.Addr && c.AdminAddr != c.RedirectAddr),
+		"admin-addr must differ from addr and redirect-addr")
+	check(slices.Contains(realip.Headers, c.ProxyHeader),
+		"proxy-header must be %q or %q, got %q", realip.HeaderXForwardedFor, realip.HeaderForwarded, c.ProxyHeader)
+	check(c.TLSReloadInterval >= 0, "tls-reload-interval must not be negative, got %s", c.TLSReloadInterval)
+	check(c.HSTSMaxAge >= 0, "hsts-max-age must not be negative, got %s", c.HSTSMaxAge)
+	check(c.SessionLifetime > 0, "session-lifetime must be positive, got %s", c.SessionLifetime)
+	check(c.PasswordHasher == PasswordHasherArgon2id || c.PasswordHasher == PasswordHasherBcrypt,
+		"password-hasher must be %q or %q, got %q", PasswordHasherArgon2id, PasswordHasherBcrypt, c.PasswordHasher)
+	check(c.BcryptCost >= bcrypt.MinCost && c.BcryptCost <= bcrypt.MaxCost,
+		"bcrypt-cost must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, c.BcryptCost)
+	check(c.Argon2Parallelism >= 1 && c.Argon2Parallelism <= math.MaxUint8,
+		"argon2-parallelism must be between 1 and %d, got %d", math.MaxUint8, c.Argon2Parallelism)
+	check(c.Argon2Iterations >= 1, "argon2-iterations must be positive, got %d", c.Argon2Iterations)
+	// Argon2 needs at least 8 KiB per thread.
+	check(c.Argon2Memory >= 8*c.Argon2Parallelism && int64(c.Argon2Memory) <= math.MaxUint32,
+		"argon2-memory must be at least 8 KiB per thread (%d), got %d", 8*c.Argon2Parallelism, c.Argon2Memory)
+	check(c.PasswordMinEntropy >= 0, "password-min-entropy must not be negative, got %d", c.PasswordMinEntropy)
+	check(c.ShutdownTimeout > 0, "shutdown-timeout must be positive, got %s", c.ShutdownTimeout)
+	check(c.ShutdownDelay >= 0, "shutdown-delay must not be negative, got %s", c.ShutdownDelay)
+	check(c.ReadinessTimeout > 0, "readiness-timeout must be positive, got %s", c.ReadinessTimeout)
+	check(c.SessionTableMaxRows >= 0, "session-table-max-rows must not be negative, got %d", c.SessionTableMaxRows)
+	check(c.RateLimiter == RateLimiterOff || c.RateLimiter == RateLimiterMemory || c.RateLimiter == RateLimiterPostgres,
+		"rate-limiter must be %q, %q or %q, got %q", RateLimiterMemory, RateLimiterPostgres, RateLimiterOff, c.RateLimiter)
+	check(c.PoWDifficulty == 0 || (c.PoWDifficulty >= validation.MinDifficulty && c.PoWDifficulty <= validation.MaxDifficulty),
+		"pow-difficulty must be 0 or between %d and %d, got %d", validation.MinDifficulty, validation.MaxDifficulty, c.PoWDifficulty)
+	// The Postgres rate limiter is there for several instances, which must sign
+	// the proof-of-work challenges with the same key.
+	check(c.PoWDifficulty == 0 || c.RateLimiter != RateLimiterPostgres || c.PoWSecret != "",
+		"pow-secret must be set when rate-limiter is %q (POW_SECRET or -pow-secret)", RateLimiterPostgres)
+	check(slices.Contains(tracing.Exporters, c.TracingExporter),
+		"tracing-exporter must be empty, %q or %q, got %q", tracing.ExporterStdout, tracing.ExporterOTLP, c.TracingExporter)
+	check(c.LogFormat == LogFormatText || c.LogFormat == LogFormatJSON,
+		"log-format must be %q or %q, got %q", LogFormatText, LogFormatJSON, c.LogFormat)
+
+	if c.OIDC.IssuerURL != "" {
+		check(c.OIDC.ClientID != "", "oidc-client-id must be set when OIDC login is enabled")
+		check(c.OIDC.RedirectURL != "", "oidc-redirect-url must be set when OIDC login is enabled")
+	}
+	check(!c.PasswordLoginDisabled || c.OIDC.IssuerURL != "",
+		"password-login-disabled requires OIDC login to be enabled (oidc-issuer-url)")
+
+	return errors.Join(errs...)
+}
+
+// Print writes the config to w in the format of a config file, with secrets
+// redacted.
+func (c *Config) Print(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, s := range settings {
+		v := s.value(c).Get()
+		if str, ok := v.(string); ok && str != "" && s.redact != nil {
+			v = s.redact(str)
+		}
+
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "  %q: %s", s.name, b)
+		if i < len(settings)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+const redacted = "REDACTED"
+
+func redactAll(string) string {
+	return redacted
+}
+
+// passwordRX matches the password settings of key/value data source names and
+// their values, which can be quoted.
+var passwordRX = regexp.MustCompile(`\b((?:ssl)?password\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN hides the passwords of a data source name, whether it is a URL, where
+// they can be in the user info or the query, or a list of key/value settings.
+func redactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return passwordRX.ReplaceAllString(dsn, "${1}"+redacted)
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	query := u.Query()
+	for key := range query {
+		if strings.EqualFold(key, "password") || strings.EqualFold(key, "sslpassword") {
+			query[key] = []string{redacted}
+			u.RawQuery = query.Encode()
+		}
+	}
+	return u.String()
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) Get() any           { return string(*v) }
+func (v *stringValue) String() string     { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+func (v *intValue) Get() any       { return int(*v) }
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) Get() any         { return bool(*v) }
+func (v *boolValue) String() string   { return strconv.FormatBool(bool(*v)) }
+func (v *boolValue) IsBoolFlag() bool { return true }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) Get() any       { return time.Duration(*v).String() }
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type levelValue slog.Level
+
+func (v *levelValue) Set(s string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return fmt.Errorf("invalid log level %q", s)
+	}
+	*v = levelValue(level)
+	return nil
+}
+func (v *levelValue) Get() any       { return slog.Level(*v).String() }
+func (v *levelValue) String() string { return slog.Level(*v).String() }
+
+type prefixesValue []netip.Prefix
+
+func (v *prefixesValue) Set(s string) error {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		prefix, err := realip.ParsePrefix(field)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q", field)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	*v = prefixes
+	return nil
+}
+func (v *prefixesValue) Get() any { return v.String() }
+func (v *prefixesValue) String() string {
+	fields := make([]string, len(*v))
+	for i, prefix := range *v {
+		fields[i] = prefix.String()
+	}
+	return strings.Join(fields, ",")
+}
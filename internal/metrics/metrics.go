@@ -0,0 +1,144 @@
+// Package metrics collects the Prometheus metrics of the web application.
+package metrics
+
+import (
+	"database/sql"
+	"github.com/alexedwards/scs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "snippetbox"
+
+// The results of a session load.
+const (
+	SessionFound   = "found"
+	SessionMissing = "missing"
+	SessionError   = "error"
+)
+
+// Metrics holds the collectors of the application in a registry of its own, so
+// that several instances can coexist in tests.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	sessionLoads    *prometheus.CounterVec
+	logins          *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests handled, by route pattern, method and status code.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route pattern, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "store_query_duration_seconds",
+			Help:      "Time taken by the methods of the stores, by store and method.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"store", "method"}),
+		sessionLoads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "session_loads_total",
+			Help:      "Number of sessions loaded from the session store, by result.",
+		}, []string{"result"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Number of login attempts, by result.",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.queryDuration, m.sessionLoads, m.logins,
+	)
+
+	// Initialize the series with a fixed set of labels, so that they are exported
+	// before the first occurrence.
+	for _, result := range []string{SessionFound, SessionMissing, SessionError} {
+		m.sessionLoads.WithLabelValues(result)
+	}
+	for _, result := range []string{"success", "failure"} {
+		m.logins.WithLabelValues(result)
+	}
+
+	return m
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func (m *Metrics) RegisterDB(db *sql.DB, name string) {
+	m.registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records a handled HTTP request. The route must be the pattern of
+// the route which matched, not the path, to keep the number of series bounded.
+func (m *Metrics) ObserveRequest(route, method string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.requestDuration.WithLabelValues(route, method, code).Observe(d.Seconds())
+}
+
+// ObserveQuery records the time taken by a method of a store.
+func (m *Metrics) ObserveQuery(store, method string, d time.Duration) {
+	m.queryDuration.WithLabelValues(store, method).Observe(d.Seconds())
+}
+
+// SessionLoaded counts a session load with one of the Session* results.
+func (m *Metrics) SessionLoaded(result string) {
+	m.sessionLoads.WithLabelValues(result).Inc()
+}
+
+// Login counts a login attempt.
+func (m *Metrics) Login(success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	m.logins.WithLabelValues(result).Inc()
+}
+
+// SessionStore returns a session store which counts the sessions loaded from
+// store.
+func (m *Metrics) SessionStore(store scs.Store) scs.Store {
+	return &sessionStore{Store: store, metrics: m}
+}
+
+type sessionStore struct {
+	scs.Store
+	metrics *Metrics
+}
+
+func (s *sessionStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.Store.Find(token)
+	switch {
+	case err != nil:
+		s.metrics.SessionLoaded(SessionError)
+	case !found:
+		s.metrics.SessionLoaded(SessionMissing)
+	default:
+		s.metrics.SessionLoaded(SessionFound)
+	}
+	return b, found, err
+}
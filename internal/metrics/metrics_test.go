@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// fakeSessionStore finds the sessions in its map, and fails for the token "fail".
+type fakeSessionStore map[string][]byte
+
+func (s fakeSessionStore) Find(token string) ([]byte, bool, error) {
+	if token == "fail" {
+		return nil, false, errors.New("connection refused")
+	}
+	b, ok := s[token]
+	return b, ok, nil
+}
+
+func (s fakeSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	s[token] = b
+	return nil
+}
+
+func (s fakeSessionStore) Delete(token string) error {
+	delete(s, token)
+	return nil
+}
+
+func TestMetrics_SessionStore(t *testing.T) {
+	m := New()
+	s := m.SessionStore(fakeSessionStore{"token-1": []byte("data")})
+
+	b, found, err := s.Find("token-1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("data"), b)
+
+	_, found, _ = s.Find("token-2")
+	assert.False(t, found)
+	_, _, err = s.Find("fail")
+	assert.Error(t, err)
+	s.Find("token-1")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.sessionLoads.WithLabelValues(SessionFound)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.sessionLoads.WithLabelValues(SessionMissing)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.sessionLoads.WithLabelValues(SessionError)))
+}
+
+func TestMetrics_Login(t *testing.T) {
+	m := New()
+	m.Login(true)
+	m.Login(false)
+	m.Login(false)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.logins.WithLabelValues("success")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.logins.WithLabelValues("failure")))
+}
@@ -0,0 +1,355 @@
+// Package migrate applies the migrations of the migrations package to a
+// database. The version of the database is kept in the schema_migrations table
+// the migrate CLI used before, so databases it migrated carry on from where it
+// stopped.
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/migrations"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// lockKey is the key of the Postgres advisory lock which keeps two processes
+// from migrating a database at the same time.
+const lockKey = 4_729_150_276
+
+// fileRX matches the names of migration files, like 000001_create_snippets_table.up.sql.
+var fileRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a change of the schema, which can be applied with Up and
+// reverted with Down.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Step is a migration which was applied, or reverted when Up is false.
+type Step struct {
+	Migration *Migration
+	Up        bool
+}
+
+func (s Step) String() string {
+	direction := "down"
+	if s.Up {
+		direction = "up"
+	}
+	return fmt.Sprintf("%s %d_%s", direction, s.Migration.Version, s.Migration.Name)
+}
+
+// Status is how far the migrations of a database got.
+type Status struct {
+	// Version is the version of the last applied migration, 0 if none was.
+	Version int
+	// Dirty is true if the migration of Version failed half-way. Migrations
+	// run in transactions so this only happens to databases the migrate CLI
+	// left behind, which need fixing by hand and Force.
+	Dirty bool
+	// Pending are the migrations which haven't been applied yet.
+	Pending []*Migration
+}
+
+// Load reads the migrations of a backend, ordered by version.
+func Load(backend store.Backend) ([]*Migration, error) {
+	switch backend {
+	case store.Postgres:
+		return load(migrations.Postgres)
+	case store.SQLite:
+		return load(migrations.SQLite)
+	default:
+		return nil, fmt.Errorf("migrate: no migrations for backend %q", backend)
+	}
+}
+
+func load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileRX.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migrate: invalid version in %s", entry.Name())
+		}
+		script, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(script)
+		} else {
+			m.Down = string(script)
+		}
+	}
+
+	list := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+	return list, nil
+}
+
+// MustLatest returns the version of the last migration of a backend, and panics
+// if the migrations can't be read.
+func MustLatest(backend store.Backend) int {
+	list, err := Load(backend)
+	if err != nil {
+		panic(err)
+	}
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
+
+// Migrator applies migrations to a database.
+type Migrator struct {
+	db         *sql.DB
+	backend    store.Backend
+	migrations []*Migration
+}
+
+// New returns a migrator for a database of the given backend.
+func New(db *sql.DB, backend store.Backend) (*Migrator, error) {
+	list, err := Load(backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, backend: backend, migrations: list}, nil
+}
+
+// Latest returns the version of the last migration, 0 if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status returns how far the migrations of the database got.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		status.Version, status.Dirty, err = version(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return Status{}, err
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > status.Version {
+			status.Pending = append(status.Pending, mig)
+		}
+	}
+	return status, nil
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]Step, error) {
+	return m.To(ctx, m.Latest())
+}
+
+// Down reverts the last applied migration.
+func (m *Migrator) Down(ctx context.Context) ([]Step, error) {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Version == 0 {
+		return nil, nil
+	}
+
+	i, err := m.index(status.Version)
+	if err != nil {
+		return nil, err
+	}
+	target := 0
+	if i > 0 {
+		target = m.migrations[i-1].Version
+	}
+	return m.To(ctx, target)
+}
+
+// To applies or reverts migrations, one transaction each, until the database
+// is at the given version. Version 0 reverts every migration. It returns the
+// steps taken, including when it fails half-way.
+func (m *Migrator) To(ctx context.Context, target int) ([]Step, error) {
+	if target != 0 {
+		if _, err := m.index(target); err != nil {
+			return nil, err
+		}
+	}
+
+	var steps []Step
+	for {
+		var step *Step
+		err := m.inTx(ctx, func(tx *sql.Tx) error {
+			current, dirty, err := version(ctx, tx)
+			if err != nil {
+				return err
+			}
+			if dirty {
+				return fmt.Errorf("migrate: migration %d failed half-way, fix the database and force its version", current)
+			}
+			if current == target {
+				return nil
+			}
+
+			step, err = m.next(current, target)
+			if err != nil {
+				return err
+			}
+
+			script, newVersion := step.Migration.Up, step.Migration.Version
+			if !step.Up {
+				script = step.Migration.Down
+				newVersion, err = m.previous(step.Migration.Version)
+				if err != nil {
+					return err
+				}
+			}
+			if _, err = tx.ExecContext(ctx, script); err != nil {
+				return fmt.Errorf("migrate: %s: %w", step, err)
+			}
+			return setVersion(ctx, tx, newVersion)
+		})
+		if err != nil {
+			return steps, err
+		}
+		if step == nil {
+			return steps, nil
+		}
+		steps = append(steps, *step)
+	}
+}
+
+// Force sets the version of the database without running any migration, and
+// clears its dirty flag. It is how a database is recovered after a migration
+// which failed half-way has been fixed by hand.
+func (m *Migrator) Force(ctx context.Context, target int) error {
+	if target != 0 {
+		if _, err := m.index(target); err != nil {
+			return err
+		}
+	}
+
+	return m.inTx(ctx, func(tx *sql.Tx) error {
+		return setVersion(ctx, tx, target)
+	})
+}
+
+// next returns the step which takes a database at version current one step
+// closer to version target.
+func (m *Migrator) next(current, target int) (*Step, error) {
+	if current < target {
+		for _, mig := range m.migrations {
+			if mig.Version > current {
+				return &Step{Migration: mig, Up: true}, nil
+			}
+		}
+	}
+
+	i, err := m.index(current)
+	if err != nil {
+		return nil, err
+	}
+	return &Step{Migration: m.migrations[i], Up: false}, nil
+}
+
+// previous returns the version before the given one, 0 for the first.
+func (m *Migrator) previous(v int) (int, error) {
+	i, err := m.index(v)
+	if err != nil || i == 0 {
+		return 0, err
+	}
+	return m.migrations[i-1].Version, nil
+}
+
+// index returns the index of the migration of a version.
+func (m *Migrator) index(v int) (int, error) {
+	for i, mig := range m.migrations {
+		if mig.Version == v {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("migrate: no migration with version %d", v)
+}
+
+// inTx runs fn in a transaction which no other migrator runs at the same time.
+// On Postgres this takes an advisory lock, which is released with the
+// transaction. SQLite transactions take the write lock of the database as they
+// begin already, see store.ParseDSN.
+func (m *Migrator) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.backend == store.Postgres {
+		if _, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+			return err
+		}
+	}
+
+	stmt := `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`
+	if _, err = tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// version returns the version of the database and whether it is dirty.
+func version(ctx context.Context, tx *sql.Tx) (int, bool, error) {
+	var v int
+	var dirty bool
+	err := tx.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&v, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return v, dirty, err
+}
+
+// setVersion records the version of the database. Like the migrate CLI, a
+// database without migrations has no row at all.
+func setVersion(ctx context.Context, tx *sql.Tx, v int) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	if v == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, dirty) VALUES ($1, FALSE)`, v)
+	return err
+}
@@ -0,0 +1,276 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad(t *testing.T) {
+	postgres, err := Load(store.Postgres)
+	require.NoError(t, err)
+	sqlite, err := Load(store.SQLite)
+	require.NoError(t, err)
+
+	// A version means the same schema whatever the backend.
+	require.Len(t, sqlite, len(postgres))
+	for i, m := range postgres {
+		assert.Equal(t, i+1, m.Version)
+		assert.Equal(t, m.Version, sqlite[i].Version)
+		assert.Equal(t, m.Name, sqlite[i].Name)
+		assert.NotEmptyf(t, m.Up, "up migration of version %d", m.Version)
+		assert.NotEmptyf(t, m.Down, "down migration of version %d", m.Version)
+	}
+
+	_, err = Load("mysql")
+	assert.Error(t, err)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	testcases := []struct {
+		name  string
+		files []string
+	}{
+		{name: "Version 0", files: []string{"000000_init.up.sql"}},
+		{name: "Two names for a version", files: []string{"000001_a.up.sql", "000001_b.down.sql"}},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fsys := fstest.MapFS{}
+			for _, name := range tc.files {
+				fsys[name] = &fstest.MapFile{Data: []byte("SELECT 1;")}
+			}
+			_, err := load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestMigrator_SQLite(t *testing.T) {
+	db := newSQLiteTestDB(t)
+	m, err := New(db, store.SQLite)
+	require.NoError(t, err)
+	testMigrator(t, db, m)
+}
+
+func TestMigrator_Postgres(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db := newPostgresTestDB(t)
+	m, err := New(db, store.Postgres)
+	require.NoError(t, err)
+	testMigrator(t, db, m)
+}
+
+func testMigrator(t *testing.T, db *sql.DB, m *Migrator) {
+	ctx := context.Background()
+	latest := m.Latest()
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, status.Version)
+	assert.Len(t, status.Pending, latest)
+
+	steps, err := m.Up(ctx)
+	require.NoError(t, err)
+	require.Len(t, steps, latest)
+	assert.True(t, steps[0].Up)
+	assert.Equal(t, "up 1_create_snippets_table", steps[0].String())
+	assertVersion(t, db, latest)
+
+	// Migrating an up to date database does nothing.
+	steps, err = m.Up(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+
+	steps, err = m.Down(ctx)
+	require.NoError(t, err)
+	require.Len(t, steps, 1)
+	assert.False(t, steps[0].Up)
+	assert.Equal(t, latest, steps[0].Migration.Version)
+	assertVersion(t, db, latest-1)
+
+	status, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latest-1, status.Version)
+	require.Len(t, status.Pending, 1)
+	assert.Equal(t, latest, status.Pending[0].Version)
+
+	steps, err = m.To(ctx, 2)
+	require.NoError(t, err)
+	assert.Len(t, steps, latest-3)
+	assertVersion(t, db, 2)
+
+	_, err = m.To(ctx, latest+1)
+	assert.Error(t, err)
+	assertVersion(t, db, 2)
+
+	// The users table of the second migration is there, the sessions table
+	// of the third isn't.
+	_, err = db.Exec(`SELECT count(*) FROM users`)
+	assert.NoError(t, err)
+	_, err = db.Exec(`SELECT count(*) FROM sessions`)
+	assert.Error(t, err)
+
+	steps, err = m.To(ctx, 0)
+	require.NoError(t, err)
+	assert.Len(t, steps, 2)
+	assertVersion(t, db, 0)
+	_, err = db.Exec(`SELECT count(*) FROM snippets`)
+	assert.Error(t, err)
+
+	// Down on an empty database does nothing.
+	steps, err = m.Down(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, steps)
+}
+
+func TestMigrator_Dirty(t *testing.T) {
+	db := newSQLiteTestDB(t)
+	ctx := context.Background()
+	m, err := New(db, store.SQLite)
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 1)
+	require.NoError(t, err)
+
+	// This is how the migrate CLI leaves a database a migration failed on.
+	_, err = db.Exec(`UPDATE schema_migrations SET version = 2, dirty = TRUE`)
+	require.NoError(t, err)
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Version)
+	assert.True(t, status.Dirty)
+
+	_, err = m.Up(ctx)
+	assert.ErrorContains(t, err, "force")
+	_, err = m.Down(ctx)
+	assert.ErrorContains(t, err, "force")
+
+	require.NoError(t, m.Force(ctx, 1))
+	status, err = m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Version)
+	assert.False(t, status.Dirty)
+
+	_, err = m.Up(ctx)
+	require.NoError(t, err)
+	assertVersion(t, db, m.Latest())
+
+	assert.Error(t, m.Force(ctx, m.Latest()+1))
+}
+
+func TestMigrator_FailedMigration(t *testing.T) {
+	db := newSQLiteTestDB(t)
+	ctx := context.Background()
+	m, err := New(db, store.SQLite)
+	require.NoError(t, err)
+
+	_, err = m.To(ctx, 1)
+	require.NoError(t, err)
+
+	// The users table of the second migration already exists, so the
+	// migration fails and its transaction is rolled back.
+	_, err = db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY)`)
+	require.NoError(t, err)
+
+	steps, err := m.Up(ctx)
+	assert.ErrorContains(t, err, "up 2_create_users_table")
+	assert.Empty(t, steps)
+
+	status, err := m.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, status.Version)
+	assert.False(t, status.Dirty)
+}
+
+func TestMigrator_Concurrent(t *testing.T) {
+	db := newSQLiteTestDB(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := New(db, store.SQLite)
+			if err == nil {
+				_, err = m.Up(ctx)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assertVersion(t, db, MustLatest(store.SQLite))
+}
+
+func assertVersion(t *testing.T, db *sql.DB, want int) {
+	t.Helper()
+	var n int
+	require.NoError(t, db.QueryRow(`SELECT count(*) FROM schema_migrations`).Scan(&n))
+	if want == 0 {
+		assert.Zero(t, n)
+		return
+	}
+	var version int
+	var dirty bool
+	require.NoError(t, db.QueryRow(`SELECT version, dirty FROM schema_migrations`).Scan(&version, &dirty))
+	assert.Equal(t, 1, n)
+	assert.Equal(t, want, version)
+	assert.False(t, dirty)
+}
+
+func newSQLiteTestDB(t *testing.T) *sql.DB {
+	db, _, err := store.Open("sqlite://" + filepath.Join(t.TempDir(), "snippetbox.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func newPostgresTestDB(t *testing.T) *sql.DB {
+	randomSuffix := strings.Split(uuid.New().String(), "-")[0]
+	testDBName := fmt.Sprintf("snippetbox_test_%s", randomSuffix)
+
+	db := getDBConn(t, "postgres")
+	_, err := db.Exec(fmt.Sprintf("CREATE DATABASE %s", testDBName))
+	if err != nil {
+		t.Fatalf("Failed to create database %s. Err = %s", testDBName, err)
+	}
+	db.Close()
+
+	db = getDBConn(t, testDBName)
+	t.Cleanup(func() {
+		db.Close()
+		admin := getDBConn(t, "postgres")
+		defer admin.Close()
+		if _, err := admin.Exec(fmt.Sprintf("DROP DATABASE %s", testDBName)); err != nil {
+			t.Fatalf("Failed to drop database %s. Err = %s", testDBName, err)
+		}
+	})
+	return db
+}
+
+func getDBConn(t *testing.T, dbname string) *sql.DB {
+	dsn := fmt.Sprintf("host=localhost port=5432 user=postgres password=postgres sslmode=disable dbname=%s", dbname)
+	db, _, err := store.Open(dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to postgres with DSN = %s\nError = %s", dsn, err)
+	}
+	return db
+}
@@ -0,0 +1,122 @@
+package oidc
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+const redirectURL = "https://snippetbox.example.com/user/login/oidc/callback"
+
+// authorize follows the provider's login page and returns the authorization code
+// it sends back to the redirect URL.
+func authorize(t *testing.T, authCodeURL string) string {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authCodeURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	return location.Query().Get("code")
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	alice := oidctest.User{Subject: "alice-123", Name: "Alice", Email: "alice@example.com", EmailVerified: true}
+
+	testcases := []struct {
+		name          string
+		user          oidctest.User
+		nonce         string
+		wrongVerifier bool
+		wantIdentity  *Identity
+		wantErr       error
+	}{
+		{
+			name:  "Valid login",
+			user:  alice,
+			nonce: "nonce",
+			wantIdentity: &Identity{
+				Subject: "alice-123",
+				Name:    "Alice",
+				Email:   "alice@example.com",
+			},
+		},
+		{
+			name:    "Nonce mismatch",
+			user:    alice,
+			nonce:   "other-nonce",
+			wantErr: ErrNonceMismatch,
+		},
+		{
+			name:    "Unverified email",
+			user:    oidctest.User{Subject: "bob-123", Name: "Bob", Email: "bob@example.com"},
+			nonce:   "nonce",
+			wantErr: ErrEmailNotVerified,
+		},
+		{
+			name:          "Wrong code verifier",
+			user:          alice,
+			nonce:         "nonce",
+			wrongVerifier: true,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := oidctest.NewServer(tc.user)
+			defer server.Close()
+
+			provider, err := NewProvider(context.Background(), Config{
+				IssuerURL:    server.URL,
+				ClientID:     oidctest.ClientID,
+				ClientSecret: oidctest.ClientSecret,
+				RedirectURL:  redirectURL,
+			})
+			require.NoError(t, err)
+
+			verifier := GenerateVerifier()
+			code := authorize(t, provider.AuthCodeURL("state", "nonce", verifier))
+
+			if tc.wrongVerifier {
+				verifier = GenerateVerifier()
+			}
+
+			identity, err := provider.Exchange(context.Background(), code, verifier, tc.nonce)
+
+			if tc.wantIdentity == nil {
+				assert.Error(t, err)
+				if tc.wantErr != nil {
+					assert.ErrorIs(t, err, tc.wantErr)
+				}
+				assert.Nil(t, identity)
+				return
+			}
+
+			require.NoError(t, err)
+			tc.wantIdentity.Issuer = server.URL
+			assert.Equal(t, tc.wantIdentity, identity)
+		})
+	}
+}
+
+func TestRandomString(t *testing.T) {
+	a, err := RandomString()
+	require.NoError(t, err)
+	b, err := RandomString()
+	require.NoError(t, err)
+
+	assert.Len(t, a, 43)
+	assert.NotEqual(t, a, b)
+}
@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter keeps the buckets in memory. It only limits the requests made to
+// a single instance of the application.
+type MemoryLimiter struct {
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+	mu      sync.Mutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	bucket
+	fullAt time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{datetimeHandler: &datetime.Handler{}, buckets: map[string]*memoryBucket{}}
+}
+
+// Take takes a token from the bucket of key.
+func (m *MemoryLimiter) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	now := m.datetimeHandler.GetCurrentTimeUTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{bucket: newBucket(now, l)}
+		m.buckets[key] = b
+	}
+	res := b.take(now, l)
+	b.fullAt = b.bucket.fullAt(l)
+	return res, nil
+}
+
+// Evict forgets the buckets which are full again, since a missing bucket is the
+// same as a full one.
+func (m *MemoryLimiter) Evict() {
+	now := m.datetimeHandler.GetCurrentTimeUTC()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.buckets {
+		if !now.Before(b.fullAt) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// Cleanup evicts the full buckets every interval until ctx is done.
+func (m *MemoryLimiter) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Evict()
+		}
+	}
+}
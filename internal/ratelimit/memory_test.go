@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newTestMemoryLimiter(now time.Time) (*MemoryLimiter, *mocks.MockDateTimeHandler) {
+	clock := mocks.NewMockDateTimeHandler(now)
+	m := NewMemoryLimiter()
+	m.datetimeHandler = clock
+	return m, clock
+}
+
+func TestMemoryLimiter_Take(t *testing.T) {
+	m, clock := newTestMemoryLimiter(time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	limit := Limit{Burst: 2, Every: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := m.Take(ctx, "ip:192.0.2.1", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	res, err := m.Take(ctx, "ip:192.0.2.1", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Minute, res.RetryAfter)
+
+	// Buckets are separate per key.
+	res, err = m.Take(ctx, "ip:192.0.2.2", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(time.Minute)
+	res, err = m.Take(ctx, "ip:192.0.2.1", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestMemoryLimiter_Evict(t *testing.T) {
+	m, clock := newTestMemoryLimiter(time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+
+	_, err := m.Take(ctx, "fast", Limit{Burst: 5, Every: time.Second})
+	require.NoError(t, err)
+	_, err = m.Take(ctx, "slow", Limit{Burst: 5, Every: time.Hour})
+	require.NoError(t, err)
+
+	m.Evict()
+	assert.Len(t, m.buckets, 2, "no bucket is full yet")
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(time.Second)
+	m.Evict()
+	assert.Len(t, m.buckets, 1)
+	assert.Contains(t, m.buckets, "slow")
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(time.Hour)
+	m.Evict()
+	assert.Empty(t, m.buckets)
+}
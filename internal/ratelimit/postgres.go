@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"time"
+)
+
+// PostgresLimiter keeps the buckets in the rate_limits table, so that they are
+// shared by every instance of the application.
+type PostgresLimiter struct {
+	db              *sql.DB
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+}
+
+func NewPostgresLimiter(db *sql.DB) *PostgresLimiter {
+	return &PostgresLimiter{db: db, datetimeHandler: &datetime.Handler{}}
+}
+
+// Take takes a token from the bucket of key. The row of the bucket is locked
+// until the token is taken, so that concurrent requests can't take the same one.
+func (p *PostgresLimiter) Take(ctx context.Context, key string, l Limit) (Result, error) {
+	now := p.datetimeHandler.GetCurrentTimeUTC()
+
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	b := newBucket(now, l)
+	stmt := `INSERT INTO rate_limits (key, tokens, updated, full_at) VALUES($1, $2, $3, $3)
+	ON CONFLICT (key) DO NOTHING`
+	_, err = tx.ExecContext(ctx, stmt, key, b.tokens, b.updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	stmt = `SELECT tokens, updated FROM rate_limits WHERE key = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, stmt, key).Scan(&b.tokens, &b.updated)
+	if err != nil {
+		return Result{}, err
+	}
+
+	res := b.take(now, l)
+
+	stmt = `UPDATE rate_limits SET tokens = $2, updated = $3, full_at = $4 WHERE key = $1`
+	_, err = tx.ExecContext(ctx, stmt, key, b.tokens, b.updated, b.fullAt(l))
+	if err != nil {
+		return Result{}, err
+	}
+
+	return res, tx.Commit()
+}
+
+// Evict deletes the buckets which are full again, since a missing bucket is the
+// same as a full one.
+func (p *PostgresLimiter) Evict(ctx context.Context) error {
+	stmt := `DELETE FROM rate_limits WHERE full_at <= $1`
+	_, err := p.db.ExecContext(ctx, stmt, p.datetimeHandler.GetCurrentTimeUTC())
+	return err
+}
+
+// Cleanup evicts the full buckets every interval until ctx is done. Failures are
+// passed to onError, and retried at the next interval.
+func (p *PostgresLimiter) Cleanup(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.Evict(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestPostgresLimiter(t *testing.T, now time.Time) (*PostgresLimiter, *mocks.MockDateTimeHandler) {
+	clock := mocks.NewMockDateTimeHandler(now)
+	p := NewPostgresLimiter(newTestDB(t))
+	p.datetimeHandler = clock
+	return p, clock
+}
+
+func TestPostgresLimiter_Take(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+
+	p, clock := newTestPostgresLimiter(t, time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	limit := Limit{Burst: 2, Every: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		res, err := p.Take(ctx, "ip:192.0.2.1", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+	}
+
+	res, err := p.Take(ctx, "ip:192.0.2.1", limit)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Limit: 2, RetryAfter: time.Minute, Reset: 2 * time.Minute}, res)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(time.Minute)
+	res, err = p.Take(ctx, "ip:192.0.2.1", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+}
+
+func TestPostgresLimiter_Take_SubSecond(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+
+	// The bucket must refill from the exact time it was updated at. Rounded to
+	// the second, the request at 10:00:00.4 would be taken as made at 10:00:00,
+	// and the later ones would get back the fractions of a second lost.
+	p, clock := newTestPostgresLimiter(t, time.Date(2023, time.January, 1, 10, 0, 0, 400_000_000, time.UTC))
+	limit := Limit{Burst: 1, Every: time.Second}
+	ctx := context.Background()
+
+	// A request every 0.9 seconds only gets a token every other time.
+	var allowed []int
+	for i := 0; i < 10; i++ {
+		res, err := p.Take(ctx, "ip:192.0.2.1", limit)
+		require.NoError(t, err)
+		if res.Allowed {
+			allowed = append(allowed, i)
+		}
+		clock.MockCurrentTime = clock.MockCurrentTime.Add(900 * time.Millisecond)
+	}
+
+	assert.Equal(t, []int{0, 2, 4, 6, 8}, allowed)
+}
+
+func TestPostgresLimiter_Take_Concurrent(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+
+	p, _ := newTestPostgresLimiter(t, time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	limit := Limit{Burst: 5, Every: time.Hour}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := p.Take(context.Background(), "user:1", limit)
+			assert.NoError(t, err)
+			if res.Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 5, allowed)
+}
+
+func TestPostgresLimiter_Evict(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+
+	p, clock := newTestPostgresLimiter(t, time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	ctx := context.Background()
+
+	_, err := p.Take(ctx, "fast", Limit{Burst: 5, Every: time.Second})
+	require.NoError(t, err)
+	_, err = p.Take(ctx, "slow", Limit{Burst: 5, Every: time.Hour})
+	require.NoError(t, err)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(time.Second)
+	require.NoError(t, p.Evict(ctx))
+
+	var keys []string
+	rows, err := p.db.Query("SELECT key FROM rate_limits")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		require.NoError(t, rows.Scan(&key))
+		keys = append(keys, key)
+	}
+	require.NoError(t, rows.Err())
+	assert.Equal(t, []string{"slow"}, keys)
+}
@@ -0,0 +1,88 @@
+// Package ratelimit limits how often clients can make requests, with token
+// buckets kept in memory or in Postgres.
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// Limit is the size of a token bucket and how fast it refills. A request takes a
+// token, and is rejected when the bucket is empty. The zero Limit doesn't limit
+// anything.
+type Limit struct {
+	// Burst is the number of requests allowed at once.
+	Burst int
+	// Every is the time it takes to get one token back.
+	Every time.Duration
+}
+
+// IsZero tells whether the limit is disabled.
+func (l Limit) IsZero() bool {
+	return l.Burst == 0
+}
+
+// Policy is the limit of a group of routes. Authenticated users get a bucket of
+// their own, wherever they make requests from, while anonymous clients share one
+// per IP address.
+type Policy struct {
+	PerIP   Limit
+	PerUser Limit
+}
+
+// Result is the state of a bucket after trying to take a token from it.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket.
+	Limit int
+	// Remaining is the number of whole tokens left.
+	Remaining int
+	// RetryAfter is the time until a token is available, zero if one is.
+	RetryAfter time.Duration
+	// Reset is the time until the bucket is full again.
+	Reset time.Duration
+}
+
+// bucket is a token bucket as of the time it was last updated.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(now time.Time, l Limit) bucket {
+	return bucket{tokens: float64(l.Burst), updated: now}
+}
+
+// take refills the bucket for the time elapsed since it was last updated, then
+// takes a token from it if there is one.
+func (b *bucket) take(now time.Time, l Limit) Result {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(float64(l.Burst), b.tokens+float64(elapsed)/float64(l.Every))
+		b.updated = now
+	}
+
+	res := Result{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = ceilSecond(time.Duration((1 - b.tokens) * float64(l.Every)))
+	}
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = b.fullAt(l).Sub(now)
+	return res
+}
+
+// fullAt returns when the bucket is full again, after which it can be forgotten.
+// It is rounded up to the second, so that a bucket is never forgotten early.
+func (b *bucket) fullAt(l Limit) time.Time {
+	missing := float64(l.Burst) - b.tokens
+	return b.updated.Add(ceilSecond(time.Duration(missing * float64(l.Every))))
+}
+
+func ceilSecond(d time.Duration) time.Duration {
+	if rounded := d.Truncate(time.Second); rounded < d {
+		return rounded + time.Second
+	}
+	return d
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBucket_take(t *testing.T) {
+	start := time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC)
+	limit := Limit{Burst: 3, Every: 10 * time.Second}
+
+	steps := []struct {
+		name    string
+		elapsed time.Duration
+		want    Result
+	}{
+		{
+			name: "Full bucket",
+			want: Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 10 * time.Second},
+		},
+		{
+			name: "Burst",
+			want: Result{Allowed: true, Limit: 3, Remaining: 1, Reset: 20 * time.Second},
+		},
+		{
+			name: "Last token",
+			want: Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 30 * time.Second},
+		},
+		{
+			name: "Empty bucket",
+			want: Result{Limit: 3, Remaining: 0, RetryAfter: 10 * time.Second, Reset: 30 * time.Second},
+		},
+		{
+			name:    "Partly refilled",
+			elapsed: 4 * time.Second,
+			want:    Result{Limit: 3, Remaining: 0, RetryAfter: 6 * time.Second, Reset: 26 * time.Second},
+		},
+		{
+			name:    "Refilled one token",
+			elapsed: 6 * time.Second,
+			want:    Result{Allowed: true, Limit: 3, Remaining: 0, Reset: 30 * time.Second},
+		},
+		{
+			name:    "Refilled no more than the burst",
+			elapsed: time.Hour,
+			want:    Result{Allowed: true, Limit: 3, Remaining: 2, Reset: 10 * time.Second},
+		},
+	}
+
+	b := newBucket(start, limit)
+	now := start
+	for _, step := range steps {
+		now = now.Add(step.elapsed)
+		assert.Equal(t, step.want, b.take(now, limit), step.name)
+	}
+}
+
+func TestCeilSecond(t *testing.T) {
+	assert.Equal(t, 2*time.Second, ceilSecond(1500*time.Millisecond))
+	assert.Equal(t, 2*time.Second, ceilSecond(2*time.Second))
+	assert.Equal(t, time.Duration(0), ceilSecond(0))
+}
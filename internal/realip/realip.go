@@ -0,0 +1,200 @@
+// Package realip finds the address of the client which made a request that went
+// through reverse proxies.
+package realip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// The headers proxies can report the addresses they received requests from in.
+const (
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderForwarded     = "Forwarded"
+)
+
+// Headers lists the valid values of the header passed to New.
+var Headers = []string{HeaderXForwardedFor, HeaderForwarded}
+
+// Client is where a request came from.
+type Client struct {
+	// IP is the address of the client, or the remote address of the connection
+	// as is if it isn't an IP address.
+	IP string
+	// Secure is true if the client sent the request over HTTPS.
+	Secure bool
+	// Proxied is true if the request came through a trusted proxy.
+	Proxied bool
+	// Proto is the protocol the trusted proxies reported the client used, or an
+	// empty string if they didn't.
+	Proto string
+}
+
+// Resolver finds the client of requests sent by trusted proxies.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// New returns a resolver which trusts the proxies in the given networks to report
+// the addresses they received requests from in header, one of the Header*
+// constants. Only the header the proxies actually set must be used, or clients
+// could forge the other.
+func New(trusted []netip.Prefix, header string) (*Resolver, error) {
+	if header != HeaderXForwardedFor && header != HeaderForwarded {
+		return nil, fmt.Errorf("realip: unknown header %q", header)
+	}
+	return &Resolver{trusted: trusted, header: header}, nil
+}
+
+// ParsePrefix parses a network in CIDR notation. A single address is parsed as a
+// network containing only that address.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr().Is4In6() {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// Client returns the client of r. The hops listed by the proxies are walked from
+// right to left, starting from the remote address of the connection, and the
+// first one which isn't a trusted proxy is the client. A hop which can't be
+// parsed stops the walk, and the proxy which reported it is taken as the client.
+func (res *Resolver) Client(r *http.Request) Client {
+	remote, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return Client{IP: r.RemoteAddr, Secure: r.TLS != nil}
+	}
+	if !res.isTrusted(remote) {
+		return Client{IP: remote.String(), Secure: r.TLS != nil}
+	}
+
+	var hops []hop
+	if res.header == HeaderForwarded {
+		hops = forwardedHops(r.Header.Values(HeaderForwarded))
+	} else {
+		hops = xForwardedForHops(r.Header.Values(HeaderXForwardedFor), r.Header.Values("X-Forwarded-Proto"))
+	}
+	if len(hops) == 0 {
+		return Client{IP: remote.String(), Secure: r.TLS != nil}
+	}
+
+	client := hop{addr: remote}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !hops[i].valid {
+			break
+		}
+		client = hops[i]
+		if !res.isTrusted(hops[i].addr) {
+			break
+		}
+	}
+
+	// The protocol is the one the client used with the first proxy, which the
+	// last hop reports when the proxies don't say which hop it belongs to.
+	proto := client.proto
+	if proto == "" {
+		proto = hops[len(hops)-1].proto
+	}
+	proto = strings.ToLower(proto)
+	secure := r.TLS != nil
+	if proto != "" {
+		secure = proto == "https"
+	}
+
+	return Client{IP: client.addr.String(), Secure: secure, Proxied: true, Proto: proto}
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// hop is an address the request was forwarded from, and the protocol it was
+// received with when known.
+type hop struct {
+	addr  netip.Addr
+	proto string
+	valid bool
+}
+
+// xForwardedForHops lists the hops of X-Forwarded-For headers. Only the last
+// hop gets the protocol, from the last X-Forwarded-Proto value, since the two
+// headers aren't kept in sync by every proxy.
+func xForwardedForHops(forwardedFor, forwardedProto []string) []hop {
+	var hops []hop
+	for _, value := range forwardedFor {
+		for _, elem := range strings.Split(value, ",") {
+			addr, ok := parseAddr(strings.TrimSpace(elem))
+			hops = append(hops, hop{addr: addr, valid: ok})
+		}
+	}
+
+	var protos []string
+	for _, value := range forwardedProto {
+		protos = append(protos, strings.Split(value, ",")...)
+	}
+	if len(hops) > 0 && len(protos) > 0 {
+		hops[len(hops)-1].proto = strings.TrimSpace(protos[len(protos)-1])
+	}
+	return hops
+}
+
+// forwardedHops lists the hops of Forwarded headers, as described in RFC 7239.
+// Each one carries the protocol it was received with.
+func forwardedHops(forwarded []string) []hop {
+	var hops []hop
+	for _, value := range forwarded {
+		for _, elem := range strings.Split(value, ",") {
+			var h hop
+			for _, pair := range strings.Split(elem, ";") {
+				name, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				v = strings.Trim(v, `"`)
+				switch strings.ToLower(name) {
+				case "for":
+					h.addr, h.valid = parseAddr(v)
+				case "proto":
+					h.proto = v
+				}
+			}
+			hops = append(hops, h)
+		}
+	}
+	return hops
+}
+
+// parseAddr parses an IP address, with an optional port and brackets around IPv6
+// addresses.
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
@@ -0,0 +1,214 @@
+package realip
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "10.0.0.0/8", want: "10.0.0.0/8"},
+		{in: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{in: "192.0.2.1", want: "192.0.2.1/32"},
+		{in: "2001:db8::/32", want: "2001:db8::/32"},
+		{in: "2001:db8::1", want: "2001:db8::1/128"},
+		{in: "::ffff:10.0.0.0/104", want: "10.0.0.0/8"},
+		{in: "10.0.0.0/33", wantErr: true},
+		{in: "proxy.example.com", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			prefix, err := ParsePrefix(tc.in)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, prefix.String())
+		})
+	}
+}
+
+func TestResolver_Client(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		remoteAddr string
+		tls        bool
+		headers    map[string][]string
+		want       Client
+	}{
+		{
+			name:       "Direct connection",
+			remoteAddr: "203.0.113.7:51234",
+			want:       Client{IP: "203.0.113.7"},
+		},
+		{
+			name:       "Direct TLS connection",
+			remoteAddr: "203.0.113.7:51234",
+			tls:        true,
+			want:       Client{IP: "203.0.113.7", Secure: true},
+		},
+		{
+			name:       "Spoofed X-Forwarded-For from untrusted client",
+			remoteAddr: "203.0.113.7:51234",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}, "X-Forwarded-Proto": {"https"}},
+			want:       Client{IP: "203.0.113.7"},
+		},
+		{
+			name:       "Trusted proxy",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}, "X-Forwarded-Proto": {"https"}},
+			want:       Client{IP: "203.0.113.7", Secure: true, Proxied: true, Proto: "https"},
+		},
+		{
+			name:       "Trusted proxy over plain HTTP",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}, "X-Forwarded-Proto": {"http"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true, Proto: "http"},
+		},
+		{
+			name:       "Trusted proxy without header",
+			remoteAddr: "10.0.0.2:40000",
+			want:       Client{IP: "10.0.0.2"},
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, 10.0.0.5"}, "X-Forwarded-Proto": {"HTTPS"}},
+			want:       Client{IP: "203.0.113.7", Secure: true, Proxied: true, Proto: "https"},
+		},
+		{
+			name:       "Client prepends a spoofed hop",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true},
+		},
+		{
+			name:       "Client spoofs a trusted hop",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.9, 203.0.113.7"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true},
+		},
+		{
+			name:       "Hops split across headers",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7, 10.0.0.5"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true},
+		},
+		{
+			name:       "Garbage hop",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, <script>, 10.0.0.5"}},
+			want:       Client{IP: "10.0.0.5", Proxied: true},
+		},
+		{
+			name:       "Every hop trusted",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.9, 10.0.0.5"}},
+			want:       Client{IP: "10.0.0.9", Proxied: true},
+		},
+		{
+			name:       "Client spoofs X-Forwarded-Proto",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}, "X-Forwarded-Proto": {"https, http"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true, Proto: "http"},
+		},
+		{
+			name:       "Ignored Forwarded header",
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https"}, "X-Forwarded-For": {"203.0.113.7"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true},
+		},
+		{
+			name:       "IPv6 hops with ports",
+			remoteAddr: "[2001:db8::2]:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"[2001:db9::7]:51234, 2001:db8::5"}},
+			want:       Client{IP: "2001:db9::7", Proxied: true},
+		},
+		{
+			name:       "IPv4-mapped IPv6 remote address",
+			remoteAddr: "[::ffff:10.0.0.2]:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true},
+		},
+		{
+			name:       "Forwarded",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"Forwarded": {`for=203.0.113.7;proto=https, for="[2001:db8::5]:8080";proto=http`}},
+			want:       Client{IP: "203.0.113.7", Secure: true, Proxied: true, Proto: "https"},
+		},
+		{
+			name:       "Forwarded with a spoofed element",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https", "for=203.0.113.7;proto=http"}},
+			want:       Client{IP: "203.0.113.7", Proxied: true, Proto: "http"},
+		},
+		{
+			name:       "Forwarded with an obfuscated hop",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"Forwarded": {"for=_hidden, for=10.0.0.5;proto=https"}},
+			want:       Client{IP: "10.0.0.5", Secure: true, Proxied: true, Proto: "https"},
+		},
+		{
+			name:       "Ignored X-Forwarded-For header",
+			header:     HeaderForwarded,
+			remoteAddr: "10.0.0.2:40000",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       Client{IP: "10.0.0.2"},
+		},
+		{
+			name:       "Remote address without port",
+			remoteAddr: "pipe",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       Client{IP: "pipe"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := tc.header
+			if header == "" {
+				header = HeaderXForwardedFor
+			}
+			res, err := New(trusted, header)
+			require.NoError(t, err)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, values := range tc.headers {
+				for _, v := range values {
+					r.Header.Add(name, v)
+				}
+			}
+
+			assert.Equal(t, tc.want, res.Client(r))
+		})
+	}
+}
+
+func TestNew_UnknownHeader(t *testing.T) {
+	_, err := New(nil, "X-Real-IP")
+	assert.EqualError(t, err, `realip: unknown header "X-Real-IP"`)
+}
@@ -0,0 +1,219 @@
+// Package secrets finds credentials, such as cloud keys, private keys and tokens,
+// in text which is about to be published.
+package secrets
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redacted replaces the secrets removed by Redact.
+const Redacted = "[REDACTED]"
+
+//go:embed rules.json
+var defaultRules []byte
+
+// Rule describes a kind of secret. Rules are read from JSON files, such as the
+// rules.json file embedded in the package.
+type Rule struct {
+	// ID names the rule, so that a file of overrides can replace it.
+	ID string `json:"id"`
+	// Description names the kind of secret for users, e.g. "AWS access key ID".
+	Description string `json:"description"`
+	// Pattern is the regular expression matching the secret, in RE2 syntax.
+	Pattern string `json:"pattern"`
+	// SecretGroup is the capturing group of Pattern holding the secret itself,
+	// or zero for the whole match.
+	SecretGroup int `json:"secret_group,omitempty"`
+	// MinEntropy, if set, is the Shannon entropy in bits per character below
+	// which a match is too regular to be a secret.
+	MinEntropy float64 `json:"min_entropy,omitempty"`
+	// Disabled turns off a rule of the defaults.
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+type rule struct {
+	Rule
+	rx *regexp.Regexp
+}
+
+// Finding is a secret found by a Scanner.
+type Finding struct {
+	RuleID      string
+	Description string
+	// Line is the line number the secret starts on, starting at 1.
+	Line int
+	// Start and End are the byte offsets of the secret in the text.
+	Start, End int
+}
+
+// Scanner finds secrets with a set of rules.
+type Scanner struct {
+	rules []rule
+}
+
+// DefaultRules returns the rules embedded in the package.
+func DefaultRules() []Rule {
+	rules, err := parseRules(defaultRules)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// Load returns a scanner with the default rules, overridden by the rules of the
+// JSON file at path unless it is empty. A rule of the file replaces the default
+// rule with the same ID, or adds a new rule if there is none.
+func Load(path string) (*Scanner, error) {
+	rules := DefaultRules()
+	if path == "" {
+		return NewScanner(rules)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("secret rules: %w", err)
+	}
+	overrides, err := parseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("secret rules %s: %w", path, err)
+	}
+
+	for _, override := range overrides {
+		i := indexOf(rules, override.ID)
+		if i < 0 {
+			rules = append(rules, override)
+		} else {
+			rules[i] = override
+		}
+	}
+
+	scanner, err := NewScanner(rules)
+	if err != nil {
+		return nil, fmt.Errorf("secret rules %s: %w", path, err)
+	}
+	return scanner, nil
+}
+
+// NewScanner returns a scanner applying the rules which aren't disabled. When
+// matches of several rules overlap, the first rule wins, so the most specific
+// rules must come first.
+func NewScanner(rules []Rule) (*Scanner, error) {
+	s := &Scanner{}
+	for _, r := range rules {
+		if r.Disabled {
+			continue
+		}
+		if r.ID == "" || r.Pattern == "" {
+			return nil, fmt.Errorf("rule %q: id and pattern are required", r.ID)
+		}
+
+		rx, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.ID, err)
+		}
+		if r.SecretGroup < 0 || r.SecretGroup > rx.NumSubexp() {
+			return nil, fmt.Errorf("rule %q: no capturing group %d", r.ID, r.SecretGroup)
+		}
+		s.rules = append(s.rules, rule{Rule: r, rx: rx})
+	}
+	return s, nil
+}
+
+// Scan returns the secrets found in text, in the order they appear.
+func (s *Scanner) Scan(text string) []Finding {
+	var findings []Finding
+	for _, r := range s.rules {
+		for _, match := range r.rx.FindAllStringSubmatchIndex(text, -1) {
+			start, end := match[2*r.SecretGroup], match[2*r.SecretGroup+1]
+			if start < 0 || start == end {
+				continue
+			}
+			if r.MinEntropy > 0 && entropy(text[start:end]) < r.MinEntropy {
+				continue
+			}
+			if overlaps(findings, start, end) {
+				continue
+			}
+
+			findings = append(findings, Finding{
+				RuleID:      r.ID,
+				Description: r.Description,
+				Line:        strings.Count(text[:start], "\n") + 1,
+				Start:       start,
+				End:         end,
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	return findings
+}
+
+// Redact replaces the secrets found in text with Redacted. The findings must be
+// the ones Scan returned for text.
+func Redact(text string, findings []Finding) string {
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(text[last:f.Start])
+		b.WriteString(Redacted)
+		last = f.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func parseRules(data []byte) ([]Rule, error) {
+	var file struct {
+		Rules []Rule `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	return file.Rules, nil
+}
+
+func indexOf(rules []Rule, id string) int {
+	for i, r := range rules {
+		if r.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func overlaps(findings []Finding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.End && f.Start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+
+	var h float64
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
@@ -0,0 +1,147 @@
+package secrets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestScanner(t *testing.T) *Scanner {
+	t.Helper()
+	s, err := Load("")
+	require.NoError(t, err)
+	return s
+}
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+// found is the rule and the line of a finding.
+type found struct {
+	ruleID string
+	line   int
+}
+
+func TestScanner_Scan(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    []found
+	}{
+		{
+			fixture: "aws.txt",
+			want:    []found{{"aws-access-key-id", 3}, {"aws-secret-access-key", 4}},
+		},
+		{
+			fixture: "private_key.txt",
+			want:    []found{{"private-key", 3}, {"private-key", 14}},
+		},
+		{
+			fixture: "jwt.txt",
+			want:    []found{{"jwt", 2}},
+		},
+		{
+			fixture: "slack.txt",
+			want:    []found{{"slack-token", 1}, {"slack-webhook", 4}},
+		},
+		{
+			fixture: "cloud.txt",
+			want:    []found{{"gcp-api-key", 1}, {"github-token", 2}, {"azure-storage-key", 3}, {"stripe-key", 4}},
+		},
+		{
+			fixture: "generic.txt",
+			want:    []found{{"generic-secret", 3}, {"generic-secret", 4}, {"high-entropy-string", 5}},
+		},
+		{
+			fixture: "clean.txt",
+		},
+	}
+
+	s := newTestScanner(t)
+	for _, tc := range tests {
+		t.Run(tc.fixture, func(t *testing.T) {
+			var got []found
+			for _, f := range s.Scan(readFixture(t, tc.fixture)) {
+				got = append(got, found{f.RuleID, f.Line})
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	s := newTestScanner(t)
+
+	text := readFixture(t, "aws.txt")
+	redacted := Redact(text, s.Scan(text))
+	assert.Empty(t, s.Scan(redacted))
+	assert.Contains(t, redacted, "aws_access_key_id = [REDACTED]\naws_secret_access_key = [REDACTED]\nregion = eu-west-1\n")
+
+	text = readFixture(t, "private_key.txt")
+	redacted = Redact(text, s.Scan(text))
+	assert.Equal(t, "Here is the deploy key, don't lose it:\n\n[REDACTED]\n\nAnd the old one, pasted half:\n[REDACTED]", redacted)
+}
+
+func TestLoad_Overrides(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	overrides := `{"rules": [
+		{"id": "high-entropy-string", "disabled": true},
+		{"id": "slack-token", "description": "Slack bot token", "pattern": "\\bxoxb-[0-9A-Za-z-]{10,}"},
+		{"id": "internal-token", "description": "internal token", "pattern": "\\bsbx_[0-9a-f]{32}\\b"}
+	]}`
+	require.NoError(t, os.WriteFile(path, []byte(overrides), 0o600))
+
+	s, err := Load(path)
+	require.NoError(t, err)
+
+	findings := s.Scan(readFixture(t, "generic.txt"))
+	require.Len(t, findings, 2, "the high entropy rule is disabled")
+
+	findings = s.Scan(readFixture(t, "slack.txt"))
+	require.Len(t, findings, 2)
+	assert.Equal(t, "Slack bot token", findings[0].Description)
+
+	findings = s.Scan("SNIPPETBOX_TOKEN=sbx_" + strings.Repeat("0f", 16))
+	require.Len(t, findings, 1)
+	assert.Equal(t, "internal-token", findings[0].RuleID)
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr string
+	}{
+		{name: "Invalid JSON", file: `{"rules": [}`, wantErr: "invalid character"},
+		{name: "Unknown field", file: `{"rules": [{"id": "x", "regex": "x"}]}`, wantErr: `unknown field "regex"`},
+		{name: "Missing pattern", file: `{"rules": [{"id": "x"}]}`, wantErr: `rule "x": id and pattern are required`},
+		{name: "Invalid pattern", file: `{"rules": [{"id": "x", "pattern": "(x"}]}`, wantErr: `rule "x": error parsing regexp`},
+		{name: "Missing group", file: `{"rules": [{"id": "x", "pattern": "x", "secret_group": 1}]}`, wantErr: `rule "x": no capturing group 1`},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(path, []byte(tc.file), 0o600))
+
+			_, err := Load(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+
+	_, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEntropy(t *testing.T) {
+	assert.Equal(t, 0.0, entropy("aaaa"))
+	assert.Equal(t, 1.0, entropy("abab"))
+	assert.Equal(t, 2.0, entropy("abcd"))
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net/url"
+	"strings"
+)
+
+// Backend is a database the stores can run on.
+type Backend string
+
+const (
+	Postgres Backend = "postgres"
+	SQLite   Backend = "sqlite"
+)
+
+// sqliteScheme prefixes the DSNs of SQLite databases, as in sqlite://snippetbox.db.
+// It is the scheme the migrate CLI uses too.
+const sqliteScheme = "sqlite://"
+
+// sqliteParams are the connection parameters every SQLite database is opened
+// with, unless the DSN sets them itself:
+//   - foreign keys are off by default in SQLite,
+//   - concurrent writers wait for each other rather than fail,
+//   - WAL lets readers go on while a transaction writes,
+//   - times are written in a format SQLite can compare and do date math on,
+//   - transactions take the write lock as they begin, which serialises them the
+//     way SELECT ... FOR UPDATE does in Postgres, see forUpdate.
+var sqliteParams = []struct{ key, value string }{
+	{"_pragma", "foreign_keys(1)"},
+	{"_pragma", "busy_timeout(5000)"},
+	{"_pragma", "journal_mode(WAL)"},
+	{"_time_format", "sqlite"},
+	{"_txlock", "immediate"},
+}
+
+// ParseDSN returns the backend of a data source name, and the driver and the
+// data source name of that driver to open the database with. DSNs starting
+// with sqlite:// name the file of an SQLite database, which is created if it
+// doesn't exist. Any other DSN is left to Postgres.
+func ParseDSN(dsn string) (Backend, driver.Driver, string, error) {
+	if !strings.HasPrefix(dsn, sqliteScheme) {
+		return Postgres, &pq.Driver{}, dsn, nil
+	}
+
+	path, rawQuery, _ := strings.Cut(strings.TrimPrefix(dsn, sqliteScheme), "?")
+	if path == "" {
+		return "", nil, "", fmt.Errorf("store: SQLite DSN %q has no file path", dsn)
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, "", fmt.Errorf("store: SQLite DSN %q has invalid parameters: %w", dsn, err)
+	}
+
+	for _, p := range sqliteParams {
+		if !hasSQLiteParam(query, p.key, p.value) {
+			query.Add(p.key, p.value)
+		}
+	}
+
+	return SQLite, &sqlite.Driver{}, path + "?" + query.Encode(), nil
+}
+
+// hasSQLiteParam reports whether query already sets the parameter, or for
+// _pragma parameters the pragma, of key and value.
+func hasSQLiteParam(query url.Values, key, value string) bool {
+	if key != "_pragma" {
+		return query.Has(key)
+	}
+
+	name, _, _ := strings.Cut(value, "(")
+	for _, v := range query[key] {
+		if pragma, _, _ := strings.Cut(v, "("); strings.EqualFold(strings.TrimSpace(pragma), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open opens a connection pool to the database of dsn, see ParseDSN, and
+// checks that it can be reached.
+func Open(dsn string) (*sql.DB, Backend, error) {
+	backend, d, name, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, "", err
+	}
+
+	db := sql.OpenDB(connector{driver: d, name: name})
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, "", err
+	}
+
+	return db, backend, nil
+}
+
+// connector opens connections to a database with a driver which isn't looked
+// up by name.
+type connector struct {
+	driver driver.Driver
+	name   string
+}
+
+func (c connector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.name)
+}
+
+func (c connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// backendOf returns the backend db runs on.
+func backendOf(db *sql.DB) Backend {
+	if _, ok := db.Driver().(*sqlite.Driver); ok {
+		return SQLite
+	}
+	return Postgres
+}
+
+// sessionExpiry returns the expression comparing a time parameter with the
+// expiry of the sessions of scs. The SQLite store of scs keeps it as a Julian
+// day, which SQLite would otherwise compare as less than any time.
+func sessionExpiry(db *sql.DB, param string) string {
+	if backendOf(db) == SQLite {
+		return "julianday(" + param + ")"
+	}
+	return param
+}
+
+// forUpdate returns the clause which locks the rows a SELECT in a transaction
+// returns, only those of the table aliased as of if it isn't empty. SQLite has no
+// such clause, and needs none since its transactions lock the whole database.
+func forUpdate(db *sql.DB, of string) string {
+	switch {
+	case backendOf(db) == SQLite:
+		return ""
+	case of != "":
+		return " FOR UPDATE OF " + of
+	default:
+		return " FOR UPDATE"
+	}
+}
+
+// isUniqueViolation reports whether err is a violation of the unique constraint
+// on the column of table, which the Postgres schema names table_uc_column.
+func isUniqueViolation(err error, table, column string) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505" && strings.Contains(pqErr.Message, table+"_uc_"+column)
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE && strings.Contains(sqliteErr.Error(), table+"."+column)
+	}
+
+	return false
+}
+
+// isForeignKeyViolation reports whether err is a violation of a foreign key
+// constraint.
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+	}
+
+	return false
+}
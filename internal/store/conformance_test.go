@@ -0,0 +1,45 @@
+package store_test
+
+import (
+	"database/sql"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/store/storetest"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"testing"
+)
+
+func snippetsOn(newDB func(t *testing.T) *sql.DB) storetest.SnippetsFactory {
+	return func(t *testing.T, clock storetest.Clock) store.Snippets {
+		s := store.NewSnippetStore(newDB(t), 0)
+		s.SetClock(clock)
+		return s
+	}
+}
+
+func usersOn(newDB func(t *testing.T) *sql.DB) storetest.UsersFactory {
+	return func(t *testing.T, clock storetest.Clock) store.Users {
+		s := store.NewUserStore(newDB(t), store.TestHasher, 0)
+		s.SetClock(clock)
+		return s
+	}
+}
+
+func TestConformance_Postgres(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+
+	t.Run("Snippets", func(t *testing.T) {
+		storetest.TestSnippets(t, snippetsOn(store.NewEmptyPostgresTestDB))
+	})
+	t.Run("Users", func(t *testing.T) {
+		storetest.TestUsers(t, usersOn(store.NewEmptyPostgresTestDB))
+	})
+}
+
+func TestConformance_SQLite(t *testing.T) {
+	t.Run("Snippets", func(t *testing.T) {
+		storetest.TestSnippets(t, snippetsOn(store.NewEmptySQLiteTestDB))
+	})
+	t.Run("Users", func(t *testing.T) {
+		storetest.TestUsers(t, usersOn(store.NewEmptySQLiteTestDB))
+	})
+}
@@ -6,4 +6,11 @@ var (
 	ErrNoRecord           = errors.New("store: no matching record found")
 	ErrInvalidCredentials = errors.New("store: invalid credentials")
 	ErrDuplicateEmail     = errors.New("store: duplicate email")
+	ErrDuplicateTeam      = errors.New("store: duplicate team")
+	ErrLastOwner          = errors.New("store: last owner of team")
+
+	// ErrRehash wraps the error which stopped the outdated password hash of an
+	// authenticated user from being replaced. It doesn't mean that the
+	// authentication failed.
+	ErrRehash = errors.New("store: could not replace password hash")
 )
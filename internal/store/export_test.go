@@ -0,0 +1,54 @@
+package store
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+// The conformance tests are in package store_test, since the storetest package
+// imports this one. These give them what they need of the unexported parts.
+
+var TestHasher = testHasher
+
+// MigrateUp and MigrateDown apply and revert every migration of a test
+// database. They are set by migrate_test.go, since the migrate package imports
+// this one.
+var MigrateUp, MigrateDown func(db *sql.DB, backend Backend) error
+
+func (s *SnippetStore) SetClock(clock interface{ GetCurrentTimeUTC() time.Time }) {
+	s.datetimeHandler = clock
+}
+
+func (s *UserStore) SetClock(clock interface{ GetCurrentTimeUTC() time.Time }) {
+	s.datetimeHandler = clock
+}
+
+// NewEmptyPostgresTestDB returns a Postgres test database without any rows,
+// which is dropped at the end of the test.
+func NewEmptyPostgresTestDB(t *testing.T) *sql.DB {
+	db, testDBName := newTestDB(t)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDBName)
+	})
+	setupDB(t, db)
+
+	_, err := db.Exec(`TRUNCATE snippets, users RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// NewEmptySQLiteTestDB returns an SQLite test database without any rows.
+func NewEmptySQLiteTestDB(t *testing.T) *sql.DB {
+	db := newSQLiteTestDB(t)
+
+	// The first migration adds a few snippets to start with.
+	_, err := db.Exec(`DELETE FROM snippets`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
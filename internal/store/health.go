@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// HealthStore answers the questions the readiness checks ask about the database.
+type HealthStore struct {
+	db *sql.DB
+}
+
+func NewHealthStore(db *sql.DB) *HealthStore {
+	return &HealthStore{db: db}
+}
+
+// Ping checks that the database can be reached.
+func (s *HealthStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// MigrationVersion returns the version of the last migration applied to the
+// database, and whether it failed half-way. It returns ErrNoRecord if no
+// migration was ever applied.
+func (s *HealthStore) MigrationVersion(ctx context.Context) (int, bool, error) {
+	stmt := `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	var version int
+	var dirty bool
+	err := s.db.QueryRowContext(ctx, stmt).Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, ErrNoRecord
+		} else {
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
+
+// SessionCount returns the number of rows in the sessions table, expired ones
+// included.
+func (s *HealthStore) SessionCount(ctx context.Context) (int, error) {
+	stmt := `SELECT count(*) FROM sessions`
+
+	var count int
+	err := s.db.QueryRowContext(ctx, stmt).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHealthStore(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewHealthStore(db)
+	ctx := context.Background()
+
+	t.Run("Ping", func(t *testing.T) {
+		assert.NoError(t, s.Ping(ctx))
+	})
+
+	t.Run("SessionCount", func(t *testing.T) {
+		count, err := s.SessionCount(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("MigrationVersion", func(t *testing.T) {
+		// The test database was migrated to the latest version.
+		version, dirty, err := s.MigrationVersion(ctx)
+		require.NoError(t, err)
+		assert.Positive(t, version)
+		assert.False(t, dirty)
+
+		_, err = db.Exec(`UPDATE schema_migrations SET version = 10, dirty = true`)
+		require.NoError(t, err)
+
+		version, dirty, err = s.MigrationVersion(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 10, version)
+		assert.True(t, dirty)
+
+		_, err = db.Exec(`DELETE FROM schema_migrations`)
+		require.NoError(t, err)
+
+		_, _, err = s.MigrationVersion(ctx)
+		assert.ErrorIs(t, err, ErrNoRecord)
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := s.SessionCount(cancelled)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
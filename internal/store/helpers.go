@@ -0,0 +1,55 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// likeEscaper escapes the wildcard characters of LIKE patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// nullID maps the zero ID, which stands for "none", to NULL.
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// checkRowsAffected returns ErrNoRecord if the statement didn't change any rows.
+func checkRowsAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// withTimeout bounds ctx by timeout, unless it is 0, for the duration of a store
+// method. The returned function must be deferred with a pointer to the error the
+// method returns: the driver reports statements cancelled by their context as
+// errors of its own, so once the context is done the error is replaced by the
+// context's, which callers can check for context.DeadlineExceeded. Errors
+// wrapping ErrRehash are wrapped around the context's error instead, as they
+// don't mean that the method failed.
+func withTimeout(ctx context.Context, timeout time.Duration, err *error) (context.Context, func()) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	return ctx, func() {
+		if *err != nil && ctx.Err() != nil {
+			if errors.Is(*err, ErrRehash) {
+				*err = fmt.Errorf("%w: %w", ErrRehash, ctx.Err())
+			} else {
+				*err = ctx.Err()
+			}
+		}
+		cancel()
+	}
+}
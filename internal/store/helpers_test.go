@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+	driverErr := errors.New("pq: canceling statement due to user request")
+
+	t.Run("Timed out", func(t *testing.T) {
+		err := driverErr
+		ctx, done := withTimeout(context.Background(), time.Millisecond, &err)
+		<-ctx.Done()
+		done()
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Cancelled by the caller", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := driverErr
+		_, done := withTimeout(parent, time.Minute, &err)
+		done()
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("Timed out replacing a password hash", func(t *testing.T) {
+		err := fmt.Errorf("%w: %w", ErrRehash, driverErr)
+		ctx, done := withTimeout(context.Background(), time.Millisecond, &err)
+		<-ctx.Done()
+		done()
+		assert.ErrorIs(t, err, ErrRehash)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Failed in time", func(t *testing.T) {
+		err := driverErr
+		ctx, done := withTimeout(context.Background(), time.Minute, &err)
+		done()
+		assert.Equal(t, driverErr, err)
+		assert.Error(t, ctx.Err(), "the context is released")
+	})
+
+	t.Run("Succeeded", func(t *testing.T) {
+		var err error
+		ctx, done := withTimeout(context.Background(), time.Millisecond, &err)
+		<-ctx.Done()
+		done()
+		assert.NoError(t, err)
+	})
+
+	t.Run("No timeout", func(t *testing.T) {
+		var err error
+		ctx, done := withTimeout(context.Background(), 0, &err)
+		defer done()
+		_, ok := ctx.Deadline()
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,33 @@
+package store_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/96malhar/snippetbox/internal/migrate"
+	"github.com/96malhar/snippetbox/internal/store"
+)
+
+// The test databases get their schema from the migrations, like the databases
+// of the application do.
+func init() {
+	store.MigrateUp = func(db *sql.DB, backend store.Backend) error {
+		return migrateTo(db, backend, -1)
+	}
+	store.MigrateDown = func(db *sql.DB, backend store.Backend) error {
+		return migrateTo(db, backend, 0)
+	}
+}
+
+// migrateTo migrates db to version, or to the latest version if version is
+// negative.
+func migrateTo(db *sql.DB, backend store.Backend, version int) error {
+	m, err := migrate.New(db, backend)
+	if err != nil {
+		return err
+	}
+	if version < 0 {
+		version = m.Latest()
+	}
+	_, err = m.To(context.Background(), version)
+	return err
+}
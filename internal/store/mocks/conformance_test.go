@@ -0,0 +1,24 @@
+package mocks
+
+import (
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/96malhar/snippetbox/internal/store/storetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	t.Run("Snippets", func(t *testing.T) {
+		storetest.TestSnippets(t, func(t *testing.T, clock storetest.Clock) store.Snippets {
+			s := NewMockSnippetStore()
+			s.datetimeHandler = clock
+			return s
+		})
+	})
+	t.Run("Users", func(t *testing.T) {
+		storetest.TestUsers(t, func(t *testing.T, clock storetest.Clock) store.Users {
+			s := NewMockUserStore()
+			s.datetimeHandler = clock
+			return s
+		})
+	})
+}
@@ -0,0 +1,43 @@
+package mocks
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/store"
+)
+
+// MockHealthStore reports a database with every migration applied, unless its
+// fields are changed.
+type MockHealthStore struct {
+	Err      error
+	Version  int
+	Dirty    bool
+	Sessions int
+}
+
+func (m *MockHealthStore) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return m.Err
+}
+
+func (m *MockHealthStore) MigrationVersion(ctx context.Context) (int, bool, error) {
+	if err := m.Ping(ctx); err != nil {
+		return 0, false, err
+	}
+	if m.Version == 0 {
+		return 0, false, store.ErrNoRecord
+	}
+	return m.Version, m.Dirty, nil
+}
+
+func (m *MockHealthStore) SessionCount(ctx context.Context) (int, error) {
+	if err := m.Ping(ctx); err != nil {
+		return 0, err
+	}
+	return m.Sessions, nil
+}
+
+func NewMockHealthStore(version int) *MockHealthStore {
+	return &MockHealthStore{Version: version}
+}
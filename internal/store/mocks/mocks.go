@@ -0,0 +1,14 @@
+// Package mocks implements the store interfaces in memory, for the tests of the
+// handlers.
+package mocks
+
+import "github.com/96malhar/snippetbox/internal/store"
+
+var (
+	_ store.Snippets     = (*MockSnippetStore)(nil)
+	_ store.Users        = (*MockUserStore)(nil)
+	_ store.UserSessions = (*MockUserSessionStore)(nil)
+	_ store.Teams        = (*MockTeamStore)(nil)
+	_ store.Moderation   = (*MockModerationStore)(nil)
+	_ store.Health       = (*MockHealthStore)(nil)
+)
@@ -0,0 +1,93 @@
+package mocks
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/store"
+)
+
+type MockModerationStore struct {
+	snippets *MockSnippetStore
+	reports  []*store.Report
+	actions  []*store.ModerationAction
+}
+
+func (m *MockModerationStore) Report(snippetID int, reason, details string) error {
+	sn, err := m.snippets.Get(context.TODO(), snippetID)
+	if err != nil {
+		return err
+	}
+	report := store.Report{
+		ID:           len(m.reports) + 1,
+		SnippetID:    snippetID,
+		SnippetTitle: sn.Title,
+		Reason:       reason,
+		Details:      details,
+		Created:      mockCurrentTime,
+	}
+	m.reports = append(m.reports, &report)
+	return nil
+}
+
+func (m *MockModerationStore) OpenReports() ([]*store.Report, error) {
+	return m.reports, nil
+}
+
+func (m *MockModerationStore) Dismiss(snippetID, moderatorID int) error {
+	return m.act(snippetID, moderatorID, store.ModerationDismiss)
+}
+
+func (m *MockModerationStore) Hide(snippetID, moderatorID int) error {
+	sn, err := m.snippets.Get(context.TODO(), snippetID)
+	if err != nil {
+		return err
+	}
+	sn.Hidden = true
+	return m.act(snippetID, moderatorID, store.ModerationHide)
+}
+
+func (m *MockModerationStore) Delete(snippetID, moderatorID int) error {
+	err := m.act(snippetID, moderatorID, store.ModerationDelete)
+	if err != nil {
+		return err
+	}
+	return m.snippets.Delete(context.TODO(), snippetID)
+}
+
+func (m *MockModerationStore) Actions(limit int) ([]*store.ModerationAction, error) {
+	var actions []*store.ModerationAction
+	for i := len(m.actions) - 1; i >= 0 && len(actions) < limit; i-- {
+		actions = append(actions, m.actions[i])
+	}
+	return actions, nil
+}
+
+func (m *MockModerationStore) act(snippetID, moderatorID int, action string) error {
+	if _, err := m.snippets.Get(context.TODO(), snippetID); err != nil {
+		return err
+	}
+
+	var open []*store.Report
+	for _, r := range m.reports {
+		if r.SnippetID != snippetID {
+			open = append(open, r)
+		}
+	}
+	m.reports = open
+
+	m.actions = append(m.actions, &store.ModerationAction{
+		ID:          len(m.actions) + 1,
+		SnippetID:   snippetID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		Created:     mockCurrentTime,
+	})
+	return nil
+}
+
+// NewMockModerationStore returns a mock which acts on the snippets of the given
+// mock snippet store.
+func NewMockModerationStore(snippets *MockSnippetStore) *MockModerationStore {
+	return &MockModerationStore{
+		snippets: snippets,
+	}
+}
@@ -1,45 +1,175 @@
 package mocks
 
 import (
+	"context"
+	datetimeMocks "github.com/96malhar/snippetbox/internal/datetime/mocks"
 	"github.com/96malhar/snippetbox/internal/store"
 	"time"
 )
 
+// mockCurrentTime is the time the mock snippet store treats as now.
+var mockCurrentTime = time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+
 type MockSnippetStore struct {
-	snippets []*store.Snippet
+	snippets        []*store.Snippet
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
 }
 
-func (m *MockSnippetStore) Insert(title string, content string, expirationDays int) (int, error) {
-	currentTime := time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+func (m *MockSnippetStore) Insert(ctx context.Context, title string, content string, expirationDays int, teamID int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	now := m.datetimeHandler.GetCurrentTimeUTC()
 	snippet := store.Snippet{
 		ID:      m.generateId(),
 		Title:   title,
 		Content: content,
-		Expires: currentTime.Add(time.Hour * 24 * time.Duration(expirationDays)),
+		Created: now,
+		Expires: now.Add(time.Hour * 24 * time.Duration(expirationDays)),
+		TeamID:  teamID,
 	}
 	m.snippets = append(m.snippets, &snippet)
 	return snippet.ID, nil
 }
 
-func (m *MockSnippetStore) Get(id int) (*store.Snippet, error) {
+func (m *MockSnippetStore) Get(ctx context.Context, id int) (*store.Snippet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sn := m.find(id)
+	if sn == nil || !m.active(sn) {
+		return nil, store.ErrNoRecord
+	}
+	return sn, nil
+}
+
+func (m *MockSnippetStore) Latest(ctx context.Context) ([]*store.Snippet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var snippets []*store.Snippet
+	for i := len(m.snippets) - 1; i >= 0 && len(snippets) < 10; i-- {
+		sn := m.snippets[i]
+		if m.active(sn) && !sn.Hidden && sn.TeamID == 0 {
+			snippets = append(snippets, sn)
+		}
+	}
+	return snippets, nil
+}
+
+func (m *MockSnippetStore) List(ctx context.Context, limit int) ([]*store.Snippet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var snippets []*store.Snippet
+	for i := len(m.snippets) - 1; i >= 0 && len(snippets) < limit; i-- {
+		snippets = append(snippets, m.snippets[i])
+	}
+	return snippets, nil
+}
+
+func (m *MockSnippetStore) ListForTeam(ctx context.Context, teamID int) ([]*store.Snippet, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var snippets []*store.Snippet
+	for i := len(m.snippets) - 1; i >= 0; i-- {
+		sn := m.snippets[i]
+		if m.active(sn) && sn.TeamID == teamID && !sn.Hidden {
+			snippets = append(snippets, sn)
+		}
+	}
+	return snippets, nil
+}
+
+func (m *MockSnippetStore) Update(ctx context.Context, id int, title, content string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sn, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	sn.Title = title
+	sn.Content = content
+	return nil
+}
+
+func (m *MockSnippetStore) Count(ctx context.Context) (int, int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	var active int
+	for _, sn := range m.snippets {
+		if m.active(sn) {
+			active++
+		}
+	}
+	return len(m.snippets), active, nil
+}
+
+func (m *MockSnippetStore) Expire(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sn := m.find(id)
+	if sn == nil {
+		return store.ErrNoRecord
+	}
+	sn.Expires = m.datetimeHandler.GetCurrentTimeUTC()
+	return nil
+}
+
+func (m *MockSnippetStore) Delete(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i, sn := range m.snippets {
+		if sn.ID == id {
+			m.snippets = append(m.snippets[:i], m.snippets[i+1:]...)
+			return nil
+		}
+	}
+	return store.ErrNoRecord
+}
+
+// find returns the snippet with the given id, expired or not, or nil.
+func (m *MockSnippetStore) find(id int) *store.Snippet {
 	for _, sn := range m.snippets {
 		if sn.ID == id {
-			return sn, nil
+			return sn
 		}
 	}
-	return nil, store.ErrNoRecord
+	return nil
 }
 
-func (m *MockSnippetStore) Latest() ([]*store.Snippet, error) {
-	return m.snippets, nil
+// active reports whether a snippet hasn't expired yet.
+func (m *MockSnippetStore) active(sn *store.Snippet) bool {
+	return sn.Expires.After(m.datetimeHandler.GetCurrentTimeUTC())
 }
 
 func (m *MockSnippetStore) generateId() int {
-	return len(m.snippets) + 1
+	var maxId int
+	for _, sn := range m.snippets {
+		maxId = max(maxId, sn.ID)
+	}
+	return maxId + 1
 }
 
 func NewMockSnippetStore(seed ...*store.Snippet) *MockSnippetStore {
 	return &MockSnippetStore{
-		snippets: seed,
+		snippets:        seed,
+		datetimeHandler: datetimeMocks.NewMockDateTimeHandler(mockCurrentTime),
 	}
 }
@@ -0,0 +1,231 @@
+package mocks
+
+import (
+	"context"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/store"
+	"strings"
+)
+
+type mockMembership struct {
+	teamID int
+	userID int
+	role   store.TeamRole
+}
+
+type mockInvite struct {
+	invite *store.TeamInvite
+	token  string
+}
+
+type MockTeamStore struct {
+	users   *MockUserStore
+	teams   []*store.Team
+	members []*mockMembership
+	invites []*mockInvite
+}
+
+func (m *MockTeamStore) Create(name string, ownerID int) (*store.Team, error) {
+	slug := store.TeamSlug(name)
+	if _, err := m.GetBySlug(slug); err == nil {
+		return nil, store.ErrDuplicateTeam
+	}
+
+	team := store.Team{ID: len(m.teams) + 1, Name: name, Slug: slug, Created: mockCurrentTime}
+	m.teams = append(m.teams, &team)
+	m.members = append(m.members, &mockMembership{teamID: team.ID, userID: ownerID, role: store.TeamRoleOwner})
+	return &team, nil
+}
+
+func (m *MockTeamStore) Get(id int) (*store.Team, error) {
+	for _, t := range m.teams {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, store.ErrNoRecord
+}
+
+func (m *MockTeamStore) GetBySlug(slug string) (*store.Team, error) {
+	for _, t := range m.teams {
+		if t.Slug == slug {
+			return t, nil
+		}
+	}
+	return nil, store.ErrNoRecord
+}
+
+func (m *MockTeamStore) ForUser(userID int) ([]*store.Team, error) {
+	var teams []*store.Team
+	for _, ms := range m.members {
+		if ms.userID == userID {
+			team, _ := m.Get(ms.teamID)
+			teams = append(teams, team)
+		}
+	}
+	return teams, nil
+}
+
+func (m *MockTeamStore) Members(teamID int) ([]*store.TeamMember, error) {
+	var members []*store.TeamMember
+	for _, ms := range m.members {
+		if ms.teamID == teamID {
+			user, err := m.users.Get(context.TODO(), ms.userID)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, &store.TeamMember{
+				UserID: user.ID,
+				Name:   user.Name,
+				Email:  user.Email,
+				Role:   ms.role,
+				Joined: mockCurrentTime,
+			})
+		}
+	}
+	return members, nil
+}
+
+func (m *MockTeamStore) Role(teamID, userID int) (store.TeamRole, error) {
+	for _, ms := range m.members {
+		if ms.teamID == teamID && ms.userID == userID {
+			return ms.role, nil
+		}
+	}
+	return "", store.ErrNoRecord
+}
+
+func (m *MockTeamStore) Invite(teamID int, email string, invitedBy int) (string, error) {
+	team, err := m.Get(teamID)
+	if err != nil {
+		return "", err
+	}
+
+	invite := &store.TeamInvite{
+		ID:       len(m.invites) + 1,
+		TeamID:   teamID,
+		TeamName: team.Name,
+		Email:    email,
+		Created:  mockCurrentTime,
+		Expires:  mockCurrentTime.AddDate(0, 0, 7),
+	}
+	token := fmt.Sprintf("invite-token-%d", invite.ID)
+	m.invites = append(m.invites, &mockInvite{invite: invite, token: token})
+	return token, nil
+}
+
+func (m *MockTeamStore) PendingInvites(teamID int) ([]*store.TeamInvite, error) {
+	var invites []*store.TeamInvite
+	for i := len(m.invites) - 1; i >= 0; i-- {
+		if m.invites[i].invite.TeamID == teamID {
+			invites = append(invites, m.invites[i].invite)
+		}
+	}
+	return invites, nil
+}
+
+func (m *MockTeamStore) InvitesForUser(userID int) ([]*store.TeamInvite, error) {
+	user, err := m.users.Get(context.TODO(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var invites []*store.TeamInvite
+	for i := len(m.invites) - 1; i >= 0; i-- {
+		invite := m.invites[i].invite
+		if strings.EqualFold(invite.Email, user.Email) {
+			if _, err := m.Role(invite.TeamID, userID); err != nil {
+				invites = append(invites, invite)
+			}
+		}
+	}
+	return invites, nil
+}
+
+func (m *MockTeamStore) AcceptInvite(token string, userID int) (*store.Team, error) {
+	return m.accept(func(i *mockInvite) bool { return i.token == token }, userID)
+}
+
+func (m *MockTeamStore) AcceptEmailInvite(inviteID, userID int) (*store.Team, error) {
+	return m.accept(func(i *mockInvite) bool { return i.invite.ID == inviteID && i.invite.Email != "" }, userID)
+}
+
+func (m *MockTeamStore) accept(match func(*mockInvite) bool, userID int) (*store.Team, error) {
+	user, err := m.users.Get(context.TODO(), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, mi := range m.invites {
+		if !match(mi) || (mi.invite.Email != "" && !strings.EqualFold(mi.invite.Email, user.Email)) {
+			continue
+		}
+
+		m.invites = append(m.invites[:i], m.invites[i+1:]...)
+		if _, err := m.Role(mi.invite.TeamID, userID); err != nil {
+			m.members = append(m.members, &mockMembership{teamID: mi.invite.TeamID, userID: userID, role: store.TeamRoleMember})
+		}
+		return m.Get(mi.invite.TeamID)
+	}
+	return nil, store.ErrNoRecord
+}
+
+func (m *MockTeamStore) RemoveMember(teamID, userID int) error {
+	role, err := m.Role(teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	owners, members := m.count(teamID)
+	if role == store.TeamRoleOwner && owners == 1 && members > 1 {
+		return store.ErrLastOwner
+	}
+
+	for i, ms := range m.members {
+		if ms.teamID == teamID && ms.userID == userID {
+			m.members = append(m.members[:i], m.members[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MockTeamStore) SetRole(teamID, userID int, role store.TeamRole) error {
+	current, err := m.Role(teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	owners, _ := m.count(teamID)
+	if current == store.TeamRoleOwner && role != store.TeamRoleOwner && owners == 1 {
+		return store.ErrLastOwner
+	}
+
+	for _, ms := range m.members {
+		if ms.teamID == teamID && ms.userID == userID {
+			ms.role = role
+		}
+	}
+	return nil
+}
+
+// count returns the number of owners and of members of a team.
+func (m *MockTeamStore) count(teamID int) (owners, members int) {
+	for _, ms := range m.members {
+		if ms.teamID == teamID {
+			members++
+			if ms.role == store.TeamRoleOwner {
+				owners++
+			}
+		}
+	}
+	return owners, members
+}
+
+// NewMockTeamStore returns a mock which looks up the members of its teams in
+// the given mock user store.
+func NewMockTeamStore(users *MockUserStore) *MockTeamStore {
+	return &MockTeamStore{
+		users: users,
+	}
+}
@@ -0,0 +1,77 @@
+package mocks
+
+import (
+	"github.com/96malhar/snippetbox/internal/store"
+	"time"
+)
+
+type MockUserSessionStore struct {
+	sessions []*store.UserSession
+}
+
+func (m *MockUserSessionStore) Insert(userID int, token, userAgent, ip string) error {
+	currentTime := time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+	session := store.UserSession{
+		ID:        m.generateId(),
+		UserID:    userID,
+		Token:     token,
+		UserAgent: userAgent,
+		IP:        ip,
+		Created:   currentTime,
+		LastSeen:  currentTime,
+	}
+	m.sessions = append(m.sessions, &session)
+	return nil
+}
+
+func (m *MockUserSessionStore) Touch(token string) error {
+	for _, us := range m.sessions {
+		if us.Token == token {
+			us.LastSeen = us.LastSeen.Add(time.Minute)
+		}
+	}
+	return nil
+}
+
+func (m *MockUserSessionStore) Get(id int) (*store.UserSession, error) {
+	for _, us := range m.sessions {
+		if us.ID == id {
+			return us, nil
+		}
+	}
+	return nil, store.ErrNoRecord
+}
+
+func (m *MockUserSessionStore) GetAllForUser(userID int) ([]*store.UserSession, error) {
+	var sessions []*store.UserSession
+	for _, us := range m.sessions {
+		if us.UserID == userID {
+			sessions = append(sessions, us)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *MockUserSessionStore) Delete(token string) error {
+	for i, us := range m.sessions {
+		if us.Token == token {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *MockUserSessionStore) generateId() int {
+	var maxId int
+	for _, us := range m.sessions {
+		maxId = max(maxId, us.ID)
+	}
+	return maxId + 1
+}
+
+func NewMockUserSessionStore(sessions ...*store.UserSession) *MockUserSessionStore {
+	return &MockUserSessionStore{
+		sessions: sessions,
+	}
+}
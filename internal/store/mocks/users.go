@@ -1,30 +1,69 @@
 package mocks
 
 import (
+	"context"
+	"fmt"
+	datetimeMocks "github.com/96malhar/snippetbox/internal/datetime/mocks"
 	"github.com/96malhar/snippetbox/internal/store"
+	"strings"
 	"time"
 )
 
+// mockUserStoreTime is the time the mock user store treats as now.
+var mockUserStoreTime = time.Date(1996, time.April, 28, 3, 0, 0, 0, time.UTC)
+
+// passwordResetLifetime matches the lifetime of the links of the real store.
+const passwordResetLifetime = 24 * time.Hour
+
+type mockPasswordReset struct {
+	userID  int
+	expires time.Time
+}
+
 type MockUserStore struct {
-	users []*store.User
+	users           []*store.User
+	recoveryCodes   map[int][]string
+	totpCounters    map[int]int64
+	identities      map[string]int
+	passwordResets  map[string]mockPasswordReset
+	resetCount      int
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
 }
 
-func (m *MockUserStore) Insert(name, email, password string) error {
+func (m *MockUserStore) Insert(ctx context.Context, name, email, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// The handler tests sign up with dupe@example.com to get a duplicate
+	// without signing up twice.
 	if email == "dupe@example.com" {
-		return store.ErrDuplicateEmail
+		return 0, store.ErrDuplicateEmail
+	}
+	for _, usr := range m.users {
+		if usr.Email == email {
+			return 0, store.ErrDuplicateEmail
+		}
 	}
 	user := store.User{
 		ID:             m.generateId(),
 		Name:           name,
 		Email:          email,
 		HashedPassword: []byte(password),
-		Created:        time.Date(1996, time.April, 28, 3, 0, 0, 0, time.UTC),
+		Created:        m.datetimeHandler.GetCurrentTimeUTC(),
+		Role:           store.RoleUser,
 	}
 	m.users = append(m.users, &user)
-	return nil
+	return user.ID, nil
 }
 
-func (m *MockUserStore) Authenticate(email, password string) (int, error) {
+func (m *MockUserStore) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	for _, usr := range m.users {
 		if usr.Email == email && string(usr.HashedPassword) == password {
 			return usr.ID, nil
@@ -33,7 +72,40 @@ func (m *MockUserStore) Authenticate(email, password string) (int, error) {
 	return 0, store.ErrInvalidCredentials
 }
 
-func (m *MockUserStore) Exists(id int) (bool, error) {
+func (m *MockUserStore) ProvisionIdentity(ctx context.Context, issuer, subject, name, email string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	key := issuer + " " + subject
+	if id, ok := m.identities[key]; ok {
+		return id, nil
+	}
+
+	for _, usr := range m.users {
+		if usr.Email == email {
+			m.identities[key] = usr.ID
+			return usr.ID, nil
+		}
+	}
+
+	user := store.User{
+		ID:      m.generateId(),
+		Name:    name,
+		Email:   email,
+		Created: m.datetimeHandler.GetCurrentTimeUTC(),
+		Role:    store.RoleUser,
+	}
+	m.users = append(m.users, &user)
+	m.identities[key] = user.ID
+	return user.ID, nil
+}
+
+func (m *MockUserStore) Exists(ctx context.Context, id int) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	for _, usr := range m.users {
 		if usr.ID == id {
 			return true, nil
@@ -42,7 +114,11 @@ func (m *MockUserStore) Exists(id int) (bool, error) {
 	return false, nil
 }
 
-func (m *MockUserStore) Get(id int) (*store.User, error) {
+func (m *MockUserStore) Get(ctx context.Context, id int) (*store.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	for _, usr := range m.users {
 		if usr.ID == id {
 			return usr, nil
@@ -51,12 +127,193 @@ func (m *MockUserStore) Get(id int) (*store.User, error) {
 	return nil, store.ErrNoRecord
 }
 
+func (m *MockUserStore) Search(ctx context.Context, query string, limit int) ([]*store.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var users []*store.User
+	for _, usr := range m.users {
+		if len(users) == limit {
+			break
+		}
+		if strings.Contains(strings.ToLower(usr.Name), strings.ToLower(query)) ||
+			strings.Contains(strings.ToLower(usr.Email), strings.ToLower(query)) {
+			users = append(users, usr)
+		}
+	}
+	return users, nil
+}
+
+func (m *MockUserStore) Count(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(m.users), nil
+}
+
+func (m *MockUserStore) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	usr.Disabled = disabled
+	return nil
+}
+
+func (m *MockUserStore) SetRole(ctx context.Context, id int, role store.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	usr.Role = role
+	return nil
+}
+
+func (m *MockUserStore) EnableTOTP(ctx context.Context, id int, secret string, recoveryCodes []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	usr.TOTPSecret = secret
+	usr.TOTPEnabled = true
+	m.recoveryCodes[id] = append([]string(nil), recoveryCodes...)
+	return nil
+}
+
+func (m *MockUserStore) DisableTOTP(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return nil
+	}
+	usr.TOTPSecret = ""
+	usr.TOTPEnabled = false
+	delete(m.recoveryCodes, id)
+	return nil
+}
+
+func (m *MockUserStore) UseRecoveryCode(ctx context.Context, id int, code string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	codes := m.recoveryCodes[id]
+	for i, c := range codes {
+		if c == code {
+			m.recoveryCodes[id] = append(codes[:i], codes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MockUserStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	if _, err := m.Get(ctx, id); err != nil || counter <= m.totpCounters[id] {
+		return false, nil
+	}
+	m.totpCounters[id] = counter
+	return true, nil
+}
+
+func (m *MockUserStore) UpdatePassword(ctx context.Context, id int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	usr, err := m.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	usr.HashedPassword = []byte(password)
+	return nil
+}
+
+func (m *MockUserStore) CreatePasswordReset(ctx context.Context, id int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if _, err := m.Get(ctx, id); err != nil {
+		return "", err
+	}
+	now := m.datetimeHandler.GetCurrentTimeUTC()
+	m.resetCount++
+	token := fmt.Sprintf("reset-token-%d", m.resetCount)
+	m.passwordResets[token] = mockPasswordReset{userID: id, expires: now.Add(passwordResetLifetime)}
+	return token, nil
+}
+
+func (m *MockUserStore) PasswordResetUser(ctx context.Context, token string) (*store.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	id, ok := m.passwordResetUserID(token)
+	if !ok {
+		return nil, store.ErrNoRecord
+	}
+	return m.Get(ctx, id)
+}
+
+func (m *MockUserStore) ResetPassword(ctx context.Context, token, password string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	id, ok := m.passwordResetUserID(token)
+	if !ok {
+		return 0, store.ErrNoRecord
+	}
+	for t, other := range m.passwordResets {
+		if other.userID == id {
+			delete(m.passwordResets, t)
+		}
+	}
+	return id, m.UpdatePassword(ctx, id, password)
+}
+
+// passwordResetUserID returns the id of the user an unexpired password reset
+// link is for.
+func (m *MockUserStore) passwordResetUserID(token string) (int, bool) {
+	reset, ok := m.passwordResets[token]
+	if !ok || !reset.expires.After(m.datetimeHandler.GetCurrentTimeUTC()) {
+		return 0, false
+	}
+	return reset.userID, true
+}
+
 func (m *MockUserStore) generateId() int {
 	return len(m.users) + 1
 }
 
 func NewMockUserStore(users ...*store.User) *MockUserStore {
 	return &MockUserStore{
-		users: users,
+		users:           users,
+		recoveryCodes:   make(map[int][]string),
+		totpCounters:    make(map[int]int64),
+		identities:      make(map[string]int),
+		passwordResets:  make(map[string]mockPasswordReset),
+		datetimeHandler: datetimeMocks.NewMockDateTimeHandler(mockUserStoreTime),
 	}
 }
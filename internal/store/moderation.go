@@ -0,0 +1,173 @@
+package store
+
+import (
+	"database/sql"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"time"
+)
+
+// Report is a complaint about a snippet, submitted by any visitor.
+type Report struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	Reason       string
+	Details      string
+	Created      time.Time
+}
+
+// ModerationAction records a decision a moderator took on a reported snippet.
+type ModerationAction struct {
+	ID            int
+	SnippetID     int
+	ModeratorID   int
+	ModeratorName string
+	Action        string
+	Created       time.Time
+}
+
+// The actions a moderator can take on a reported snippet.
+const (
+	ModerationDismiss = "dismiss"
+	ModerationHide    = "hide"
+	ModerationDelete  = "delete"
+)
+
+type ModerationStore struct {
+	db              *sql.DB
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+}
+
+func NewModerationStore(db *sql.DB) *ModerationStore {
+	return &ModerationStore{db: db, datetimeHandler: &datetime.Handler{}}
+}
+
+// Report files a new report about a snippet.
+func (s *ModerationStore) Report(snippetID int, reason, details string) error {
+	stmt := `INSERT INTO reports (snippet_id, reason, details, created)
+	VALUES($1, $2, $3, $4)`
+
+	_, err := s.db.Exec(stmt, snippetID, reason, details, s.datetimeHandler.GetCurrentTimeUTC())
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return ErrNoRecord
+		}
+		return err
+	}
+	return nil
+}
+
+// OpenReports returns the reports which haven't been dealt with yet, oldest first.
+func (s *ModerationStore) OpenReports() ([]*Report, error) {
+	stmt := `SELECT r.id, r.snippet_id, s.title, r.reason, r.details, r.created
+	FROM reports r INNER JOIN snippets s ON s.id = r.snippet_id
+	WHERE r.status = 'open' ORDER BY r.created, r.id`
+
+	rows, err := s.db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*Report
+	for rows.Next() {
+		var r Report
+		err = rows.Scan(&r.ID, &r.SnippetID, &r.SnippetTitle, &r.Reason, &r.Details, &r.Created)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, &r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+// Dismiss closes the open reports about a snippet without acting on the snippet.
+func (s *ModerationStore) Dismiss(snippetID, moderatorID int) error {
+	return s.act(snippetID, moderatorID, ModerationDismiss, "dismissed", "")
+}
+
+// Hide takes a snippet down and closes the open reports about it.
+func (s *ModerationStore) Hide(snippetID, moderatorID int) error {
+	return s.act(snippetID, moderatorID, ModerationHide, "actioned", "UPDATE snippets SET hidden = TRUE WHERE id = $1")
+}
+
+// Delete removes a snippet along with the reports about it.
+func (s *ModerationStore) Delete(snippetID, moderatorID int) error {
+	return s.act(snippetID, moderatorID, ModerationDelete, "actioned", "DELETE FROM snippets WHERE id = $1")
+}
+
+// act closes the open reports about a snippet with the given status, runs the
+// statement which acts on the snippet, if any, and records who did it and when.
+func (s *ModerationStore) act(snippetID, moderatorID int, action, status, snippetStmt string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT true FROM snippets WHERE id = $1)", snippetID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+
+	_, err = tx.Exec("UPDATE reports SET status = $1 WHERE snippet_id = $2 AND status = 'open'", status, snippetID)
+	if err != nil {
+		return err
+	}
+
+	if snippetStmt != "" {
+		_, err = tx.Exec(snippetStmt, snippetID)
+		if err != nil {
+			return err
+		}
+	}
+
+	stmt := `INSERT INTO moderation_actions (snippet_id, moderator_id, action, created)
+	VALUES($1, $2, $3, $4)`
+	_, err = tx.Exec(stmt, snippetID, moderatorID, action, s.datetimeHandler.GetCurrentTimeUTC())
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Actions returns up to limit of the most recent moderation actions.
+func (s *ModerationStore) Actions(limit int) ([]*ModerationAction, error) {
+	stmt := `SELECT a.id, a.snippet_id, a.moderator_id, u.name, a.action, a.created
+	FROM moderation_actions a INNER JOIN users u ON u.id = a.moderator_id
+	ORDER BY a.id DESC LIMIT $1`
+
+	rows, err := s.db.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []*ModerationAction
+	for rows.Next() {
+		var a ModerationAction
+		err = rows.Scan(&a.ID, &a.SnippetID, &a.ModeratorID, &a.ModeratorName, &a.Action, &a.Created)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
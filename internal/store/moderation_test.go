@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestModerationStore_Report(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewModerationStore(db)
+	mockCurrTime := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	require.NoError(t, s.Report(2, "spam", "Buy now!"))
+	require.NoError(t, s.Report(1, "secret", ""))
+	assert.ErrorIs(t, s.Report(3, "spam", ""), ErrNoRecord)
+
+	reports, err := s.OpenReports()
+	require.NoError(t, err)
+	wantReports := []*Report{
+		{ID: 1, SnippetID: 2, SnippetTitle: "Snippet 2 Title", Reason: "spam", Details: "Buy now!", Created: mockCurrTime},
+		{ID: 2, SnippetID: 1, SnippetTitle: "Snippet 1 Title", Reason: "secret", Created: mockCurrTime},
+	}
+	assert.Equal(t, wantReports, reports)
+}
+
+func TestModerationStore_Actions(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewModerationStore(db)
+	mockCurrTime := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+	snippets := NewSnippetStore(db, 0)
+	snippets.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	require.NoError(t, s.Report(1, "spam", ""))
+	require.NoError(t, s.Report(2, "spam", ""))
+
+	t.Run("Dismiss", func(t *testing.T) {
+		require.NoError(t, s.Dismiss(1, 1))
+
+		sn, err := snippets.Get(ctx, 1)
+		require.NoError(t, err)
+		assert.False(t, sn.Hidden)
+
+		reports, err := s.OpenReports()
+		require.NoError(t, err)
+		require.Len(t, reports, 1)
+		assert.Equal(t, 2, reports[0].SnippetID)
+	})
+
+	t.Run("Hide", func(t *testing.T) {
+		require.NoError(t, s.Hide(2, 1))
+
+		sn, err := snippets.Get(ctx, 2)
+		require.NoError(t, err)
+		assert.True(t, sn.Hidden)
+
+		latest, err := snippets.Latest(ctx)
+		require.NoError(t, err)
+		require.Len(t, latest, 1)
+		assert.Equal(t, 1, latest[0].ID)
+
+		reports, err := s.OpenReports()
+		require.NoError(t, err)
+		assert.Empty(t, reports)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.Delete(2, 1))
+
+		_, err := snippets.Get(ctx, 2)
+		assert.ErrorIs(t, err, ErrNoRecord)
+	})
+
+	t.Run("Unknown snippet", func(t *testing.T) {
+		assert.ErrorIs(t, s.Hide(3, 1), ErrNoRecord)
+	})
+
+	actions, err := s.Actions(10)
+	require.NoError(t, err)
+	wantActions := []*ModerationAction{
+		{ID: 3, SnippetID: 2, ModeratorID: 1, ModeratorName: "John", Action: ModerationDelete, Created: mockCurrTime},
+		{ID: 2, SnippetID: 2, ModeratorID: 1, ModeratorName: "John", Action: ModerationHide, Created: mockCurrTime},
+		{ID: 1, SnippetID: 1, ModeratorID: 1, ModeratorName: "John", Action: ModerationDismiss, Created: mockCurrTime},
+	}
+	assert.Equal(t, wantActions, actions)
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// ErrUnsupportedHash is returned when verifying a password against a hash made by
+// an unknown algorithm.
+var ErrUnsupportedHash = errors.New("store: unsupported password hash")
+
+// PasswordHasher hashes the passwords of users with an algorithm and its
+// parameters. Hashes are encoded in the PHC string format, e.g.
+// "$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>", or the modular crypt format
+// PHC strings are based on for bcrypt, so that they say how they were made.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of password, with a random salt.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash, which can have been made by
+	// any supported algorithm. rehash is true when the password matched but hash
+	// wasn't made with the algorithm and parameters of the hasher, so that it
+	// should be replaced.
+	Verify(password, hash string) (match, rehash bool, err error)
+}
+
+// BcryptHasher hashes passwords with bcrypt.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(password, hash string) (bool, bool, error) {
+	match, err := verifyPassword(password, hash)
+	if !match || err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || cost != h.Cost, nil
+}
+
+// Argon2idHasher hashes passwords with Argon2id, as described in RFC 9106.
+type Argon2idHasher struct {
+	// Memory is the memory used by a hash, in KiB.
+	Memory uint32
+	// Iterations is the number of passes over the memory.
+	Iterations uint32
+	// Parallelism is the number of threads used.
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	params := argon2Params{memory: h.Memory, iterations: h.Iterations, parallelism: h.Parallelism, salt: salt}
+	params.key = argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return params.encode(), nil
+}
+
+func (h *Argon2idHasher) Verify(password, hash string) (bool, bool, error) {
+	match, err := verifyPassword(password, hash)
+	if !match || err != nil {
+		return false, false, err
+	}
+
+	params, err := decodeArgon2id(hash)
+	current := err == nil &&
+		params.memory == h.Memory && params.iterations == h.Iterations && params.parallelism == h.Parallelism &&
+		len(params.salt) == argon2SaltLength && len(params.key) == argon2KeyLength
+	return true, !current, nil
+}
+
+// verifyPassword checks a password against a hash made by any of the supported
+// algorithms, which is found from the prefix of the hash.
+func verifyPassword(password, hash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		key := argon2.IDKey([]byte(password), params.salt, params.iterations, params.memory, params.parallelism, uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+
+	default:
+		return false, ErrUnsupportedHash
+	}
+}
+
+// argon2Params are the parameters, salt and key of an Argon2id hash.
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (p argon2Params) encode() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.iterations, p.parallelism,
+		base64.RawStdEncoding.EncodeToString(p.salt), base64.RawStdEncoding.EncodeToString(p.key))
+}
+
+func decodeArgon2id(hash string) (argon2Params, error) {
+	var p argon2Params
+
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return p, fmt.Errorf("%w: malformed argon2id hash", ErrUnsupportedHash)
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, fmt.Errorf("%w: argon2id version %q", ErrUnsupportedHash, fields[2])
+	}
+	_, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil || p.iterations == 0 || p.parallelism == 0 {
+		return p, fmt.Errorf("%w: argon2id parameters %q", ErrUnsupportedHash, fields[3])
+	}
+
+	if p.salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil {
+		return p, fmt.Errorf("%w: argon2id salt: %v", ErrUnsupportedHash, err)
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(fields[5]); err != nil || len(p.key) == 0 {
+		return p, fmt.Errorf("%w: argon2id key", ErrUnsupportedHash)
+	}
+	return p, nil
+}
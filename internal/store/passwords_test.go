@@ -0,0 +1,71 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"testing"
+)
+
+// testArgon2idHasher keeps hashing passwords in tests fast.
+var testArgon2idHasher = &Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+
+// phcRX matches the Argon2id hashes in the PHC string format.
+var phcRX = regexp.MustCompile(`^\$argon2id\$v=19\$m=64,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`)
+
+func TestArgon2idHasher_Hash(t *testing.T) {
+	hash, err := testArgon2idHasher.Hash("Hello, World!")
+	require.NoError(t, err)
+	assert.Regexp(t, phcRX, hash)
+
+	other, err := testArgon2idHasher.Hash("Hello, World!")
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, other, "hashes are salted")
+}
+
+func TestPasswordHasher_Verify(t *testing.T) {
+	// The hash of John's password in testdata/fixtures.sql.
+	const bcryptHash = "$2a$04$iQ07aWdTTLrEcem61mMEeuguBE994i.4qA5F90EhsPi9UQWzTBnyO"
+	argon2idHash, err := testArgon2idHasher.Hash("Hello, World!")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		hasher     PasswordHasher
+		password   string
+		hash       string
+		wantMatch  bool
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "Current bcrypt hash", hasher: testHasher, password: "Hello, World!", hash: bcryptHash, wantMatch: true},
+		{name: "Wrong password", hasher: testHasher, password: "Bye, World!", hash: bcryptHash},
+		{name: "Other bcrypt cost", hasher: &BcryptHasher{Cost: 5}, password: "Hello, World!", hash: bcryptHash, wantMatch: true, wantRehash: true},
+		{name: "bcrypt hash with Argon2id preferred", hasher: testArgon2idHasher, password: "Hello, World!", hash: bcryptHash, wantMatch: true, wantRehash: true},
+		{name: "Current Argon2id hash", hasher: testArgon2idHasher, password: "Hello, World!", hash: argon2idHash, wantMatch: true},
+		{name: "Wrong Argon2id password", hasher: testArgon2idHasher, password: "Bye, World!", hash: argon2idHash},
+		{
+			name:       "Other Argon2id parameters",
+			hasher:     &Argon2idHasher{Memory: 128, Iterations: 1, Parallelism: 1},
+			password:   "Hello, World!",
+			hash:       argon2idHash,
+			wantMatch:  true,
+			wantRehash: true,
+		},
+		{name: "Argon2id hash with bcrypt preferred", hasher: testHasher, password: "Hello, World!", hash: argon2idHash, wantMatch: true, wantRehash: true},
+		{name: "Unknown algorithm", hasher: testArgon2idHasher, password: "Hello, World!", hash: "$scrypt$ln=16,r=8,p=1$aM15713r3Xsvxbi31lqr1Q$nFNh2CVHVjNldFVKDHDlm4CbdRSCdEBsjjJxD+iCs5E", wantErr: ErrUnsupportedHash},
+		{name: "Malformed Argon2id hash", hasher: testArgon2idHasher, password: "Hello, World!", hash: "$argon2id$v=19$m=64,t=0,p=1$c2FsdA$a2V5", wantErr: ErrUnsupportedHash},
+		{name: "Other Argon2 version", hasher: testArgon2idHasher, password: "Hello, World!", hash: "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", wantErr: ErrUnsupportedHash},
+		{name: "Truncated bcrypt hash", hasher: testHasher, password: "Hello, World!", hash: bcryptHash[:30], wantErr: bcrypt.ErrHashTooShort},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			match, rehash, err := tc.hasher.Verify(tc.password, tc.hash)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantMatch, match)
+			assert.Equal(t, tc.wantRehash, rehash)
+		})
+	}
+}
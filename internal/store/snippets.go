@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/96malhar/snippetbox/internal/datetime"
@@ -16,31 +17,43 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	// Hidden snippets have been taken down by a moderator.
+	Hidden bool
+	// TeamID is the team whose members alone can see and edit the snippet, or
+	// 0 for public snippets.
+	TeamID int
 }
 
 // SnippetStore is a type which wraps a sql.DB connection pool.
 type SnippetStore struct {
 	db              *sql.DB
+	queryTimeout    time.Duration
 	datetimeHandler interface {
 		GetCurrentTimeUTC() time.Time
 	}
 }
 
-func NewSnippetStore(db *sql.DB) *SnippetStore {
-	return &SnippetStore{db: db, datetimeHandler: &datetime.Handler{}}
+// NewSnippetStore returns a SnippetStore whose calls give up after queryTimeout,
+// or never if it is 0.
+func NewSnippetStore(db *sql.DB, queryTimeout time.Duration) *SnippetStore {
+	return &SnippetStore{db: db, queryTimeout: queryTimeout, datetimeHandler: &datetime.Handler{}}
 }
 
 // Insert will add a new snippet into the database and return the snippet ID.
-func (s *SnippetStore) Insert(title string, content string, expirationDays int) (int, error) {
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-    VALUES($1, $2, $3, $4)
+// A teamID of 0 creates a public snippet.
+func (s *SnippetStore) Insert(ctx context.Context, title string, content string, expirationDays int, teamID int) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `INSERT INTO snippets (title, content, created, expires, team_id)
+    VALUES($1, $2, $3, $4, $5)
 	returning id`
 
 	created := s.datetimeHandler.GetCurrentTimeUTC()
 	expires := created.Add(time.Hour * 24 * time.Duration(expirationDays))
 
 	var id int
-	err := s.db.QueryRow(stmt, title, content, created, expires).Scan(&id)
+	err = s.db.QueryRowContext(ctx, stmt, title, content, created, expires, nullID(teamID)).Scan(&id)
 	if err != nil {
 		return -1, err
 	}
@@ -48,13 +61,17 @@ func (s *SnippetStore) Insert(title string, content string, expirationDays int)
 }
 
 // Get will return a specific snippet based on its id.
-func (s *SnippetStore) Get(id int) (*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets
+func (s *SnippetStore) Get(ctx context.Context, id int) (_ *Snippet, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `SELECT id, title, content, created, expires, hidden, team_id FROM snippets
 	WHERE expires > $1 AND id = $2`
 
 	var sn Snippet
+	var teamID sql.NullInt64
 	currTime := s.datetimeHandler.GetCurrentTimeUTC()
-	err := s.db.QueryRow(stmt, currTime, id).Scan(&sn.ID, &sn.Title, &sn.Content, &sn.Created, &sn.Expires)
+	err = s.db.QueryRowContext(ctx, stmt, currTime, id).Scan(&sn.ID, &sn.Title, &sn.Content, &sn.Created, &sn.Expires, &sn.Hidden, &teamID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -62,15 +79,52 @@ func (s *SnippetStore) Get(id int) (*Snippet, error) {
 			return nil, err
 		}
 	}
+	sn.TeamID = int(teamID.Int64)
 	return &sn, nil
 }
 
-// Latest will return the 10 most recently created snippets.
-func (s *SnippetStore) Latest() ([]*Snippet, error) {
+// Latest will return the 10 most recently created public snippets which are
+// neither expired nor hidden.
+func (s *SnippetStore) Latest(ctx context.Context) (_ []*Snippet, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
 	stmt := `SELECT id, title, content, created, expires FROM snippets
-    		WHERE expires > $1 ORDER BY id DESC LIMIT 10`
+    		WHERE expires > $1 AND NOT hidden AND team_id IS NULL ORDER BY id DESC LIMIT 10`
+
+	rows, err := s.db.QueryContext(ctx, stmt, s.datetimeHandler.GetCurrentTimeUTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*Snippet
+	for rows.Next() {
+		var sn Snippet
+		err = rows.Scan(&sn.ID, &sn.Title, &sn.Content, &sn.Created, &sn.Expires)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, &sn)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// List returns up to limit of the most recently created snippets, including
+// expired and hidden ones.
+func (s *SnippetStore) List(ctx context.Context, limit int) (_ []*Snippet, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
 
-	rows, err := s.db.Query(stmt, s.datetimeHandler.GetCurrentTimeUTC())
+	stmt := `SELECT id, title, content, created, expires, hidden, team_id FROM snippets
+    		ORDER BY id DESC LIMIT $1`
+
+	rows, err := s.db.QueryContext(ctx, stmt, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -79,6 +133,40 @@ func (s *SnippetStore) Latest() ([]*Snippet, error) {
 	var snippets []*Snippet
 	for rows.Next() {
 		var sn Snippet
+		var teamID sql.NullInt64
+		err = rows.Scan(&sn.ID, &sn.Title, &sn.Content, &sn.Created, &sn.Expires, &sn.Hidden, &teamID)
+		if err != nil {
+			return nil, err
+		}
+		sn.TeamID = int(teamID.Int64)
+		snippets = append(snippets, &sn)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snippets, nil
+}
+
+// ListForTeam returns the unexpired snippets of a team which haven't been
+// hidden, newest first.
+func (s *SnippetStore) ListForTeam(ctx context.Context, teamID int) (_ []*Snippet, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `SELECT id, title, content, created, expires FROM snippets
+    		WHERE expires > $1 AND NOT hidden AND team_id = $2 ORDER BY id DESC`
+
+	rows, err := s.db.QueryContext(ctx, stmt, s.datetimeHandler.GetCurrentTimeUTC(), teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snippets []*Snippet
+	for rows.Next() {
+		sn := Snippet{TeamID: teamID}
 		err = rows.Scan(&sn.ID, &sn.Title, &sn.Content, &sn.Created, &sn.Expires)
 		if err != nil {
 			return nil, err
@@ -92,3 +180,54 @@ func (s *SnippetStore) Latest() ([]*Snippet, error) {
 
 	return snippets, nil
 }
+
+// Update changes the title and content of an unexpired snippet.
+func (s *SnippetStore) Update(ctx context.Context, id int, title, content string) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `UPDATE snippets SET title = $1, content = $2 WHERE id = $3 AND expires > $4`
+
+	result, err := s.db.ExecContext(ctx, stmt, title, content, id, s.datetimeHandler.GetCurrentTimeUTC())
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Count returns the total number of snippets and the number of unexpired ones.
+func (s *SnippetStore) Count(ctx context.Context) (total int, active int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `SELECT COUNT(*), COUNT(*) FILTER (WHERE expires > $1) FROM snippets`
+
+	err = s.db.QueryRowContext(ctx, stmt, s.datetimeHandler.GetCurrentTimeUTC()).Scan(&total, &active)
+	return total, active, err
+}
+
+// Expire makes a snippet expire immediately.
+func (s *SnippetStore) Expire(ctx context.Context, id int) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `UPDATE snippets SET expires = $1 WHERE id = $2`
+
+	result, err := s.db.ExecContext(ctx, stmt, s.datetimeHandler.GetCurrentTimeUTC(), id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// Delete removes a snippet.
+func (s *SnippetStore) Delete(ctx context.Context, id int) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM snippets WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"context"
 	"github.com/96malhar/snippetbox/internal/datetime/mocks"
 	"github.com/96malhar/snippetbox/internal/testutils"
 	"github.com/stretchr/testify/assert"
@@ -11,6 +12,7 @@ import (
 
 func TestSnippetStore_Get(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	tests := []struct {
 		name            string
 		id              int
@@ -71,12 +73,12 @@ func TestSnippetStore_Get(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := NewSnippetStore(db)
+			s := NewSnippetStore(db, 0)
 			if tt.mockCurrentTime != "" {
 				s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, tt.mockCurrentTime))
 			}
 
-			gotSnippet, err := s.Get(tt.id)
+			gotSnippet, err := s.Get(ctx, tt.id)
 
 			assert.ErrorIs(t, err, tt.wantErr)
 			assert.Equal(t, tt.wantSnippet, gotSnippet)
@@ -86,6 +88,7 @@ func TestSnippetStore_Get(t *testing.T) {
 
 func TestSnippetStore_Latest(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	testcases := []struct {
 		name            string
 		mockCurrentTime string
@@ -126,12 +129,12 @@ func TestSnippetStore_Latest(t *testing.T) {
 				dropDB(t, testDbName)
 			})
 
-			s := NewSnippetStore(db)
+			s := NewSnippetStore(db, 0)
 			if tt.mockCurrentTime != "" {
 				s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, tt.mockCurrentTime))
 			}
 
-			gotSnippets, err := s.Latest()
+			gotSnippets, err := s.Latest(ctx)
 
 			require.NoError(t, err)
 			assert.Equal(t, len(tt.wantSnippets), len(gotSnippets))
@@ -144,6 +147,7 @@ func TestSnippetStore_Latest(t *testing.T) {
 
 func TestSnippetStore_Insert(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	db, testDbName := newTestDB(t)
 	setupDB(t, db)
 	t.Cleanup(func() {
@@ -151,11 +155,11 @@ func TestSnippetStore_Insert(t *testing.T) {
 		dropDB(t, testDbName)
 	})
 
-	s := NewSnippetStore(db)
+	s := NewSnippetStore(db, 0)
 	mockCurrTime := time.Date(2022, 1, 1, 10, 0, 0, 0, time.UTC)
 	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
 
-	id, err := s.Insert("Snippet 3 Title", "Snippet 3 content.", 10)
+	id, err := s.Insert(ctx, "Snippet 3 Title", "Snippet 3 content.", 10, 0)
 
 	require.NoError(t, err)
 	assert.Equal(t, 3, id)
@@ -167,6 +171,95 @@ func TestSnippetStore_Insert(t *testing.T) {
 		Created: mockCurrTime,
 		Expires: mockCurrTime.Add(time.Hour * 24 * 10),
 	}
-	gotSnippet, _ := s.Get(3)
+	gotSnippet, _ := s.Get(ctx, 3)
 	assert.Equal(t, wantSnippet, gotSnippet)
 }
+
+func TestSnippetStore_List(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewSnippetStore(db, 0)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, "2024-12-01T10:00:00Z"))
+
+	// Expired snippets are listed as well
+	gotSnippets, err := s.List(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, gotSnippets, 2)
+	assert.Equal(t, 2, gotSnippets[0].ID)
+	assert.Equal(t, 1, gotSnippets[1].ID)
+
+	gotSnippets, err = s.List(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, gotSnippets, 1)
+}
+
+func TestSnippetStore_Count(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewSnippetStore(db, 0)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, "2023-01-15T10:00:00Z"))
+
+	total, active, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, 1, active)
+}
+
+func TestSnippetStore_ExpireAndDelete(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewSnippetStore(db, 0)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, "2022-12-01T10:00:00Z"))
+
+	require.NoError(t, s.Expire(ctx, 1))
+	_, err := s.Get(ctx, 1)
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	require.NoError(t, s.Delete(ctx, 2))
+	_, err = s.Get(ctx, 2)
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	assert.ErrorIs(t, s.Expire(ctx, 3), ErrNoRecord)
+	assert.ErrorIs(t, s.Delete(ctx, 3), ErrNoRecord)
+}
+
+func TestSnippetStore_QueryTimeout(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewSnippetStore(db, time.Nanosecond)
+	_, err := s.Get(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The timeout applies to each call rather than the whole store.
+	s.queryTimeout = time.Minute
+	_, err = s.Get(ctx, 1)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,88 @@
+// Package store keeps the data of the application in a database, either Postgres
+// or SQLite, see ParseDSN.
+package store
+
+import "context"
+
+// The interfaces below are what the application needs from its stores. The
+// stores of this package implement them on every Backend, the mocks package
+// implements them in memory for the handler tests.
+
+type Snippets interface {
+	Insert(ctx context.Context, title string, content string, expirationDays int, teamID int) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	Latest(ctx context.Context) ([]*Snippet, error)
+	List(ctx context.Context, limit int) ([]*Snippet, error)
+	ListForTeam(ctx context.Context, teamID int) ([]*Snippet, error)
+	Update(ctx context.Context, id int, title, content string) error
+	Count(ctx context.Context) (total int, active int, err error)
+	Expire(ctx context.Context, id int) error
+	Delete(ctx context.Context, id int) error
+}
+
+type Users interface {
+	Insert(ctx context.Context, name, email, password string) (int, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	ProvisionIdentity(ctx context.Context, issuer, subject, name, email string) (int, error)
+	Get(ctx context.Context, id int) (*User, error)
+	Search(ctx context.Context, query string, limit int) ([]*User, error)
+	Count(ctx context.Context) (int, error)
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	SetRole(ctx context.Context, id int, role Role) error
+	EnableTOTP(ctx context.Context, id int, secret string, recoveryCodes []string) error
+	DisableTOTP(ctx context.Context, id int) error
+	UseRecoveryCode(ctx context.Context, id int, code string) (bool, error)
+	UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error)
+	UpdatePassword(ctx context.Context, id int, password string) error
+	CreatePasswordReset(ctx context.Context, id int) (string, error)
+	PasswordResetUser(ctx context.Context, token string) (*User, error)
+	ResetPassword(ctx context.Context, token, password string) (int, error)
+}
+
+type UserSessions interface {
+	Insert(userID int, token, userAgent, ip string) error
+	Touch(token string) error
+	Get(id int) (*UserSession, error)
+	GetAllForUser(userID int) ([]*UserSession, error)
+	Delete(token string) error
+}
+
+type Teams interface {
+	Create(name string, ownerID int) (*Team, error)
+	Get(id int) (*Team, error)
+	GetBySlug(slug string) (*Team, error)
+	ForUser(userID int) ([]*Team, error)
+	Members(teamID int) ([]*TeamMember, error)
+	Role(teamID, userID int) (TeamRole, error)
+	Invite(teamID int, email string, invitedBy int) (string, error)
+	PendingInvites(teamID int) ([]*TeamInvite, error)
+	InvitesForUser(userID int) ([]*TeamInvite, error)
+	AcceptInvite(token string, userID int) (*Team, error)
+	AcceptEmailInvite(inviteID, userID int) (*Team, error)
+	RemoveMember(teamID, userID int) error
+	SetRole(teamID, userID int, role TeamRole) error
+}
+
+type Moderation interface {
+	Report(snippetID int, reason, details string) error
+	OpenReports() ([]*Report, error)
+	Dismiss(snippetID, moderatorID int) error
+	Hide(snippetID, moderatorID int) error
+	Delete(snippetID, moderatorID int) error
+	Actions(limit int) ([]*ModerationAction, error)
+}
+
+type Health interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (int, bool, error)
+	SessionCount(ctx context.Context) (int, error)
+}
+
+var (
+	_ Snippets     = (*SnippetStore)(nil)
+	_ Users        = (*UserStore)(nil)
+	_ UserSessions = (*UserSessionStore)(nil)
+	_ Teams        = (*TeamStore)(nil)
+	_ Moderation   = (*ModerationStore)(nil)
+	_ Health       = (*HealthStore)(nil)
+)
@@ -0,0 +1,359 @@
+// Package storetest checks that implementations of the store interfaces behave
+// alike. It runs against the stores of every backend and against the mocks the
+// handler tests use, so that the handler tests can trust the mocks.
+package storetest
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// Clock is where the stores under test read the current time from.
+type Clock interface {
+	GetCurrentTimeUTC() time.Time
+}
+
+// SnippetsFactory returns an empty snippet store which reads the current time
+// from clock. Every call must return a store of its own.
+type SnippetsFactory func(t *testing.T, clock Clock) store.Snippets
+
+// UsersFactory returns an empty user store which reads the current time from
+// clock. Every call must return a store of its own.
+type UsersFactory func(t *testing.T, clock Clock) store.Users
+
+// startTime is the time the clocks of the tests start at. Postgres keeps whole
+// seconds, so the clocks are only ever moved by whole seconds.
+var startTime = time.Date(2024, time.March, 1, 10, 0, 0, 0, time.UTC)
+
+// missingID is an ID no test creates a record with.
+const missingID = 1_000_000
+
+func newClock() *mocks.MockDateTimeHandler {
+	return mocks.NewMockDateTimeHandler(startTime)
+}
+
+// assertTime asserts that got is the same instant as want, whatever its
+// location.
+func assertTime(t *testing.T, want, got time.Time) {
+	t.Helper()
+	assert.Truef(t, want.Equal(got), "got time %s; want %s", got, want)
+}
+
+// snippetIDs returns the IDs of snippets in order.
+func snippetIDs(snippets []*store.Snippet) []int {
+	ids := make([]int, 0, len(snippets))
+	for _, sn := range snippets {
+		ids = append(ids, sn.ID)
+	}
+	return ids
+}
+
+// TestSnippets checks that the snippet stores of newStore behave like the
+// snippet store of the Postgres backend.
+func TestSnippets(t *testing.T, newStore SnippetsFactory) {
+	ctx := context.Background()
+
+	insert := func(t *testing.T, s store.Snippets, title string, expirationDays int) int {
+		t.Helper()
+		id, err := s.Insert(ctx, title, "Content of "+title, expirationDays, 0)
+		require.NoError(t, err)
+		return id
+	}
+
+	t.Run("Insert and Get", func(t *testing.T) {
+		clock := newClock()
+		s := newStore(t, clock)
+
+		id := insert(t, s, "Title", 7)
+
+		sn, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, id, sn.ID)
+		assert.Equal(t, "Title", sn.Title)
+		assert.Equal(t, "Content of Title", sn.Content)
+		assertTime(t, startTime, sn.Created)
+		assertTime(t, startTime.AddDate(0, 0, 7), sn.Expires)
+		assert.False(t, sn.Hidden)
+		assert.Zero(t, sn.TeamID)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		_, err := s.Get(ctx, missingID)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		assert.ErrorIs(t, s.Update(ctx, missingID, "Title", "Content"), store.ErrNoRecord)
+		assert.ErrorIs(t, s.Expire(ctx, missingID), store.ErrNoRecord)
+		assert.ErrorIs(t, s.Delete(ctx, missingID), store.ErrNoRecord)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		clock := newClock()
+		s := newStore(t, clock)
+
+		expiring := insert(t, s, "Expiring", 1)
+		lasting := insert(t, s, "Lasting", 3)
+		clock.MockCurrentTime = startTime.AddDate(0, 0, 2)
+
+		_, err := s.Get(ctx, expiring)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		assert.ErrorIs(t, s.Update(ctx, expiring, "Title", "Content"), store.ErrNoRecord)
+		_, err = s.Get(ctx, lasting)
+		assert.NoError(t, err)
+
+		latest, err := s.Latest(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []int{lasting}, snippetIDs(latest))
+
+		// Unlike Latest, List is for admins and includes expired snippets.
+		all, err := s.List(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []int{lasting, expiring}, snippetIDs(all))
+
+		total, active, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, total)
+		assert.Equal(t, 1, active)
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Title", 7)
+		require.NoError(t, s.Expire(ctx, id))
+
+		_, err := s.Get(ctx, id)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		// Expiring a snippet again is harmless.
+		assert.NoError(t, s.Expire(ctx, id))
+
+		total, active, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, total)
+		assert.Equal(t, 0, active)
+	})
+
+	t.Run("Latest ordering and limit", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		var ids []int
+		for i := 0; i < 12; i++ {
+			ids = append(ids, insert(t, s, "Title", 7))
+		}
+
+		latest, err := s.Latest(ctx)
+		require.NoError(t, err)
+		var want []int
+		for i := len(ids) - 1; i >= 2; i-- {
+			want = append(want, ids[i])
+		}
+		assert.Equal(t, want, snippetIDs(latest))
+	})
+
+	t.Run("List ordering and limit", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		first := insert(t, s, "First", 7)
+		second := insert(t, s, "Second", 7)
+		third := insert(t, s, "Third", 7)
+
+		snippets, err := s.List(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []int{third, second}, snippetIDs(snippets))
+
+		snippets, err = s.List(ctx, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []int{third, second, first}, snippetIDs(snippets))
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Title", 7)
+		require.NoError(t, s.Update(ctx, id, "New title", "New content"))
+
+		sn, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, "New title", sn.Title)
+		assert.Equal(t, "New content", sn.Content)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Title", 7)
+		require.NoError(t, s.Delete(ctx, id))
+
+		_, err := s.Get(ctx, id)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		assert.ErrorIs(t, s.Delete(ctx, id), store.ErrNoRecord)
+
+		total, _, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, total)
+	})
+}
+
+// TestUsers checks that the user stores of newStore behave like the user store
+// of the Postgres backend.
+func TestUsers(t *testing.T, newStore UsersFactory) {
+	ctx := context.Background()
+
+	insert := func(t *testing.T, s store.Users, name, email string) int {
+		t.Helper()
+		id, err := s.Insert(ctx, name, email, "pa$$word")
+		require.NoError(t, err)
+		authenticated, err := s.Authenticate(ctx, email, "pa$$word")
+		require.NoError(t, err)
+		require.Equal(t, id, authenticated)
+		return id
+	}
+
+	t.Run("Insert and Get", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Alice", "alice@example.com")
+
+		user, err := s.Get(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, id, user.ID)
+		assert.Equal(t, "Alice", user.Name)
+		assert.Equal(t, "alice@example.com", user.Email)
+		assertTime(t, startTime, user.Created)
+		assert.Equal(t, store.RoleUser, user.Role)
+		assert.False(t, user.Disabled)
+		assert.False(t, user.TOTPEnabled)
+	})
+
+	t.Run("Duplicate email", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		insert(t, s, "Alice", "alice@example.com")
+		_, err := s.Insert(ctx, "Another Alice", "alice@example.com", "pa$$word")
+		assert.ErrorIs(t, err, store.ErrDuplicateEmail)
+
+		n, err := s.Count(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("Not found", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		_, err := s.Get(ctx, missingID)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		assert.ErrorIs(t, s.SetDisabled(ctx, missingID, true), store.ErrNoRecord)
+		assert.ErrorIs(t, s.SetRole(ctx, missingID, store.RoleAdmin), store.ErrNoRecord)
+		assert.ErrorIs(t, s.UpdatePassword(ctx, missingID, "pa$$word"), store.ErrNoRecord)
+		_, err = s.CreatePasswordReset(ctx, missingID)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		_, err = s.PasswordResetUser(ctx, "missing-token")
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+	})
+
+	t.Run("Invalid credentials", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		insert(t, s, "Alice", "alice@example.com")
+		_, err := s.Authenticate(ctx, "alice@example.com", "wrong-password")
+		assert.ErrorIs(t, err, store.ErrInvalidCredentials)
+		_, err = s.Authenticate(ctx, "bob@example.com", "pa$$word")
+		assert.ErrorIs(t, err, store.ErrInvalidCredentials)
+	})
+
+	t.Run("TOTP counter", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Alice", "alice@example.com")
+		steps := []struct {
+			counter int64
+			want    bool
+		}{
+			{counter: 100, want: true},
+			{counter: 100, want: false},
+			{counter: 99, want: false},
+			{counter: 101, want: true},
+		}
+		for _, step := range steps {
+			ok, err := s.UseTOTPCounter(ctx, id, step.counter)
+			require.NoError(t, err)
+			assert.Equalf(t, step.want, ok, "UseTOTPCounter(%d)", step.counter)
+		}
+
+		ok, err := s.UseTOTPCounter(ctx, missingID, 100)
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Search ordering and limit", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		alice := insert(t, s, "Alice", "alice@example.com")
+		bob := insert(t, s, "Bob", "bob@example.com")
+		alfred := insert(t, s, "Alfred", "alfred@example.com")
+
+		searches := []struct {
+			query string
+			limit int
+			want  []int
+		}{
+			{query: "al", limit: 10, want: []int{alice, alfred}},
+			{query: "AL", limit: 10, want: []int{alice, alfred}},
+			{query: "al", limit: 1, want: []int{alice}},
+			{query: "bob@", limit: 10, want: []int{bob}},
+			{query: "", limit: 2, want: []int{alice, bob}},
+			{query: "carol", limit: 10, want: []int{}},
+		}
+		for _, search := range searches {
+			users, err := s.Search(ctx, search.query, search.limit)
+			require.NoError(t, err)
+			ids := []int{}
+			for _, user := range users {
+				ids = append(ids, user.ID)
+			}
+			assert.Equalf(t, search.want, ids, "Search(%q, %d)", search.query, search.limit)
+		}
+	})
+
+	t.Run("Password reset", func(t *testing.T) {
+		s := newStore(t, newClock())
+
+		id := insert(t, s, "Alice", "alice@example.com")
+		token, err := s.CreatePasswordReset(ctx, id)
+		require.NoError(t, err)
+
+		user, err := s.PasswordResetUser(ctx, token)
+		require.NoError(t, err)
+		assert.Equal(t, id, user.ID)
+
+		resetID, err := s.ResetPassword(ctx, token, "new-pa$$word")
+		require.NoError(t, err)
+		assert.Equal(t, id, resetID)
+		_, err = s.Authenticate(ctx, "alice@example.com", "new-pa$$word")
+		assert.NoError(t, err)
+
+		// A link can only be used once.
+		_, err = s.ResetPassword(ctx, token, "another-pa$$word")
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+	})
+
+	t.Run("Password reset expiry", func(t *testing.T) {
+		clock := newClock()
+		s := newStore(t, clock)
+
+		id := insert(t, s, "Alice", "alice@example.com")
+		token, err := s.CreatePasswordReset(ctx, id)
+		require.NoError(t, err)
+
+		clock.MockCurrentTime = startTime.Add(25 * time.Hour)
+		_, err = s.PasswordResetUser(ctx, token)
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+		_, err = s.ResetPassword(ctx, token, "new-pa$$word")
+		assert.ErrorIs(t, err, store.ErrNoRecord)
+	})
+}
@@ -0,0 +1,441 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TeamRole determines what a member can do in a team. Every member can see and
+// edit the snippets of the team, owners can also invite, remove and change the
+// role of members.
+type TeamRole string
+
+const (
+	TeamRoleOwner  TeamRole = "owner"
+	TeamRoleMember TeamRole = "member"
+)
+
+// Valid returns true if r is one of the known team roles.
+func (r TeamRole) Valid() bool {
+	return r == TeamRoleOwner || r == TeamRoleMember
+}
+
+// teamInviteLifetime is how long an invite can be accepted for.
+const teamInviteLifetime = 7 * 24 * time.Hour
+
+type Team struct {
+	ID      int
+	Name    string
+	Slug    string
+	Created time.Time
+}
+
+type TeamMember struct {
+	UserID int
+	Name   string
+	Email  string
+	Role   TeamRole
+	Joined time.Time
+}
+
+// TeamInvite is an outstanding invitation to join a team. Invites with an empty
+// Email can be accepted by anyone with the link, the others only by the user
+// with that email address.
+type TeamInvite struct {
+	ID       int
+	TeamID   int
+	TeamName string
+	Email    string
+	Created  time.Time
+	Expires  time.Time
+}
+
+// TeamSlug derives the slug used in the URLs of a team from its name. It returns
+// an empty string for names without any letters or digits.
+func TeamSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+type TeamStore struct {
+	db              *sql.DB
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+}
+
+func NewTeamStore(db *sql.DB) *TeamStore {
+	return &TeamStore{db: db, datetimeHandler: &datetime.Handler{}}
+}
+
+// Create adds a new team with the given user as its owner. It returns
+// ErrDuplicateTeam if the slug of the name is already taken.
+func (s *TeamStore) Create(name string, ownerID int) (*Team, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	team := Team{Name: name, Slug: TeamSlug(name), Created: s.datetimeHandler.GetCurrentTimeUTC()}
+
+	stmt := `INSERT INTO teams (name, slug, created) VALUES($1, $2, $3) RETURNING id`
+	err = tx.QueryRow(stmt, team.Name, team.Slug, team.Created).Scan(&team.ID)
+	if err != nil {
+		if isUniqueViolation(err, "teams", "slug") {
+			return nil, ErrDuplicateTeam
+		}
+		return nil, err
+	}
+
+	stmt = `INSERT INTO team_members (team_id, user_id, role, joined) VALUES($1, $2, $3, $4)`
+	_, err = tx.Exec(stmt, team.ID, ownerID, TeamRoleOwner, team.Created)
+	if err != nil {
+		return nil, err
+	}
+
+	return &team, tx.Commit()
+}
+
+// Get returns the team with the given ID.
+func (s *TeamStore) Get(id int) (*Team, error) {
+	return s.get(`SELECT id, name, slug, created FROM teams WHERE id = $1`, id)
+}
+
+// GetBySlug returns the team with the given slug.
+func (s *TeamStore) GetBySlug(slug string) (*Team, error) {
+	return s.get(`SELECT id, name, slug, created FROM teams WHERE slug = $1`, slug)
+}
+
+func (s *TeamStore) get(stmt string, arg any) (*Team, error) {
+	var t Team
+	err := s.db.QueryRow(stmt, arg).Scan(&t.ID, &t.Name, &t.Slug, &t.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// ForUser returns the teams the given user is a member of, ordered by name.
+func (s *TeamStore) ForUser(userID int) ([]*Team, error) {
+	stmt := `SELECT t.id, t.name, t.slug, t.created FROM teams t
+	INNER JOIN team_members m ON m.team_id = t.id
+	WHERE m.user_id = $1 ORDER BY t.name, t.id`
+
+	rows, err := s.db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []*Team
+	for rows.Next() {
+		var t Team
+		err = rows.Scan(&t.ID, &t.Name, &t.Slug, &t.Created)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, &t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return teams, nil
+}
+
+// Members returns the members of a team, owners first.
+func (s *TeamStore) Members(teamID int) ([]*TeamMember, error) {
+	stmt := `SELECT u.id, u.name, u.email, m.role, m.joined FROM team_members m
+	INNER JOIN users u ON u.id = m.user_id
+	WHERE m.team_id = $1 ORDER BY m.role = 'owner' DESC, u.name, u.id`
+
+	rows, err := s.db.Query(stmt, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*TeamMember
+	for rows.Next() {
+		var m TeamMember
+		err = rows.Scan(&m.UserID, &m.Name, &m.Email, &m.Role, &m.Joined)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// Role returns the role of a user in a team, or ErrNoRecord if the user isn't a
+// member of the team.
+func (s *TeamStore) Role(teamID, userID int) (TeamRole, error) {
+	stmt := `SELECT role FROM team_members WHERE team_id = $1 AND user_id = $2`
+
+	var role TeamRole
+	err := s.db.QueryRow(stmt, teamID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		} else {
+			return "", err
+		}
+	}
+	return role, nil
+}
+
+// Invite creates an invite to a team and returns the token of its link. An empty
+// email creates an invite which anyone with the link can accept.
+func (s *TeamStore) Invite(teamID int, email string, invitedBy int) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	stmt := `INSERT INTO team_invites (team_id, email, token_hash, invited_by, created, expires)
+	VALUES($1, $2, $3, $4, $5, $6)`
+
+	now := s.datetimeHandler.GetCurrentTimeUTC()
+	_, err = s.db.Exec(stmt, teamID, email, hashInviteToken(token), invitedBy, now, now.Add(teamInviteLifetime))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PendingInvites returns the unexpired invites to a team, newest first.
+func (s *TeamStore) PendingInvites(teamID int) ([]*TeamInvite, error) {
+	stmt := `SELECT i.id, i.team_id, t.name, i.email, i.created, i.expires FROM team_invites i
+	INNER JOIN teams t ON t.id = i.team_id
+	WHERE i.team_id = $1 AND i.expires > $2 ORDER BY i.id DESC`
+
+	return s.invites(stmt, teamID, s.datetimeHandler.GetCurrentTimeUTC())
+}
+
+// InvitesForUser returns the unexpired invites addressed to the email of the
+// given user, to teams they haven't joined yet.
+func (s *TeamStore) InvitesForUser(userID int) ([]*TeamInvite, error) {
+	stmt := `SELECT i.id, i.team_id, t.name, i.email, i.created, i.expires FROM team_invites i
+	INNER JOIN teams t ON t.id = i.team_id
+	INNER JOIN users u ON lower(u.email) = lower(i.email)
+	WHERE u.id = $1 AND i.expires > $2
+	AND NOT EXISTS (SELECT true FROM team_members m WHERE m.team_id = i.team_id AND m.user_id = u.id)
+	ORDER BY i.id DESC`
+
+	return s.invites(stmt, userID, s.datetimeHandler.GetCurrentTimeUTC())
+}
+
+func (s *TeamStore) invites(stmt string, args ...any) ([]*TeamInvite, error) {
+	rows, err := s.db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []*TeamInvite
+	for rows.Next() {
+		var i TeamInvite
+		err = rows.Scan(&i.ID, &i.TeamID, &i.TeamName, &i.Email, &i.Created, &i.Expires)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, &i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invites, nil
+}
+
+// AcceptInvite adds the user to the team of the invite link with the given
+// token and uses the invite up. It returns ErrNoRecord if there is no such
+// unexpired invite or it is addressed to someone else.
+func (s *TeamStore) AcceptInvite(token string, userID int) (*Team, error) {
+	return s.accept(`i.token_hash = $1`, hashInviteToken(token), userID)
+}
+
+// AcceptEmailInvite works like AcceptInvite for an invite addressed to the email
+// of the user, which they accept from their list of invites.
+func (s *TeamStore) AcceptEmailInvite(inviteID, userID int) (*Team, error) {
+	return s.accept(`i.id = $1 AND i.email <> ''`, inviteID, userID)
+}
+
+func (s *TeamStore) accept(condition string, arg any, userID int) (*Team, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stmt := `SELECT i.id, t.id, t.name, t.slug, t.created FROM team_invites i
+	INNER JOIN teams t ON t.id = i.team_id
+	INNER JOIN users u ON u.id = $2
+	WHERE ` + condition + ` AND i.expires > $3 AND (i.email = '' OR lower(i.email) = lower(u.email))` +
+		forUpdate(s.db, "i")
+
+	var inviteID int
+	var team Team
+	now := s.datetimeHandler.GetCurrentTimeUTC()
+	err = tx.QueryRow(stmt, arg, userID, now).Scan(&inviteID, &team.ID, &team.Name, &team.Slug, &team.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	stmt = `INSERT INTO team_members (team_id, user_id, role, joined) VALUES($1, $2, $3, $4)
+	ON CONFLICT DO NOTHING`
+	_, err = tx.Exec(stmt, team.ID, userID, TeamRoleMember, now)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM team_invites WHERE id = $1`, inviteID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &team, tx.Commit()
+}
+
+// RemoveMember removes a user from a team, whether they leave it or an owner
+// removes them. It returns ErrLastOwner if the user is the only owner of a team
+// which has other members.
+func (s *TeamStore) RemoveMember(teamID, userID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roles, err := lockMembers(tx, s.db, teamID)
+	if err != nil {
+		return err
+	}
+
+	role, ok := roles[userID]
+	if !ok {
+		return ErrNoRecord
+	}
+	if role == TeamRoleOwner && countOwners(roles) == 1 && len(roles) > 1 {
+		return ErrLastOwner
+	}
+
+	_, err = tx.Exec(`DELETE FROM team_members WHERE team_id = $1 AND user_id = $2`, teamID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetRole changes the role of a member of a team. It returns ErrLastOwner if
+// that would leave the team without an owner.
+func (s *TeamStore) SetRole(teamID, userID int, role TeamRole) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	roles, err := lockMembers(tx, s.db, teamID)
+	if err != nil {
+		return err
+	}
+
+	current, ok := roles[userID]
+	if !ok {
+		return ErrNoRecord
+	}
+	if current == TeamRoleOwner && role != TeamRoleOwner && countOwners(roles) == 1 {
+		return ErrLastOwner
+	}
+
+	_, err = tx.Exec(`UPDATE team_members SET role = $3 WHERE team_id = $1 AND user_id = $2`, teamID, userID, role)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMembers returns the roles of the members of a team by user ID, and locks
+// their memberships until the end of tx so that two owners can't both step down
+// at once.
+func lockMembers(tx *sql.Tx, db *sql.DB, teamID int) (map[int]TeamRole, error) {
+	stmt := `SELECT user_id, role FROM team_members WHERE team_id = $1` + forUpdate(db, "")
+	rows, err := tx.Query(stmt, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[int]TeamRole)
+	for rows.Next() {
+		var id int
+		var role TeamRole
+		err = rows.Scan(&id, &role)
+		if err != nil {
+			return nil, err
+		}
+		roles[id] = role
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func countOwners(roles map[int]TeamRole) int {
+	n := 0
+	for _, role := range roles {
+		if role == TeamRoleOwner {
+			n++
+		}
+	}
+	return n
+}
+
+func hashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
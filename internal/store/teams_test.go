@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestTeamSlug(t *testing.T) {
+	testcases := []struct {
+		name string
+		want string
+	}{
+		{name: "Platform", want: "platform"},
+		{name: "Platform Team", want: "platform-team"},
+		{name: "  R&D / QA  ", want: "r-d-qa"},
+		{name: "Team 42", want: "team-42"},
+		{name: "Équipe", want: "quipe"},
+		{name: "!!!", want: ""},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, TeamSlug(tc.name))
+		})
+	}
+}
+
+func newTestTeamStore(t *testing.T) (*TeamStore, time.Time) {
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	// Add a second user to invite to teams.
+	_, err := db.Exec(`INSERT INTO users (name, email, hashed_password, created)
+	VALUES ('Jane', 'jane@example.com', '$2a$04$iQ07aWdTTLrEcem61mMEeuguBE994i.4qA5F90EhsPi9UQWzTBnyO', '2023-02-01 10:00:00')`)
+	require.NoError(t, err)
+
+	s := NewTeamStore(db)
+	mockCurrTime := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+	return s, mockCurrTime
+}
+
+func TestTeamStore_Create(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	s, mockCurrTime := newTestTeamStore(t)
+
+	team, err := s.Create("Platform Team", 1)
+	require.NoError(t, err)
+	assert.Equal(t, &Team{ID: 1, Name: "Platform Team", Slug: "platform-team", Created: mockCurrTime}, team)
+
+	_, err = s.Create("platform team!", 2)
+	assert.ErrorIs(t, err, ErrDuplicateTeam)
+
+	got, err := s.GetBySlug("platform-team")
+	require.NoError(t, err)
+	assert.Equal(t, team, got)
+
+	got, err = s.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, team, got)
+
+	_, err = s.GetBySlug("unknown")
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	role, err := s.Role(1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, TeamRoleOwner, role)
+
+	_, err = s.Role(1, 2)
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	teams, err := s.ForUser(1)
+	require.NoError(t, err)
+	assert.Equal(t, []*Team{team}, teams)
+
+	teams, err = s.ForUser(2)
+	require.NoError(t, err)
+	assert.Empty(t, teams)
+}
+
+func TestTeamStore_Invites(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	s, mockCurrTime := newTestTeamStore(t)
+
+	team, err := s.Create("Platform", 1)
+	require.NoError(t, err)
+
+	t.Run("Link invite", func(t *testing.T) {
+		token, err := s.Invite(team.ID, "", 1)
+		require.NoError(t, err)
+
+		joined, err := s.AcceptInvite(token, 2)
+		require.NoError(t, err)
+		assert.Equal(t, team, joined)
+
+		role, err := s.Role(team.ID, 2)
+		require.NoError(t, err)
+		assert.Equal(t, TeamRoleMember, role)
+
+		// Invites can only be used once.
+		_, err = s.AcceptInvite(token, 2)
+		assert.ErrorIs(t, err, ErrNoRecord)
+	})
+
+	t.Run("Email invite", func(t *testing.T) {
+		other, err := s.Create("Other", 1)
+		require.NoError(t, err)
+
+		token, err := s.Invite(other.ID, "JANE@example.com", 1)
+		require.NoError(t, err)
+
+		pending, err := s.PendingInvites(other.ID)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Equal(t, "JANE@example.com", pending[0].Email)
+		assert.Equal(t, mockCurrTime.Add(7*24*time.Hour), pending[0].Expires)
+
+		invites, err := s.InvitesForUser(2)
+		require.NoError(t, err)
+		require.Len(t, invites, 1)
+		assert.Equal(t, "Other", invites[0].TeamName)
+
+		invites, err = s.InvitesForUser(1)
+		require.NoError(t, err)
+		assert.Empty(t, invites)
+
+		// The invite is addressed to Jane, so John can't use the link.
+		_, err = s.AcceptInvite(token, 1)
+		assert.ErrorIs(t, err, ErrNoRecord)
+
+		_, err = s.AcceptEmailInvite(invites[0].ID, 2)
+		require.NoError(t, err)
+
+		members, err := s.Members(other.ID)
+		require.NoError(t, err)
+		require.Len(t, members, 2)
+		assert.Equal(t, TeamRoleOwner, members[0].Role)
+		assert.Equal(t, "Jane", members[1].Name)
+	})
+
+	t.Run("Expired invite", func(t *testing.T) {
+		token, err := s.Invite(team.ID, "", 1)
+		require.NoError(t, err)
+
+		s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime.Add(8 * 24 * time.Hour))
+		defer func() { s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime) }()
+
+		_, err = s.AcceptInvite(token, 2)
+		assert.ErrorIs(t, err, ErrNoRecord)
+	})
+}
+
+func TestTeamStore_RemoveMember(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	s, _ := newTestTeamStore(t)
+
+	team, err := s.Create("Platform", 1)
+	require.NoError(t, err)
+	token, err := s.Invite(team.ID, "", 1)
+	require.NoError(t, err)
+	_, err = s.AcceptInvite(token, 2)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.RemoveMember(team.ID, 1), ErrLastOwner)
+
+	require.NoError(t, s.RemoveMember(team.ID, 2))
+	assert.ErrorIs(t, s.RemoveMember(team.ID, 2), ErrNoRecord)
+
+	// The last member can leave, even as the owner.
+	require.NoError(t, s.RemoveMember(team.ID, 1))
+}
+
+func TestTeamStore_SetRole(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	s, _ := newTestTeamStore(t)
+
+	team, err := s.Create("Platform", 1)
+	require.NoError(t, err)
+	token, err := s.Invite(team.ID, "", 1)
+	require.NoError(t, err)
+	_, err = s.AcceptInvite(token, 2)
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, s.SetRole(team.ID, 1, TeamRoleMember), ErrLastOwner)
+	assert.ErrorIs(t, s.SetRole(team.ID, 3, TeamRoleOwner), ErrNoRecord)
+
+	// Once there is another owner, the first one can step down or leave.
+	require.NoError(t, s.SetRole(team.ID, 2, TeamRoleOwner))
+	require.NoError(t, s.SetRole(team.ID, 1, TeamRoleMember))
+	role, err := s.Role(team.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, TeamRoleMember, role)
+
+	assert.ErrorIs(t, s.RemoveMember(team.ID, 2), ErrLastOwner)
+	require.NoError(t, s.RemoveMember(team.ID, 1))
+}
+
+func TestSnippetStore_Teams(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	mockCurrTime := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	teams := NewTeamStore(db)
+	teams.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+	s := NewSnippetStore(db, 0)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	team, err := teams.Create("Platform", 1)
+	require.NoError(t, err)
+
+	id, err := s.Insert(ctx, "Team Snippet", "Team content.", 10, team.ID)
+	require.NoError(t, err)
+
+	sn, err := s.Get(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, team.ID, sn.TeamID)
+
+	latest, err := s.Latest(ctx)
+	require.NoError(t, err)
+	for _, sn := range latest {
+		assert.NotEqual(t, id, sn.ID)
+	}
+
+	require.NoError(t, s.Update(ctx, id, "New Title", "New content."))
+	assert.ErrorIs(t, s.Update(ctx, 10, "New Title", "New content."), ErrNoRecord)
+
+	snippets, err := s.ListForTeam(ctx, team.ID)
+	require.NoError(t, err)
+	require.Len(t, snippets, 1)
+	assert.Equal(t, &Snippet{
+		ID:      id,
+		Title:   "New Title",
+		Content: "New content.",
+		Created: mockCurrTime,
+		Expires: mockCurrTime.Add(10 * 24 * time.Hour),
+		TeamID:  team.ID,
+	}, snippets[0])
+}
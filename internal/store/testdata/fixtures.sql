@@ -0,0 +1,35 @@
+-- The rows the Postgres store tests expect, on top of the schema of the
+-- migrations. The first migration adds a few snippets to start with, which the
+-- tests don't expect.
+TRUNCATE snippets RESTART IDENTITY CASCADE;
+
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('Snippet 1 Title',
+        'Snippet 1 content.',
+        '2022-01-01 10:00:00',
+        '2023-01-01 10:00:00');
+
+INSERT INTO snippets (title, content, created, expires)
+VALUES ('Snippet 2 Title',
+        'Snippet 2 content.',
+        '2022-02-01 10:00:00',
+        '2023-02-01 10:00:00');
+
+INSERT INTO users (name, email, hashed_password, created)
+VALUES ('John',
+        'john@example.com',
+           -- Hello, World! as password
+        '$2a$04$iQ07aWdTTLrEcem61mMEeuguBE994i.4qA5F90EhsPi9UQWzTBnyO',
+        '2023-02-01 10:00:00');
+
+INSERT INTO user_identities (issuer, subject, user_id, created)
+VALUES ('https://sso.example.com', 'john-123', 1, '2023-02-01 10:00:00');
+
+INSERT INTO sessions (token, data, expiry)
+VALUES ('token-1', '', '2023-06-01 10:00:00'),
+       ('token-2', '', '2023-01-01 10:00:00');
+
+INSERT INTO user_sessions (token, user_id, user_agent, ip, created, last_seen)
+VALUES ('token-1', 1, 'Firefox', '10.0.0.1', '2022-12-01 10:00:00', '2022-12-01 10:00:00'),
+       ('token-2', 1, 'Chrome', '10.0.0.2', '2022-11-01 10:00:00', '2022-11-01 10:00:00'),
+       ('token-3', 1, 'Safari', '10.0.0.3', '2022-10-01 10:00:00', '2022-10-01 10:00:00');
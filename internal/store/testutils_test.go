@@ -4,13 +4,18 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+// testHasher keeps hashing passwords in tests fast.
+var testHasher = &BcryptHasher{Cost: bcrypt.MinCost}
+
 func newTestDB(t *testing.T) (*sql.DB, string) {
 	randomSuffix := strings.Split(uuid.New().String(), "-")[0]
 	testDBName := fmt.Sprintf("snippetbox_test_%s", randomSuffix)
@@ -27,19 +32,14 @@ func newTestDB(t *testing.T) (*sql.DB, string) {
 	return db, testDBName
 }
 
+// setupDB migrates a Postgres test database to the latest version, then adds
+// the rows the tests expect.
 func setupDB(t *testing.T, db *sql.DB) {
-	script, err := os.ReadFile("./testdata/setup.sql")
-	if err != nil {
-		t.Fatal(err)
+	if err := MigrateUp(db, Postgres); err != nil {
+		t.Fatalf("Failed to migrate the database. Err = %s", err)
 	}
-	_, err = db.Exec(string(script))
-	if err != nil {
-		t.Fatal(err)
-	}
-}
 
-func cleanupDB(t *testing.T, db *sql.DB) {
-	script, err := os.ReadFile("./testdata/teardown.sql")
+	script, err := os.ReadFile("./testdata/fixtures.sql")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -67,6 +67,25 @@ func getDBConn(t *testing.T, user, password, dbname string) *sql.DB {
 	return db
 }
 
+// newSQLiteTestDB returns an SQLite database in a temporary directory, migrated
+// to the latest version. Unlike the Postgres databases it needs no server, so
+// the tests using it don't have to run as integration tests.
+func newSQLiteTestDB(t *testing.T) *sql.DB {
+	db, backend, err := Open("sqlite://" + filepath.Join(t.TempDir(), "snippetbox.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if backend != SQLite {
+		t.Fatalf("got backend %s; want %s", backend, SQLite)
+	}
+
+	if err = MigrateUp(db, SQLite); err != nil {
+		t.Fatalf("Failed to migrate the database. Err = %s", err)
+	}
+	return db
+}
+
 func parseTime(t *testing.T, layout, value string) time.Time {
 	t.Helper()
 	tm, err := time.Parse(layout, value)
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"time"
+)
+
+// UserSession records a single login of a user. It acts as an index from a
+// user to the scs session tokens issued to them, because scs stores the
+// session data itself as opaque bytes.
+type UserSession struct {
+	ID        int
+	UserID    int
+	Token     string
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+}
+
+// touchInterval is how old the last seen time of a session has to be for Touch
+// to update it, so that not every request of a user writes to the database.
+const touchInterval = time.Minute
+
+// orphanAge is how old a session of the index has to be for DeleteOrphans to
+// delete it once its scs session is gone. scs only saves a new session once the
+// response to the login is written, after the session was added to the index.
+const orphanAge = time.Minute
+
+type UserSessionStore struct {
+	db              *sql.DB
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+}
+
+func NewUserSessionStore(db *sql.DB) *UserSessionStore {
+	return &UserSessionStore{db: db, datetimeHandler: &datetime.Handler{}}
+}
+
+// Insert records a new login of the given user under the given session token.
+func (s *UserSessionStore) Insert(userID int, token, userAgent, ip string) error {
+	stmt := `INSERT INTO user_sessions (token, user_id, user_agent, ip, created, last_seen)
+	VALUES($1, $2, $3, $4, $5, $5)`
+
+	now := s.datetimeHandler.GetCurrentTimeUTC()
+
+	_, err := s.db.Exec(stmt, token, userID, userAgent, ip, now)
+	return err
+}
+
+// Touch updates the last seen time of the session with the given token, unless
+// it was updated less than a minute ago.
+func (s *UserSessionStore) Touch(token string) error {
+	stmt := `UPDATE user_sessions SET last_seen = $1 WHERE token = $2 AND last_seen <= $3`
+
+	now := s.datetimeHandler.GetCurrentTimeUTC()
+	_, err := s.db.Exec(stmt, now, token, now.Add(-touchInterval))
+	return err
+}
+
+// Get returns a specific session based on its id.
+func (s *UserSessionStore) Get(id int) (*UserSession, error) {
+	stmt := `SELECT id, user_id, token, user_agent, ip, created, last_seen FROM user_sessions
+	WHERE id = $1`
+
+	var us UserSession
+	err := s.db.QueryRow(stmt, id).Scan(&us.ID, &us.UserID, &us.Token, &us.UserAgent, &us.IP, &us.Created, &us.LastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return &us, nil
+}
+
+// GetAllForUser returns the unexpired sessions of a user, most recently seen first.
+func (s *UserSessionStore) GetAllForUser(userID int) ([]*UserSession, error) {
+	stmt := `SELECT us.id, us.user_id, us.token, us.user_agent, us.ip, us.created, us.last_seen
+	FROM user_sessions us INNER JOIN sessions s ON s.token = us.token
+	WHERE us.user_id = $1 AND s.expiry > ` + sessionExpiry(s.db, "$2") + ` ORDER BY us.last_seen DESC, us.id DESC`
+
+	rows, err := s.db.Query(stmt, userID, s.datetimeHandler.GetCurrentTimeUTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*UserSession
+	for rows.Next() {
+		var us UserSession
+		err = rows.Scan(&us.ID, &us.UserID, &us.Token, &us.UserAgent, &us.IP, &us.Created, &us.LastSeen)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &us)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// Delete removes the session with the given token from the index.
+func (s *UserSessionStore) Delete(token string) error {
+	stmt := `DELETE FROM user_sessions WHERE token = $1`
+
+	_, err := s.db.Exec(stmt, token)
+	return err
+}
+
+// DeleteOrphans removes the sessions whose scs session is gone from the index,
+// which happens once scs deletes expired sessions. It returns how many were
+// removed.
+func (s *UserSessionStore) DeleteOrphans(ctx context.Context) (int, error) {
+	stmt := `DELETE FROM user_sessions
+	WHERE created < $1 AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.token = user_sessions.token)`
+
+	result, err := s.db.ExecContext(ctx, stmt, s.datetimeHandler.GetCurrentTimeUTC().Add(-orphanAge))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// Cleanup calls DeleteOrphans every interval until ctx is done, and reports
+// the errors to onError.
+func (s *UserSessionStore) Cleanup(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.DeleteOrphans(ctx); err != nil && ctx.Err() == nil {
+				onError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/96malhar/snippetbox/internal/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestUserSessionStore_Get(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	testcases := []struct {
+		name        string
+		id          int
+		wantSession *UserSession
+		wantErr     error
+	}{
+		{
+			name: "Exists",
+			id:   1,
+			wantSession: &UserSession{
+				ID:        1,
+				UserID:    1,
+				Token:     "token-1",
+				UserAgent: "Firefox",
+				IP:        "10.0.0.1",
+				Created:   parseTime(t, time.RFC3339, "2022-12-01T10:00:00Z"),
+				LastSeen:  parseTime(t, time.RFC3339, "2022-12-01T10:00:00Z"),
+			},
+		},
+		{
+			name:    "Does not exist",
+			id:      10,
+			wantErr: ErrNoRecord,
+		},
+	}
+
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewUserSessionStore(db)
+
+			got, err := s.Get(tc.id)
+			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantSession, got)
+		})
+	}
+}
+
+func TestUserSessionStore_GetAllForUser(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	testcases := []struct {
+		name            string
+		userID          int
+		mockCurrentTime string
+		wantTokens      []string
+	}{
+		{
+			name:            "All sessions unexpired",
+			userID:          1,
+			mockCurrentTime: "2022-12-15T10:00:00Z",
+			wantTokens:      []string{"token-1", "token-2"},
+		},
+		{
+			name:            "Some sessions expired",
+			userID:          1,
+			mockCurrentTime: "2023-02-01T10:00:00Z",
+			wantTokens:      []string{"token-1"},
+		},
+		{
+			name:            "Unknown user",
+			userID:          2,
+			mockCurrentTime: "2022-12-15T10:00:00Z",
+		},
+	}
+
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewUserSessionStore(db)
+			s.datetimeHandler = mocks.NewMockDateTimeHandler(parseTime(t, time.RFC3339, tc.mockCurrentTime))
+
+			got, err := s.GetAllForUser(tc.userID)
+			require.NoError(t, err)
+
+			var gotTokens []string
+			for _, us := range got {
+				gotTokens = append(gotTokens, us.Token)
+			}
+			assert.Equal(t, tc.wantTokens, gotTokens)
+		})
+	}
+}
+
+func TestUserSessionStore_InsertTouchDelete(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserSessionStore(db)
+	mockCurrTime := time.Date(2022, 12, 20, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	err := s.Insert(1, "token-4", "curl", "10.0.0.4")
+	require.NoError(t, err)
+
+	got, err := s.Get(4)
+	require.NoError(t, err)
+	assert.Equal(t, "token-4", got.Token)
+	assert.Equal(t, mockCurrTime, got.Created)
+
+	touchTime := mockCurrTime.Add(time.Hour)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(touchTime)
+	require.NoError(t, s.Touch("token-4"))
+
+	got, err = s.Get(4)
+	require.NoError(t, err)
+	assert.Equal(t, touchTime, got.LastSeen)
+
+	// A session seen less than a minute ago isn't updated again.
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(touchTime.Add(30 * time.Second))
+	require.NoError(t, s.Touch("token-4"))
+
+	got, err = s.Get(4)
+	require.NoError(t, err)
+	assert.Equal(t, touchTime, got.LastSeen)
+
+	require.NoError(t, s.Delete("token-4"))
+	_, err = s.Get(4)
+	assert.ErrorIs(t, err, ErrNoRecord)
+}
+
+func TestUserSessionStore_DeleteOrphans(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserSessionStore(db)
+	mockCurrTime := time.Date(2022, 12, 20, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	// The scs session of token-5 isn't saved yet, so it is kept.
+	require.NoError(t, s.Insert(1, "token-5", "curl", "10.0.0.5"))
+
+	// token-3 has no scs session.
+	n, err := s.DeleteOrphans(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = s.Get(3)
+	assert.ErrorIs(t, err, ErrNoRecord)
+	for _, id := range []int{1, 2, 4} {
+		_, err = s.Get(id)
+		assert.NoError(t, err)
+	}
+}
@@ -1,66 +1,113 @@
 package store
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/96malhar/snippetbox/internal/datetime"
-	"github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
-	"strings"
 	"time"
 )
 
+// Role determines what a user is allowed to do. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{RoleUser: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// Includes returns true if r grants at least the permissions of other.
+func (r Role) Includes(other Role) bool {
+	return roleRanks[r] >= roleRanks[other] && roleRanks[other] > 0
+}
+
+// Valid returns true if r is one of the known roles.
+func (r Role) Valid() bool {
+	return roleRanks[r] > 0
+}
+
+// passwordResetLifetime is how long a password reset link can be used for.
+const passwordResetLifetime = 24 * time.Hour
+
 type User struct {
 	ID             int
 	Name           string
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	TOTPSecret     string
+	TOTPEnabled    bool
+	Role           Role
+	Disabled       bool
 }
 
 type UserStore struct {
 	db              *sql.DB
+	hasher          PasswordHasher
+	queryTimeout    time.Duration
 	datetimeHandler interface {
 		GetCurrentTimeUTC() time.Time
 	}
 }
 
-func NewUserStore(db *sql.DB) *UserStore {
-	return &UserStore{db: db, datetimeHandler: &datetime.Handler{}}
+// NewUserStore returns a UserStore which hashes passwords with hasher. Hashes
+// made with other algorithms or parameters are replaced when their users log in.
+// Its calls give up after queryTimeout, or never if it is 0.
+func NewUserStore(db *sql.DB, hasher PasswordHasher, queryTimeout time.Duration) *UserStore {
+	return &UserStore{db: db, hasher: hasher, queryTimeout: queryTimeout, datetimeHandler: &datetime.Handler{}}
 }
 
-func (s *UserStore) Insert(name, email, password string) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+// Insert adds a user and returns their ID.
+func (s *UserStore) Insert(ctx context.Context, name, email, password string) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	hashedPassword, err := s.hasher.Hash(password)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, hashed_password, created)
-    VALUES($1, $2, $3, $4)`
+    VALUES($1, $2, $3, $4) RETURNING id`
 
 	createdAt := s.datetimeHandler.GetCurrentTimeUTC()
 
-	_, err = s.db.Exec(stmt, name, email, string(hashedPassword), createdAt)
+	var id int
+	err = s.db.QueryRowContext(ctx, stmt, name, email, hashedPassword, createdAt).Scan(&id)
 	if err != nil {
-		var pqErr *pq.Error
-		if errors.As(err, &pqErr) {
-			if pqErr.Code == "23505" && strings.Contains(pqErr.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
+		if isUniqueViolation(err, "users", "email") {
+			return 0, ErrDuplicateEmail
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	return id, nil
 }
 
-func (s *UserStore) Authenticate(email, password string) (int, error) {
+// Authenticate returns the id of the user with the given email and password. If
+// the password hash of the user is outdated, it is replaced by a hash made with
+// the current algorithm and parameters. The user is authenticated whether the
+// new hash is saved or not, so if it isn't the id is returned along with an
+// error wrapping ErrRehash.
+func (s *UserStore) Authenticate(ctx context.Context, email, password string) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
 	var id int
-	var hashedPassword []byte
+	var hashedPassword sql.NullString
 
 	stmt := "SELECT id, hashed_password FROM users WHERE email = $1"
 
-	err := s.db.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err = s.db.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -68,32 +115,119 @@ func (s *UserStore) Authenticate(email, password string) (int, error) {
 		return 0, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	// Users provisioned by an OpenID Connect provider don't have a password.
+	if !hashedPassword.Valid || hashedPassword.String == "" {
+		return 0, ErrInvalidCredentials
+	}
+
+	match, rehash, err := s.hasher.Verify(password, hashedPassword.String)
 	if err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			return 0, ErrInvalidCredentials
-		}
 		return 0, err
 	}
+	if !match {
+		return 0, ErrInvalidCredentials
+	}
+
+	if rehash {
+		// The old hash is checked so that a password changed in the meantime
+		// isn't overwritten.
+		newHash, err := s.hasher.Hash(password)
+		if err != nil {
+			return id, fmt.Errorf("%w: %w", ErrRehash, err)
+		}
+		stmt = "UPDATE users SET hashed_password = $1 WHERE id = $2 AND hashed_password = $3"
+		if _, err = s.db.ExecContext(ctx, stmt, newHash, id, hashedPassword.String); err != nil {
+			return id, fmt.Errorf("%w: %w", ErrRehash, err)
+		}
+	}
 
 	return id, nil
 }
 
-func (s *UserStore) Exists(id int) (bool, error) {
+// UpdatePassword replaces the password of a user.
+func (s *UserStore) UpdatePassword(ctx context.Context, id int, password string) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET hashed_password = $1 WHERE id = $2", hashedPassword, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// ProvisionIdentity returns the id of the user linked to an identity asserted by an
+// OpenID Connect provider. If the identity is new, it is linked to the user with
+// the same email address, or to a newly created user without a password if there
+// is none. Callers must only pass email addresses the provider has verified.
+func (s *UserStore) ProvisionIdentity(ctx context.Context, issuer, subject, name, email string) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2", issuer, subject).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	createdAt := s.datetimeHandler.GetCurrentTimeUTC()
+
+	err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", email).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		stmt := `INSERT INTO users (name, email, hashed_password, created)
+		VALUES($1, $2, NULL, $3) RETURNING id`
+		err = tx.QueryRowContext(ctx, stmt, name, email, createdAt).Scan(&id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `INSERT INTO user_identities (issuer, subject, user_id, created)
+	VALUES($1, $2, $3, $4)`
+	_, err = tx.ExecContext(ctx, stmt, issuer, subject, id, createdAt)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s *UserStore) Exists(ctx context.Context, id int) (_ bool, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
 	var exists bool
 
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = $1)"
 
-	err := s.db.QueryRow(stmt, id).Scan(&exists)
+	err = s.db.QueryRowContext(ctx, stmt, id).Scan(&exists)
 	return exists, err
 }
 
-func (s *UserStore) Get(id int) (*User, error) {
+func (s *UserStore) Get(ctx context.Context, id int) (_ *User, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
 	var user User
 
-	stmt := `SELECT id, name, email, created FROM users WHERE id = $1`
+	stmt := `SELECT id, name, email, created, totp_secret, totp_enabled, role, disabled FROM users WHERE id = $1`
 
-	err := s.db.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created)
+	err = s.db.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.TOTPSecret, &user.TOTPEnabled,
+		&user.Role, &user.Disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
@@ -104,3 +238,283 @@ func (s *UserStore) Get(id int) (*User, error) {
 
 	return &user, nil
 }
+
+// Search returns up to limit users whose name or email contains the query,
+// ordered by id. An empty query matches every user.
+func (s *UserStore) Search(ctx context.Context, query string, limit int) (_ []*User, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `SELECT id, name, email, created, totp_enabled, role, disabled FROM users
+	WHERE lower(name) LIKE lower($1) ESCAPE '\' OR lower(email) LIKE lower($1) ESCAPE '\' ORDER BY id LIMIT $2`
+
+	rows, err := s.db.QueryContext(ctx, stmt, "%"+likeEscaper.Replace(query)+"%", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var user User
+		err = rows.Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.TOTPEnabled, &user.Role, &user.Disabled)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// Count returns the total number of users.
+func (s *UserStore) Count(ctx context.Context) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	var count int
+	err = s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count)
+	return count, err
+}
+
+// SetDisabled disables or re-enables the account of a user. Disabled users can't log in.
+func (s *UserStore) SetDisabled(ctx context.Context, id int, disabled bool) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET disabled = $1 WHERE id = $2", disabled, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// SetRole changes the role of a user.
+func (s *UserStore) SetRole(ctx context.Context, id int, role Role) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id)
+	if err != nil {
+		return err
+	}
+	return checkRowsAffected(result)
+}
+
+// EnableTOTP turns on two-factor authentication for a user with the given TOTP
+// secret, replacing any previously issued recovery codes with the given ones.
+// Only hashes of the recovery codes are stored.
+func (s *UserStore) EnableTOTP(ctx context.Context, id int, secret string, recoveryCodes []string) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET totp_secret = $1, totp_enabled = TRUE WHERE id = $2", secret, id)
+	if err != nil {
+		return err
+	}
+	if err = checkRowsAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (user_id, hashed_code) VALUES($1, $2)", id, hashRecoveryCode(code))
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication for a user and deletes their
+// recovery codes.
+func (s *UserStore) DisableTOTP(ctx context.Context, id int) (err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_secret = '', totp_enabled = FALSE WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used. It returns
+// false if the user has no such unused code.
+func (s *UserStore) UseRecoveryCode(ctx context.Context, id int, code string) (_ bool, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `UPDATE user_recovery_codes SET used = TRUE
+	WHERE user_id = $1 AND hashed_code = $2 AND NOT used`
+
+	result, err := s.db.ExecContext(ctx, stmt, id, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// UseTOTPCounter records that a user entered the TOTP code of the given time
+// step. It returns false if they already entered a code of that step or a
+// later one, in which case the code must be refused.
+func (s *UserStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (_ bool, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	stmt := `UPDATE users SET totp_last_counter = $1 WHERE id = $2 AND totp_last_counter < $1`
+
+	result, err := s.db.ExecContext(ctx, stmt, counter, id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// CreatePasswordReset returns the token of a new link letting a user choose a
+// new password, which expires after a day. Only a hash of the token is stored.
+func (s *UserStore) CreatePasswordReset(ctx context.Context, id int) (_ string, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := s.datetimeHandler.GetCurrentTimeUTC()
+
+	// Clean up the links nobody used while at it.
+	_, err = s.db.ExecContext(ctx, "DELETE FROM password_resets WHERE expires <= $1", now)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (user_id, token_hash, created, expires)
+	SELECT id, $2, $3, $4 FROM users WHERE id = $1`
+
+	result, err := s.db.ExecContext(ctx, stmt, id, hashResetToken(token), now, now.Add(passwordResetLifetime))
+	if err != nil {
+		return "", err
+	}
+	if err = checkRowsAffected(result); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// PasswordResetUser returns the user a password reset link is for. It returns
+// ErrNoRecord if there is no unexpired link with the given token.
+func (s *UserStore) PasswordResetUser(ctx context.Context, token string) (_ *User, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	var id int
+
+	stmt := "SELECT user_id FROM password_resets WHERE token_hash = $1 AND expires > $2"
+
+	err = s.db.QueryRowContext(ctx, stmt, hashResetToken(token), s.datetimeHandler.GetCurrentTimeUTC()).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+
+	return s.Get(ctx, id)
+}
+
+// ResetPassword sets the password of the user a password reset link is for and
+// uses up every link of the user. It returns the id of the user, or ErrNoRecord
+// if there is no unexpired link with the given token.
+func (s *UserStore) ResetPassword(ctx context.Context, token, password string) (_ int, err error) {
+	ctx, done := withTimeout(ctx, s.queryTimeout, &err)
+	defer done()
+
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	stmt := "SELECT user_id FROM password_resets WHERE token_hash = $1 AND expires > $2" + forUpdate(s.db, "")
+	err = tx.QueryRowContext(ctx, stmt, hashResetToken(token), s.datetimeHandler.GetCurrentTimeUTC()).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		} else {
+			return 0, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET hashed_password = $1 WHERE id = $2", hashedPassword, id)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = $1", id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// hashRecoveryCode returns the hex encoded SHA-256 hash of a recovery code. A fast
+// hash is sufficient here because recovery codes are random rather than chosen by users.
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashResetToken returns the hex encoded SHA-256 hash of a password reset token.
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
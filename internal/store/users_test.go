@@ -1,13 +1,19 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
 	"github.com/96malhar/snippetbox/internal/testutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func TestUserStore_Exists(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	testcases := []struct {
 		name    string
 		id      int
@@ -35,9 +41,9 @@ func TestUserStore_Exists(t *testing.T) {
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewUserStore(db)
+			s := NewUserStore(db, testHasher, 0)
 
-			got, err := s.Exists(tc.id)
+			got, err := s.Exists(ctx, tc.id)
 			assert.ErrorIs(t, err, tc.wantErr)
 			assert.Equal(t, tc.want, got)
 		})
@@ -46,6 +52,7 @@ func TestUserStore_Exists(t *testing.T) {
 
 func TestUserStore_Get(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	testcases := []struct {
 		name    string
 		id      int
@@ -73,8 +80,8 @@ func TestUserStore_Get(t *testing.T) {
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewUserStore(db)
-			gotUser, err := s.Get(tc.id)
+			s := NewUserStore(db, testHasher, 0)
+			gotUser, err := s.Get(ctx, tc.id)
 			assert.ErrorIs(t, err, tc.wantErr)
 
 			for _, check := range tc.checks {
@@ -86,6 +93,7 @@ func TestUserStore_Get(t *testing.T) {
 
 func TestUserStore_Authenticate(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	testcases := []struct {
 		name         string
 		userEmail    string
@@ -124,17 +132,88 @@ func TestUserStore_Authenticate(t *testing.T) {
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewUserStore(db)
+			s := NewUserStore(db, testHasher, 0)
 
-			gotId, err := s.Authenticate(tc.userEmail, tc.userPassword)
+			gotId, err := s.Authenticate(ctx, tc.userEmail, tc.userPassword)
 			assert.ErrorIs(t, err, tc.wantErr)
 			assert.Equal(t, tc.wantId, gotId)
 		})
 	}
 }
 
+func TestUserStore_Authenticate_Rehash(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	// John's password was hashed with bcrypt, it is replaced by an Argon2id hash
+	// when he logs in.
+	s := NewUserStore(db, testArgon2idHasher, 0)
+	id, err := s.Authenticate(ctx, "john@example.com", "Hello, World!")
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	var hash string
+	err = db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&hash)
+	require.NoError(t, err)
+	assert.Regexp(t, phcRX, hash)
+
+	id, err = s.Authenticate(ctx, "john@example.com", "Hello, World!")
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	_, err = s.Authenticate(ctx, "john@example.com", "Bye, World!")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+// failingHasher verifies passwords like Argon2idHasher, but can't hash them.
+type failingHasher struct {
+	Argon2idHasher
+}
+
+func (h *failingHasher) Hash(string) (string, error) {
+	return "", errors.New("out of memory")
+}
+
+func TestUserStore_Authenticate_RehashFailure(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	var oldHash string
+	err := db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&oldHash)
+	require.NoError(t, err)
+
+	// John is authenticated even though his bcrypt hash can't be replaced.
+	s := NewUserStore(db, &failingHasher{*testArgon2idHasher}, 0)
+	id, err := s.Authenticate(ctx, "john@example.com", "Hello, World!")
+	assert.ErrorIs(t, err, ErrRehash)
+	assert.ErrorContains(t, err, "out of memory")
+	assert.Equal(t, 1, id)
+
+	var hash string
+	err = db.QueryRow("SELECT hashed_password FROM users WHERE id = 1").Scan(&hash)
+	require.NoError(t, err)
+	assert.Equal(t, oldHash, hash)
+
+	_, err = s.Authenticate(ctx, "john@example.com", "Bye, World!")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.NotErrorIs(t, err, ErrRehash)
+}
+
 func TestUserStore_Insert(t *testing.T) {
 	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
 	testcases := []struct {
 		name         string
 		userName     string
@@ -169,17 +248,321 @@ func TestUserStore_Insert(t *testing.T) {
 
 	for _, tc := range testcases {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewUserStore(db)
+			s := NewUserStore(db, testHasher, 0)
 
-			err := s.Insert(tc.userName, tc.userEmail, tc.userPassword)
+			id, err := s.Insert(ctx, tc.userName, tc.userEmail, tc.userPassword)
 
 			assert.ErrorIs(t, err, tc.wantErr)
+			assert.Equal(t, tc.wantId, id)
 
 			if tc.wantId != 0 {
-				exists, err := s.Exists(tc.wantId)
+				exists, err := s.Exists(ctx, tc.wantId)
 				assert.True(t, exists)
 				assert.NoError(t, err)
 			}
 		})
 	}
 }
+
+func TestUserStore_TOTP(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserStore(db, testHasher, 0)
+
+	err := s.EnableTOTP(ctx, 2, "JBSWY3DPEHPK3PXP", []string{"aaaaa-bbbbb"})
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	err = s.EnableTOTP(ctx, 1, "JBSWY3DPEHPK3PXP", []string{"aaaaa-bbbbb", "ccccc-ddddd"})
+	require.NoError(t, err)
+
+	user, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.True(t, user.TOTPEnabled)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", user.TOTPSecret)
+
+	ok, err := s.UseRecoveryCode(ctx, 1, "aaaaa-bbbbb")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Recovery codes can only be used once
+	ok, err = s.UseRecoveryCode(ctx, 1, "aaaaa-bbbbb")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.UseRecoveryCode(ctx, 1, "unknown")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	err = s.DisableTOTP(ctx, 1)
+	require.NoError(t, err)
+
+	user, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.False(t, user.TOTPEnabled)
+	assert.Empty(t, user.TOTPSecret)
+
+	ok, err = s.UseRecoveryCode(ctx, 1, "ccccc-ddddd")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestUserStore_ProvisionIdentity(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	testcases := []struct {
+		name      string
+		issuer    string
+		subject   string
+		userName  string
+		userEmail string
+		wantId    int
+	}{
+		{
+			name:      "Linked identity",
+			issuer:    "https://sso.example.com",
+			subject:   "john-123",
+			userName:  "John",
+			userEmail: "john@example.com",
+			wantId:    1,
+		},
+		{
+			name:      "New identity of an existing user",
+			issuer:    "https://other-sso.example.com",
+			subject:   "john-456",
+			userName:  "John",
+			userEmail: "john@example.com",
+			wantId:    1,
+		},
+		{
+			name:      "New user",
+			issuer:    "https://sso.example.com",
+			subject:   "jane-123",
+			userName:  "Jane",
+			userEmail: "jane@example.com",
+			wantId:    2,
+		},
+		{
+			name:      "Previously provisioned user",
+			issuer:    "https://sso.example.com",
+			subject:   "jane-123",
+			userName:  "Jane",
+			userEmail: "jane@example.com",
+			wantId:    2,
+		},
+	}
+
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewUserStore(db, testHasher, 0)
+
+			gotId, err := s.ProvisionIdentity(ctx, tc.issuer, tc.subject, tc.userName, tc.userEmail)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantId, gotId)
+		})
+	}
+
+	t.Run("Provisioned users can't login with a password", func(t *testing.T) {
+		s := NewUserStore(db, testHasher, 0)
+
+		_, err := s.Authenticate(ctx, "jane@example.com", "")
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+	})
+}
+
+func TestUserStore_Search(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserStore(db, testHasher, 0)
+	_, err := s.Insert(ctx, "Jane", "jane@example.com", "random-pass-123")
+	require.NoError(t, err)
+	_, err = s.Insert(ctx, "Jack_Smith", "jack@example.org", "random-pass-123")
+	require.NoError(t, err)
+
+	testcases := []struct {
+		name    string
+		query   string
+		limit   int
+		wantIds []int
+	}{
+		{name: "Empty query", query: "", limit: 10, wantIds: []int{1, 2, 3}},
+		{name: "Limit", query: "", limit: 2, wantIds: []int{1, 2}},
+		{name: "By name", query: "jan", limit: 10, wantIds: []int{2}},
+		{name: "By email", query: "example.org", limit: 10, wantIds: []int{3}},
+		{name: "Wildcards are escaped", query: "_", limit: 10, wantIds: []int{3}},
+		{name: "No match", query: "nobody", limit: 10},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := s.Search(ctx, tc.query, tc.limit)
+			require.NoError(t, err)
+
+			var gotIds []int
+			for _, u := range users {
+				gotIds = append(gotIds, u.ID)
+			}
+			assert.Equal(t, tc.wantIds, gotIds)
+		})
+	}
+
+	count, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestUserStore_SetDisabledAndRole(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserStore(db, testHasher, 0)
+
+	user, err := s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, RoleUser, user.Role)
+	assert.False(t, user.Disabled)
+
+	require.NoError(t, s.SetDisabled(ctx, 1, true))
+	require.NoError(t, s.SetRole(ctx, 1, RoleModerator))
+
+	user, err = s.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, RoleModerator, user.Role)
+	assert.True(t, user.Disabled)
+
+	assert.ErrorIs(t, s.SetDisabled(ctx, 2, true), ErrNoRecord)
+	assert.ErrorIs(t, s.SetRole(ctx, 2, RoleAdmin), ErrNoRecord)
+	assert.Error(t, s.SetRole(ctx, 1, Role("superuser")))
+}
+
+func TestUserStore_UpdatePassword(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserStore(db, testHasher, 0)
+
+	require.NoError(t, s.UpdatePassword(ctx, 1, "new-sturdy-lantern"))
+
+	_, err := s.Authenticate(ctx, "john@example.com", "Hello, World!")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	id, err := s.Authenticate(ctx, "john@example.com", "new-sturdy-lantern")
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	assert.ErrorIs(t, s.UpdatePassword(ctx, 2, "new-sturdy-lantern"), ErrNoRecord)
+}
+
+func TestUserStore_PasswordReset(t *testing.T) {
+	testutils.RunAsIntegTest(t)
+	ctx := context.Background()
+	db, testDbName := newTestDB(t)
+	setupDB(t, db)
+	t.Cleanup(func() {
+		db.Close()
+		dropDB(t, testDbName)
+	})
+
+	s := NewUserStore(db, testHasher, 0)
+	mockCurrTime := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime)
+
+	_, err := s.CreatePasswordReset(ctx, 2)
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	first, err := s.CreatePasswordReset(ctx, 1)
+	require.NoError(t, err)
+	second, err := s.CreatePasswordReset(ctx, 1)
+	require.NoError(t, err)
+
+	user, err := s.PasswordResetUser(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "john@example.com", user.Email)
+
+	_, err = s.PasswordResetUser(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNoRecord)
+	_, err = s.ResetPassword(ctx, "unknown", "new-sturdy-lantern")
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	id, err := s.ResetPassword(ctx, first, "new-sturdy-lantern")
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	id, err = s.Authenticate(ctx, "john@example.com", "new-sturdy-lantern")
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	// Resetting the password uses up every link of the user.
+	_, err = s.ResetPassword(ctx, first, "other-sturdy-lantern")
+	assert.ErrorIs(t, err, ErrNoRecord)
+	_, err = s.PasswordResetUser(ctx, second)
+	assert.ErrorIs(t, err, ErrNoRecord)
+
+	t.Run("Expired link", func(t *testing.T) {
+		token, err := s.CreatePasswordReset(ctx, 1)
+		require.NoError(t, err)
+
+		s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime.Add(25 * time.Hour))
+		defer func() { s.datetimeHandler = mocks.NewMockDateTimeHandler(mockCurrTime) }()
+
+		_, err = s.PasswordResetUser(ctx, token)
+		assert.ErrorIs(t, err, ErrNoRecord)
+		_, err = s.ResetPassword(ctx, token, "other-sturdy-lantern")
+		assert.ErrorIs(t, err, ErrNoRecord)
+	})
+}
+
+func TestRole_Includes(t *testing.T) {
+	testcases := []struct {
+		role  Role
+		other Role
+		want  bool
+	}{
+		{role: RoleAdmin, other: RoleAdmin, want: true},
+		{role: RoleAdmin, other: RoleModerator, want: true},
+		{role: RoleAdmin, other: RoleUser, want: true},
+		{role: RoleModerator, other: RoleAdmin, want: false},
+		{role: RoleModerator, other: RoleModerator, want: true},
+		{role: RoleUser, other: RoleModerator, want: false},
+		{role: RoleUser, other: RoleUser, want: true},
+		{role: Role(""), other: RoleUser, want: false},
+		{role: RoleAdmin, other: Role("superuser"), want: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(string(tc.role)+" includes "+string(tc.other), func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.role.Includes(tc.other))
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package tlsreload serves a TLS certificate which can be replaced on disk while
+// the server is running, e.g. when it is renewed.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Reloader holds the certificate loaded from a pair of files. It reloads them
+// when they change or when the process receives SIGHUP, and keeps serving the
+// previous certificate if the new pair fails to load.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+	cert     atomic.Pointer[tls.Certificate]
+
+	// modTimes are the modification times of the files when they were last
+	// loaded. They are only used by Watch.
+	modTimes [2]time.Time
+}
+
+// New loads the certificate and key from the given files.
+func New(certFile, keyFile string, logger *slog.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	r.modTimes = r.statFiles()
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It is meant to be used as
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// NotAfter returns the expiry time of the current certificate.
+func (r *Reloader) NotAfter() time.Time {
+	return r.cert.Load().Leaf.NotAfter
+}
+
+// Reload loads the files again. On failure the current certificate is kept, and
+// the error is logged and returned.
+func (r *Reloader) Reload() error {
+	if err := r.load(); err != nil {
+		r.logger.Error("failed to reload TLS certificate, keeping the current one",
+			"cert", r.certFile, "key", r.keyFile, "error", err.Error())
+		return err
+	}
+
+	r.logger.Info("reloaded TLS certificate", "cert", r.certFile, "not_after", r.NotAfter())
+	return nil
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("tlsreload: parsing certificate: %w", err)
+	}
+
+	r.cert.Store(&cert)
+	return nil
+}
+
+// Watch reloads the certificate whenever the process receives SIGHUP and, if
+// interval is positive, when the modification time of either file changes. The
+// files are checked every interval. Watch returns once ctx is cancelled.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info("received SIGHUP, reloading TLS certificate")
+			r.modTimes = r.statFiles()
+			r.Reload()
+		case <-tick:
+			modTimes := r.statFiles()
+			if modTimes != r.modTimes {
+				// The new times are kept even if reloading fails, so that a broken
+				// pair is only retried once the files change again.
+				r.modTimes = modTimes
+				r.Reload()
+			}
+		}
+	}
+}
+
+// statFiles returns the modification times of the certificate and key files,
+// zero for the ones which can't be read.
+func (r *Reloader) statFiles() [2]time.Time {
+	var modTimes [2]time.Time
+	for i, name := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(name); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
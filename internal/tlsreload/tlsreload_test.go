@@ -0,0 +1,155 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"math/big"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// writeCertPair writes a self-signed certificate expiring at notAfter, and its
+// key, to the given files.
+func writeCertPair(t *testing.T, certFile, keyFile string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+// touch moves the modification time of the files forward, since a rewrite can
+// happen within the resolution of the file system clock.
+func touch(t *testing.T, names ...string) {
+	t.Helper()
+	future := time.Now().Add(time.Hour)
+	for _, name := range names {
+		require.NoError(t, os.Chtimes(name, future, future))
+	}
+}
+
+func newTestReloader(t *testing.T, notAfter time.Time) (*Reloader, string, string) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertPair(t, certFile, keyFile, notAfter)
+
+	r, err := New(certFile, keyFile, discardLogger)
+	require.NoError(t, err)
+	return r, certFile, keyFile
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Valid pair", func(t *testing.T) {
+		notAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+		r, _, _ := newTestReloader(t, notAfter)
+
+		assert.Equal(t, notAfter, r.NotAfter())
+		cert, err := r.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, "localhost", cert.Leaf.Subject.CommonName)
+	})
+
+	t.Run("Missing files", func(t *testing.T) {
+		dir := t.TempDir()
+		_, err := New(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), discardLogger)
+		assert.Error(t, err)
+	})
+}
+
+func TestReloader_Reload(t *testing.T) {
+	oldNotAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	newNotAfter := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	r, certFile, keyFile := newTestReloader(t, oldNotAfter)
+
+	t.Run("Broken pair keeps the current certificate", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+
+		assert.Error(t, r.Reload())
+		assert.Equal(t, oldNotAfter, r.NotAfter())
+	})
+
+	t.Run("New pair replaces the certificate", func(t *testing.T) {
+		writeCertPair(t, certFile, keyFile, newNotAfter)
+
+		require.NoError(t, r.Reload())
+		assert.Equal(t, newNotAfter, r.NotAfter())
+		cert, err := r.GetCertificate(nil)
+		require.NoError(t, err)
+		assert.Equal(t, newNotAfter, cert.Leaf.NotAfter)
+	})
+}
+
+func TestReloader_Watch(t *testing.T) {
+	oldNotAfter := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	newNotAfter := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	watch := func(t *testing.T, r *Reloader, interval time.Duration) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			r.Watch(ctx, interval)
+			close(done)
+		}()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+	}
+
+	t.Run("Changed files", func(t *testing.T) {
+		r, certFile, keyFile := newTestReloader(t, oldNotAfter)
+		watch(t, r, 10*time.Millisecond)
+
+		writeCertPair(t, certFile, keyFile, newNotAfter)
+		touch(t, certFile, keyFile)
+
+		assert.Eventually(t, func() bool {
+			return r.NotAfter().Equal(newNotAfter)
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("SIGHUP", func(t *testing.T) {
+		// Keep SIGHUP from killing the test binary in case it is sent before Watch
+		// starts listening for it.
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		r, certFile, keyFile := newTestReloader(t, oldNotAfter)
+		watch(t, r, 0)
+		writeCertPair(t, certFile, keyFile, newNotAfter)
+
+		assert.Eventually(t, func() bool {
+			syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			return r.NotAfter().Equal(newNotAfter)
+		}, 5*time.Second, 50*time.Millisecond)
+	})
+}
@@ -0,0 +1,145 @@
+// Package totp implements time-based one-time passwords as described in RFC 6238,
+// using the defaults understood by common authenticator apps: HMAC-SHA1, six
+// digits and a 30-second time step.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	digits   = 6
+	period   = 30
+	skew     = 1
+	secretSz = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSz)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Code returns the one-time password for the secret at time t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, counter(t)), nil
+}
+
+// Validate returns true if code is the one-time password for the secret at time t,
+// or for the time step directly before or after it to allow for clock drift.
+func Validate(secret, code string, t time.Time) bool {
+	_, ok := Counter(secret, code, t)
+	return ok
+}
+
+// Counter returns the time step code is the one-time password of, if Validate
+// accepts it. Codes of a step at or before the last step a user logged in with
+// must be refused, or a code could be used more than once.
+func Counter(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != digits {
+		return 0, false
+	}
+
+	c := counter(t)
+	var match int64
+	valid := false
+	for i := -skew; i <= skew; i++ {
+		want := codeAt(key, c, i)
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			match = int64(c) + int64(i)
+			valid = true
+		}
+	}
+	return match, valid
+}
+
+// URI returns the otpauth:// key URI which authenticator apps read from QR codes.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(digits))
+	v.Set("period", fmt.Sprint(period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// GenerateRecoveryCodes returns n random single-use codes in the form xxxxx-xxxxx.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		s := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode brings a recovery code entered by a user into the form
+// returned by GenerateRecoveryCodes.
+func NormalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+func counter(t time.Time) uint64 {
+	return uint64(t.Unix() / period)
+}
+
+func codeAt(key []byte, c uint64, offset int) string {
+	return code(key, uint64(int64(c)+int64(offset)))
+}
+
+// code implements HOTP as described in RFC 4226.
+func code(key []byte, c uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], c)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, bin%1000000)
+}
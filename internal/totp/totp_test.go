@@ -0,0 +1,115 @@
+package totp
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 secret used by the test vectors in RFC 6238 Appendix B.
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// The RFC test vectors use eight digits, so the expected codes are their last six digits.
+	testcases := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "59", time: 59, want: "287082"},
+		{name: "1111111109", time: 1111111109, want: "081804"},
+		{name: "1111111111", time: 1111111111, want: "050471"},
+		{name: "1234567890", time: 1234567890, want: "005924"},
+		{name: "2000000000", time: 2000000000, want: "279037"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Code(rfcSecret, time.Unix(tc.time, 0))
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 0, 15, 0, time.UTC)
+	codeAt := func(tm time.Time) string {
+		code, err := Code(rfcSecret, tm)
+		require.NoError(t, err)
+		return code
+	}
+
+	testcases := []struct {
+		name   string
+		secret string
+		code   string
+		want   bool
+	}{
+		{name: "Current step", secret: rfcSecret, code: codeAt(now), want: true},
+		{name: "Previous step", secret: rfcSecret, code: codeAt(now.Add(-30 * time.Second)), want: true},
+		{name: "Next step", secret: rfcSecret, code: codeAt(now.Add(30 * time.Second)), want: true},
+		{name: "Two steps behind", secret: rfcSecret, code: codeAt(now.Add(-60 * time.Second)), want: false},
+		{name: "Two steps ahead", secret: rfcSecret, code: codeAt(now.Add(60 * time.Second)), want: false},
+		{name: "Surrounding whitespace", secret: rfcSecret, code: " " + codeAt(now) + " ", want: true},
+		{name: "Wrong length", secret: rfcSecret, code: "12345", want: false},
+		{name: "Invalid secret", secret: "not base32!", code: codeAt(now), want: false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Validate(tc.secret, tc.code, now))
+		})
+	}
+}
+
+func TestCounter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 0, 15, 0, time.UTC)
+	step := now.Unix() / 30
+
+	for _, offset := range []int64{-1, 0, 1} {
+		code, err := Code(rfcSecret, now.Add(time.Duration(offset)*30*time.Second))
+		require.NoError(t, err)
+
+		got, ok := Counter(rfcSecret, code, now)
+		assert.True(t, ok)
+		assert.Equal(t, step+offset, got)
+	}
+
+	_, ok := Counter(rfcSecret, "12345", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	other, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Snippetbox", "alice@example.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(got, "otpauth://totp/Snippetbox:alice@example.com?"))
+	assert.Contains(t, got, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, got, "issuer=Snippetbox")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	require.NoError(t, err)
+	assert.Len(t, codes, 10)
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		assert.Regexp(t, "^[a-z2-7]{5}-[a-z2-7]{5}$", code)
+		assert.Equal(t, code, NormalizeRecoveryCode(" "+strings.ToUpper(code)+" "))
+		seen[code] = true
+	}
+	assert.Len(t, seen, 10)
+}
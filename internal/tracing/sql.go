@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"regexp"
+	"strings"
+)
+
+const instrumentationName = "github.com/96malhar/snippetbox/internal/tracing"
+
+var (
+	stringLiteralRX  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	numberLiteralRX  = regexp.MustCompile(`\$?\b\d+(?:\.\d+)?\b`)
+	consecutiveSpace = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL replaces the literals of a statement with question marks and
+// collapses its whitespace, so that it can be attached to a span without leaking
+// data. Placeholders like $1 are kept.
+func SanitizeSQL(query string) string {
+	query = stringLiteralRX.ReplaceAllString(query, "?")
+	query = numberLiteralRX.ReplaceAllStringFunc(query, func(s string) string {
+		if strings.HasPrefix(s, "$") {
+			return s
+		}
+		return "?"
+	})
+	return strings.TrimSpace(consecutiveSpace.ReplaceAllString(query, " "))
+}
+
+// The database systems the spans of statements can be attributed to.
+const (
+	DBSystemPostgreSQL = "postgresql"
+	DBSystemSQLite     = "sqlite"
+)
+
+// Connector returns a connector for sql.OpenDB which creates a span for every
+// statement sent through d, to a database of the given system. The spans are
+// children of the span in the context the statement is run with.
+func Connector(d driver.Driver, dsn, system string) driver.Connector {
+	return &connector{driver: d, dsn: dsn, system: semconv.DBSystemKey.String(system)}
+}
+
+type connector struct {
+	driver driver.Driver
+	dsn    string
+	system attribute.KeyValue
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, system: c.system}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// startSpan starts the span of a statement. The tracer is looked up every time,
+// so that the provider installed by Setup is used even for connections opened
+// before it.
+func startSpan(ctx context.Context, system attribute.KeyValue, query string) (context.Context, trace.Span) {
+	sanitized := SanitizeSQL(query)
+	name := "db"
+	if fields := strings.Fields(sanitized); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(system, semconv.DBStatement(sanitized)),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, driver.ErrSkip) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedConn traces the statements run on a connection. The optional interfaces
+// of the wrapped connection which don't run statements are passed through.
+type tracedConn struct {
+	driver.Conn
+	system attribute.KeyValue
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := startSpan(ctx, c.system, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := startSpan(ctx, c.system, query)
+	res, err := execer.ExecContext(ctx, query, args)
+	endSpan(span, err)
+	return res, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, system: c.system, query: query}, nil
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck // Fallback for drivers without BeginTx.
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *tracedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// tracedStmt traces the runs of a prepared statement.
+type tracedStmt struct {
+	driver.Stmt
+	system attribute.KeyValue
+	query  string
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startSpan(ctx, s.system, s.query)
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args)) //nolint:staticcheck // Fallback for drivers without QueryContext.
+	}
+	endSpan(span, err)
+	return rows, err
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startSpan(ctx, s.system, s.query)
+	var res driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = execer.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args)) //nolint:staticcheck // Fallback for drivers without ExecContext.
+	}
+	endSpan(span, err)
+	return res, err
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vals := make([]driver.Value, len(args))
+	for i, arg := range args {
+		vals[i] = arg.Value
+	}
+	return vals
+}
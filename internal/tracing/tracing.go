@@ -0,0 +1,71 @@
+// Package tracing sets up OpenTelemetry tracing, and traces the queries sent to
+// the database.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+)
+
+// The exporters spans can be sent to.
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Exporters lists the valid values of the exporter passed to Setup.
+var Exporters = []string{ExporterNone, ExporterStdout, ExporterOTLP}
+
+// Propagator reads and writes the W3C trace context and baggage headers.
+var Propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Setup installs the global tracer provider and propagator. Spans are written to
+// w by the stdout exporter, while the OTLP exporter sends them over HTTP to the
+// endpoint set by the standard OTEL_EXPORTER_OTLP_* environment variables. With
+// no exporter, spans are still created, so that trace IDs are propagated and
+// logged, but they aren't recorded.
+//
+// The returned function flushes the spans which haven't been exported yet and
+// must be called before the process exits.
+func Setup(ctx context.Context, exporter, serviceName string, w io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(Propagator)
+
+	var opts []sdktrace.TracerProviderOption
+	switch exporter {
+	case ExporterNone:
+		opts = append(opts, sdktrace.WithSampler(sdktrace.NeverSample()))
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	case ExporterOTLP:
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exp))
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, sdktrace.WithResource(res))
+
+	tp := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"io"
+	"testing"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "Placeholders",
+			query: "SELECT id, title FROM snippets WHERE id = $1",
+			want:  "SELECT id, title FROM snippets WHERE id = $1",
+		},
+		{
+			name:  "String literals",
+			query: "SELECT id FROM users WHERE email = 'alice@example.com' AND name = 'O''Brien'",
+			want:  "SELECT id FROM users WHERE email = ? AND name = ?",
+		},
+		{
+			name:  "Number literals",
+			query: "SELECT id FROM snippets WHERE expires > now() - interval '1 day' LIMIT 10 OFFSET 2.5",
+			want:  "SELECT id FROM snippets WHERE expires > now() - interval ? LIMIT ? OFFSET ?",
+		},
+		{
+			name:  "Identifiers with digits",
+			query: "SELECT v2 FROM table1 WHERE id = $12",
+			want:  "SELECT v2 FROM table1 WHERE id = $12",
+		},
+		{
+			name: "Whitespace",
+			query: `
+				INSERT INTO snippets (title, content)
+				VALUES ($1, $2)`,
+			want: "INSERT INTO snippets (title, content) VALUES ($1, $2)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, SanitizeSQL(tc.query))
+		})
+	}
+}
+
+func TestConnector(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	defer provider.Shutdown(context.Background())
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	otel.SetTracerProvider(provider)
+
+	db := sql.OpenDB(Connector(fakeDriver{}, "", DBSystemSQLite))
+	defer db.Close()
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	_, err := db.ExecContext(ctx, "UPDATE users SET name = 'Alice' WHERE id = $1", 1)
+	require.NoError(t, err)
+	_, err = db.QueryContext(ctx, "SELECT broken")
+	require.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	update := spans[0]
+	assert.Equal(t, "UPDATE", update.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), update.Parent().SpanID())
+	assert.Contains(t, update.Attributes(), semconv.DBSystemSqlite)
+	assert.Contains(t, update.Attributes(), semconv.DBStatement("UPDATE users SET name = ? WHERE id = $1"))
+	assert.Equal(t, codes.Unset, update.Status().Code)
+
+	selectSpan := spans[1]
+	assert.Equal(t, "SELECT", selectSpan.Name())
+	assert.Equal(t, codes.Error, selectSpan.Status().Code)
+}
+
+func TestSetup(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), ExporterStdout, "snippetbox", &buf)
+	require.NoError(t, err)
+
+	_, span := otel.Tracer("test").Start(context.Background(), "request")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	assert.Contains(t, buf.String(), `"Name":"request"`)
+	assert.Contains(t, buf.String(), `"Value":"snippetbox"`)
+
+	_, err = Setup(context.Background(), "jaeger", "snippetbox", &buf)
+	assert.EqualError(t, err, `tracing: unknown exporter "jaeger"`)
+}
+
+// fakeDriver accepts every statement, except those selecting "broken".
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	return fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeConn) Close() error {
+	return nil
+}
+
+func (fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("not supported")
+}
+
+func (fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if query == "SELECT broken" {
+		return nil, errors.New("column does not exist")
+	}
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string {
+	return nil
+}
+
+func (fakeRows) Close() error {
+	return nil
+}
+
+func (fakeRows) Next(dest []driver.Value) error {
+	return io.EOF
+}
@@ -0,0 +1,143 @@
+package validation
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+//go:generate go run ../../cmd/db breached build -o breached.bloom wordlists/passwords.txt
+
+// breachedPasswords is a filter of the most common passwords, which is used when
+// no other list of breached passwords is configured.
+//
+//go:embed breached.bloom
+var breachedPasswords []byte
+
+// bloomMagic starts the files written by BloomFilter.WriteTo.
+const bloomMagic = "SBXBLOOM"
+
+// ErrInvalidBloomFilter is returned when reading a file which isn't a bloom
+// filter written by BloomFilter.WriteTo.
+var ErrInvalidBloomFilter = errors.New("validation: invalid bloom filter")
+
+// BloomFilter is a compact set of passwords, which tells for sure that a password
+// isn't in the set, but only with a small rate of false positives that it is.
+//
+// Passwords are added by their SHA-1 hash, so that filters can be built from the
+// Pwned Passwords lists of Have I Been Pwned, which are lists of SHA-1 hashes.
+type BloomFilter struct {
+	// k is the number of bits set for each password, out of m.
+	k    uint32
+	m    uint64
+	bits []byte
+}
+
+// NewBloomFilter returns an empty filter sized for n passwords with the given
+// rate of false positives.
+func NewBloomFilter(n int, falsePositiveRate float64) *BloomFilter {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &BloomFilter{k: k, m: m, bits: make([]byte, (m+7)/8)}
+}
+
+// Add adds a password to the filter.
+func (f *BloomFilter) Add(password string) {
+	f.AddHash(sha1.Sum([]byte(password)))
+}
+
+// AddHash adds a password to the filter by its SHA-1 hash.
+func (f *BloomFilter) AddHash(sum [sha1.Size]byte) {
+	h1, h2 := f.hashes(sum)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Contains reports whether the password is probably in the filter.
+func (f *BloomFilter) Contains(password string) bool {
+	h1, h2 := f.hashes(sha1.Sum([]byte(password)))
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes derives the k bit positions of a password from two halves of its hash,
+// as described by Kirsch and Mitzenmacher.
+func (f *BloomFilter) hashes(sum [sha1.Size]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(sum[0:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
+
+// WriteTo writes the filter to w, in a format ReadBloomFilter reads.
+func (f *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, len(bloomMagic)+12)
+	copy(header, bloomMagic)
+	binary.BigEndian.PutUint32(header[len(bloomMagic):], f.k)
+	binary.BigEndian.PutUint64(header[len(bloomMagic)+4:], f.m)
+
+	n, err := w.Write(header)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := w.Write(f.bits)
+	return int64(n + m), err
+}
+
+// ReadBloomFilter reads a filter written by BloomFilter.WriteTo.
+func ReadBloomFilter(r io.Reader) (*BloomFilter, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(bloomMagic)+12)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(bloomMagic)]) != bloomMagic {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	f := &BloomFilter{
+		k: binary.BigEndian.Uint32(header[len(bloomMagic):]),
+		m: binary.BigEndian.Uint64(header[len(bloomMagic)+4:]),
+	}
+	if f.k == 0 || f.m == 0 || f.m > math.MaxInt32*8 {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	f.bits = make([]byte, (f.m+7)/8)
+	if _, err := io.ReadFull(br, f.bits); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, ErrInvalidBloomFilter
+	}
+	return f, nil
+}
+
+// LoadBloomFilter reads the filter of breached passwords at path, or returns the
+// embedded filter of common passwords if path is empty.
+func LoadBloomFilter(path string) (*BloomFilter, error) {
+	if path == "" {
+		return ReadBloomFilter(bytes.NewReader(breachedPasswords))
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("breached passwords: %w", err)
+	}
+	defer file.Close()
+
+	f, err := ReadBloomFilter(file)
+	if err != nil {
+		return nil, fmt.Errorf("breached passwords %s: %w", path, err)
+	}
+	return f, nil
+}
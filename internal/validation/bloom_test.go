@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := NewBloomFilter(1000, 1e-4)
+	for i := 0; i < 1000; i++ {
+		f.Add(fmt.Sprintf("password-%d", i))
+	}
+	f.AddHash(sha1.Sum([]byte("hashed")))
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, f.Contains(fmt.Sprintf("password-%d", i)))
+	}
+	assert.True(t, f.Contains("hashed"))
+
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Contains(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	assert.LessOrEqual(t, falsePositives, 5)
+}
+
+func TestBloomFilter_WriteTo(t *testing.T) {
+	f := NewBloomFilter(10, 1e-6)
+	f.Add("hunter2")
+
+	var buf bytes.Buffer
+	_, err := f.WriteTo(&buf)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "breached.bloom")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	read, err := LoadBloomFilter(path)
+	require.NoError(t, err)
+	assert.Equal(t, f, read)
+	assert.True(t, read.Contains("hunter2"))
+
+	_, err = ReadBloomFilter(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	assert.ErrorIs(t, err, ErrInvalidBloomFilter, "truncated")
+	_, err = ReadBloomFilter(bytes.NewReader(append(buf.Bytes(), 0)))
+	assert.ErrorIs(t, err, ErrInvalidBloomFilter, "trailing data")
+	_, err = ReadBloomFilter(bytes.NewReader([]byte("not a filter at all")))
+	assert.ErrorIs(t, err, ErrInvalidBloomFilter, "other file")
+
+	_, err = LoadBloomFilter(filepath.Join(t.TempDir(), "missing.bloom"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestLoadBloomFilter_Default(t *testing.T) {
+	f, err := LoadBloomFilter("")
+	require.NoError(t, err)
+	assert.True(t, f.Contains("password"))
+	assert.True(t, f.Contains("trustno1"))
+	assert.False(t, f.Contains("new-sturdy-lantern-42"))
+}
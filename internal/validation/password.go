@@ -0,0 +1,89 @@
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The reasons a PasswordPolicy rejects a password.
+var (
+	ErrPasswordTooShort = errors.New("validation: password too short")
+	ErrPasswordPersonal = errors.New("validation: password contains personal information")
+	ErrPasswordBreached = errors.New("validation: password found in a data breach")
+	ErrPasswordWeak     = errors.New("validation: password too weak")
+)
+
+// minPersonalLength is the length below which parts of a name or of an email
+// address are too short to be looked for in passwords.
+const minPersonalLength = 3
+
+// WeakPasswordError is returned by PasswordPolicy.Check for passwords which are
+// too easy to guess. It matches ErrPasswordWeak.
+type WeakPasswordError struct {
+	Strength Strength
+}
+
+func (e *WeakPasswordError) Error() string {
+	return fmt.Sprintf("%s: %.1f bits", ErrPasswordWeak, e.Strength.Entropy)
+}
+
+func (e *WeakPasswordError) Is(target error) bool {
+	return target == ErrPasswordWeak
+}
+
+// PasswordPolicy decides which passwords users may choose.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters of a password.
+	MinLength int
+	// MinEntropy is the minimum strength of a password, in bits, as estimated
+	// by EstimateStrength.
+	MinEntropy float64
+	// Breached holds the passwords known from data breaches, or is nil to not
+	// look passwords up.
+	Breached *BloomFilter
+}
+
+// Check returns nil if password meets the policy, or an error matching one of
+// the ErrPassword errors which says why not. personal holds what is known about
+// the user, like their name and email address, which mustn't appear in the
+// password.
+func (p *PasswordPolicy) Check(password string, personal ...string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return ErrPasswordTooShort
+	}
+	if containsPersonal(password, personal) {
+		return ErrPasswordPersonal
+	}
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return ErrPasswordBreached
+	}
+	if strength := EstimateStrength(password); strength.Entropy < p.MinEntropy {
+		return &WeakPasswordError{Strength: strength}
+	}
+	return nil
+}
+
+// containsPersonal reports whether the password contains a name or the local
+// part of an email address, in full or one of its words, ignoring case.
+func containsPersonal(password string, personal []string) bool {
+	password = strings.ToLower(password)
+	for _, s := range personal {
+		s = strings.ToLower(s)
+		if at := strings.LastIndex(s, "@"); at >= 0 {
+			s = s[:at]
+		}
+
+		words := strings.FieldsFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range append(words, strings.Join(words, "")) {
+			if utf8.RuneCountInString(word) >= minPersonalLength && strings.Contains(password, word) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	breached, err := LoadBloomFilter("")
+	require.NoError(t, err)
+	policy := &PasswordPolicy{MinLength: 8, MinEntropy: 30, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  error
+	}{
+		{name: "Strong", password: "new-sturdy-lantern-42"},
+		{name: "Random", password: "kj4h5g2l3kjh4g5l"},
+		{name: "Too short", password: "x7#Kp!", wantErr: ErrPasswordTooShort},
+		{name: "Name", password: "Bobcat-Lantern-Sturdy", wantErr: ErrPasswordPersonal},
+		{name: "Surname in capitals", password: "sturdy-MARLEY-lantern", wantErr: ErrPasswordPersonal},
+		{name: "Email address", password: "bob.marley1945!", wantErr: ErrPasswordPersonal},
+		{name: "Breached", password: "trustno1", wantErr: ErrPasswordBreached},
+		{name: "Common word with a digit", password: "Sunshine1", wantErr: ErrPasswordWeak},
+		{name: "L33t speak", password: "P@ssw0rd!", wantErr: ErrPasswordWeak},
+		{name: "Keyboard row", password: "poiuytrewq", wantErr: ErrPasswordWeak},
+		{name: "Sequence", password: "abcdefghij", wantErr: ErrPasswordWeak},
+		{name: "Repeat", password: "xyzxyzxyzxyz", wantErr: ErrPasswordWeak},
+		{name: "Date", password: "19/04/1988", wantErr: ErrPasswordWeak},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := policy.Check(tc.password, "Bob Marley", "bob.marley@example.com")
+			assert.ErrorIs(t, err, tc.wantErr)
+		})
+	}
+}
+
+func TestPasswordPolicy_Check_NoBreachedList(t *testing.T) {
+	policy := &PasswordPolicy{MinLength: 8}
+	assert.NoError(t, policy.Check("password"))
+}
+
+func TestEstimateStrength(t *testing.T) {
+	tests := []struct {
+		password    string
+		wantPattern Pattern
+		minEntropy  float64
+		maxEntropy  float64
+	}{
+		{password: "password", wantPattern: PatternDictionary, maxEntropy: 2},
+		{password: "drowssap", wantPattern: PatternDictionary, maxEntropy: 3},
+		{password: "P4ssw0rd", wantPattern: PatternDictionary, maxEntropy: 10},
+		{password: "Hello, World!", wantPattern: PatternDictionary, minEntropy: 30, maxEntropy: 40},
+		{password: "qwerty123", wantPattern: PatternDictionary, maxEntropy: 15},
+		{password: "zxcvfdsa", wantPattern: PatternKeyboard, maxEntropy: 20},
+		{password: "abcdefgh", wantPattern: PatternSequence, maxEntropy: 10},
+		{password: "97531", wantPattern: PatternSequence, maxEntropy: 10},
+		{password: "aaaaaaaaaa", wantPattern: PatternRepeat, maxEntropy: 10},
+		{password: "1988-04-19", wantPattern: PatternDate, maxEntropy: 20},
+		{password: "correct horse battery staple", wantPattern: PatternDictionary, minEntropy: 60},
+		{password: "kj4h5g2l3kjh4g5l", minEntropy: 50},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.password, func(t *testing.T) {
+			got := EstimateStrength(tc.password)
+			assert.Equal(t, tc.wantPattern, got.Pattern)
+			assert.GreaterOrEqual(t, got.Entropy, tc.minEntropy)
+			if tc.maxEntropy > 0 {
+				assert.LessOrEqual(t, got.Entropy, tc.maxEntropy)
+			}
+		})
+	}
+}
+
+func TestEstimateStrength_Long(t *testing.T) {
+	long := EstimateStrength(string(make([]rune, 1000)))
+	assert.Greater(t, long.Entropy, EstimateStrength(string(make([]rune, maxEstimatedLength))).Entropy)
+}
@@ -0,0 +1,242 @@
+package validation
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/96malhar/snippetbox/internal/datetime"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The bounds of the difficulty of proof-of-work challenges, in leading zero bits
+// of the hash. Each bit doubles the work; 16 bits take well under a second in a
+// browser and 24 bits a few minutes.
+const (
+	MinDifficulty = 1
+	MaxDifficulty = 24
+)
+
+const (
+	// challengeTTL is how long a challenge can be solved for, which leaves enough
+	// time to fill in a form.
+	challengeTTL = 30 * time.Minute
+	// submissionWindow is how long the solved challenges of an IP address keep
+	// raising the difficulty of its next ones.
+	submissionWindow = time.Hour
+	// busyLoad is the load past which every doubling adds a bit to the difficulty.
+	busyLoad = 32
+	// maxSolutionLen bounds the solutions, which are decimal counters.
+	maxSolutionLen = 20
+)
+
+// The reasons a solution is rejected.
+var (
+	ErrChallengeInvalid  = errors.New("validation: invalid proof-of-work challenge")
+	ErrChallengeExpired  = errors.New("validation: expired proof-of-work challenge")
+	ErrChallengeReplayed = errors.New("validation: proof-of-work challenge already used")
+	ErrChallengeUnsolved = errors.New("validation: proof-of-work challenge not solved")
+	ErrChallengeTooEasy  = errors.New("validation: proof-of-work challenge easier than the current difficulty")
+)
+
+// Challenge is a hashcash-style proof-of-work challenge, which is solved by
+// finding a decimal counter such that the SHA-256 hash of "<token>:<counter>"
+// starts with Difficulty zero bits.
+type Challenge struct {
+	// Token is the signed challenge, sent back along with the solution.
+	Token string
+	// Difficulty is the number of leading zero bits the hash must have.
+	Difficulty int
+}
+
+// ProofOfWork issues signed challenges to the clients submitting forms
+// anonymously, and verifies their solutions. The challenges are bound to the IP
+// address they were issued to and can only be used once. Their difficulty rises
+// with the load of the server and with the number of recent submissions from the
+// IP address, and a challenge is rejected if it is easier than one issued at the
+// time of the submission, so that challenges collected in advance are of no use.
+//
+// Used challenges and submissions are only remembered by the instance which
+// verified them, so a challenge can be replayed once on every instance.
+type ProofOfWork struct {
+	key        []byte
+	difficulty int
+	load       func() int
+
+	datetimeHandler interface {
+		GetCurrentTimeUTC() time.Time
+	}
+
+	mu sync.Mutex
+	// spent maps the nonces of the challenges used to when they expire.
+	spent       map[string]time.Time
+	submissions map[string]*submissions
+}
+
+// submissions counts the challenges an IP address solved since the start of a
+// window.
+type submissions struct {
+	count int
+	reset time.Time
+}
+
+// NewProofOfWork returns a ProofOfWork signing its challenges with key, which
+// must be shared by every instance of the application. Challenges start at
+// difficulty bits, between MinDifficulty and MaxDifficulty. load, which can be
+// nil, measures how busy the server is, for instance as the number of requests
+// being handled.
+func NewProofOfWork(key []byte, difficulty int, load func() int) *ProofOfWork {
+	return &ProofOfWork{
+		key:             key,
+		difficulty:      difficulty,
+		load:            load,
+		datetimeHandler: &datetime.Handler{},
+		spent:           map[string]time.Time{},
+		submissions:     map[string]*submissions{},
+	}
+}
+
+// Issue returns a new challenge for the client at ip.
+func (p *ProofOfWork) Issue(ip string) (Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, err
+	}
+
+	now := p.datetimeHandler.GetCurrentTimeUTC()
+	p.mu.Lock()
+	difficulty := p.currentDifficulty(ip, now)
+	p.mu.Unlock()
+	payload := fmt.Sprintf("%d.%d.%s", difficulty, now.Add(challengeTTL).Unix(), base64.RawURLEncoding.EncodeToString(nonce))
+	token := payload + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload, ip))
+	return Challenge{Token: token, Difficulty: difficulty}, nil
+}
+
+// Verify checks that solution solves the challenge token issued to ip, and marks
+// the challenge as used. It returns one of the ErrChallenge* errors otherwise.
+func (p *ProofOfWork) Verify(ip, token, solution string) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return ErrChallengeInvalid
+	}
+	payload := strings.Join(parts[:3], ".")
+	mac, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil || !hmac.Equal(mac, p.sign(payload, ip)) {
+		return ErrChallengeInvalid
+	}
+
+	// The fields can be trusted from here on, since the server signed them.
+	difficulty, _ := strconv.Atoi(parts[0])
+	expiresUnix, _ := strconv.ParseInt(parts[1], 10, 64)
+	expires := time.Unix(expiresUnix, 0).UTC()
+	nonce := parts[2]
+
+	now := p.datetimeHandler.GetCurrentTimeUTC()
+	if !now.Before(expires) {
+		return ErrChallengeExpired
+	}
+	if solution == "" || len(solution) > maxSolutionLen || strings.Trim(solution, "0123456789") != "" {
+		return ErrChallengeUnsolved
+	}
+	if leadingZeroBits(sha256.Sum256([]byte(token+":"+solution))) < difficulty {
+		return ErrChallengeUnsolved
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.spent[nonce]; ok {
+		return ErrChallengeReplayed
+	}
+	if difficulty < p.currentDifficulty(ip, now) {
+		return ErrChallengeTooEasy
+	}
+	p.spent[nonce] = expires
+
+	s, ok := p.submissions[ip]
+	if !ok || !now.Before(s.reset) {
+		s = &submissions{reset: now.Add(submissionWindow)}
+		p.submissions[ip] = s
+	}
+	s.count++
+	return nil
+}
+
+// currentDifficulty returns the difficulty of a challenge issued to ip. A bit is
+// added for every doubling of the load past busyLoad, and for every doubling of
+// the recent submissions from ip, so that the work asked of a client grows with
+// the number of forms it submits. p.mu must be held.
+func (p *ProofOfWork) currentDifficulty(ip string, now time.Time) int {
+	difficulty := p.difficulty
+	if p.load != nil {
+		if load := p.load(); load > busyLoad {
+			difficulty += bits.Len(uint(load / busyLoad))
+		}
+	}
+
+	if s, ok := p.submissions[ip]; ok && now.Before(s.reset) {
+		difficulty += bits.Len(uint(s.count))
+	}
+
+	return min(difficulty, MaxDifficulty)
+}
+
+// sign returns the MAC of a challenge issued to ip.
+func (p *ProofOfWork) sign(payload, ip string) []byte {
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(payload + "|" + ip))
+	return h.Sum(nil)
+}
+
+// Evict forgets the used challenges which expired, since they would be rejected
+// anyway, and the submissions of past windows.
+func (p *ProofOfWork) Evict() {
+	now := p.datetimeHandler.GetCurrentTimeUTC()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for nonce, expires := range p.spent {
+		if !now.Before(expires) {
+			delete(p.spent, nonce)
+		}
+	}
+	for ip, s := range p.submissions {
+		if !now.Before(s.reset) {
+			delete(p.submissions, ip)
+		}
+	}
+}
+
+// Cleanup evicts what is no longer needed every interval until ctx is done.
+func (p *ProofOfWork) Cleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Evict()
+		}
+	}
+}
+
+func leadingZeroBits(hash [sha256.Size]byte) int {
+	n := 0
+	for _, b := range hash {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
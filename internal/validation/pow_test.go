@@ -0,0 +1,203 @@
+package validation
+
+import (
+	"crypto/sha256"
+	"github.com/96malhar/snippetbox/internal/datetime/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProofOfWork(difficulty int, load func() int) (*ProofOfWork, *mocks.MockDateTimeHandler) {
+	clock := mocks.NewMockDateTimeHandler(time.Date(2023, time.January, 1, 10, 0, 0, 0, time.UTC))
+	p := NewProofOfWork([]byte("test key"), difficulty, load)
+	p.datetimeHandler = clock
+	return p, clock
+}
+
+// solve finds the solution of a challenge the way the script of the forms does.
+func solve(t *testing.T, c Challenge) string {
+	t.Helper()
+	for counter := 0; ; counter++ {
+		solution := strconv.Itoa(counter)
+		if leadingZeroBits(sha256.Sum256([]byte(c.Token+":"+solution))) >= c.Difficulty {
+			return solution
+		}
+	}
+}
+
+func TestProofOfWork_Verify(t *testing.T) {
+	const ip = "192.0.2.1"
+
+	tests := []struct {
+		name    string
+		tamper  func(token, solution string) (string, string, string)
+		wantErr error
+	}{
+		{
+			name:   "Valid",
+			tamper: func(token, solution string) (string, string, string) { return ip, token, solution },
+		},
+		{
+			name:    "Other IP address",
+			tamper:  func(token, solution string) (string, string, string) { return "192.0.2.2", token, solution },
+			wantErr: ErrChallengeInvalid,
+		},
+		{
+			name: "Lowered difficulty",
+			tamper: func(token, solution string) (string, string, string) {
+				return ip, "0" + strings.TrimPrefix(token, "4"), solution
+			},
+			wantErr: ErrChallengeInvalid,
+		},
+		{
+			name:    "Garbage token",
+			tamper:  func(token, solution string) (string, string, string) { return ip, "<script>", solution },
+			wantErr: ErrChallengeInvalid,
+		},
+		{
+			name:    "Missing solution",
+			tamper:  func(token, solution string) (string, string, string) { return ip, token, "" },
+			wantErr: ErrChallengeUnsolved,
+		},
+		{
+			name:    "Non-numeric solution",
+			tamper:  func(token, solution string) (string, string, string) { return ip, token, "-" + solution },
+			wantErr: ErrChallengeUnsolved,
+		},
+		{
+			name:    "Long solution",
+			tamper:  func(token, solution string) (string, string, string) { return ip, token, strings.Repeat("0", 21) },
+			wantErr: ErrChallengeUnsolved,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, _ := newTestProofOfWork(4, nil)
+			c, err := p.Issue(ip)
+			require.NoError(t, err)
+			require.Equal(t, 4, c.Difficulty)
+
+			gotIP, token, solution := tc.tamper(c.Token, solve(t, c))
+			err = p.Verify(gotIP, token, solution)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestProofOfWork_VerifyWrongSolution(t *testing.T) {
+	p, _ := newTestProofOfWork(MaxDifficulty, nil)
+	c, err := p.Issue("192.0.2.1")
+	require.NoError(t, err)
+
+	// Finding a solution to a 24 bits challenge by chance is unlikely enough.
+	assert.ErrorIs(t, p.Verify("192.0.2.1", c.Token, "0"), ErrChallengeUnsolved)
+}
+
+func TestProofOfWork_Replay(t *testing.T) {
+	p, _ := newTestProofOfWork(4, nil)
+	c, err := p.Issue("192.0.2.1")
+	require.NoError(t, err)
+	solution := solve(t, c)
+
+	require.NoError(t, p.Verify("192.0.2.1", c.Token, solution))
+	assert.ErrorIs(t, p.Verify("192.0.2.1", c.Token, solution), ErrChallengeReplayed)
+}
+
+func TestProofOfWork_CollectedChallenges(t *testing.T) {
+	load := 0
+	p, _ := newTestProofOfWork(4, func() int { return load })
+	issue := func() Challenge {
+		t.Helper()
+		c, err := p.Issue("192.0.2.1")
+		require.NoError(t, err)
+		require.Equal(t, 4, c.Difficulty)
+		return c
+	}
+
+	// Challenges issued before a submission are as easy as the one submitted,
+	// but the submission makes the next ones harder.
+	first, second := issue(), issue()
+	require.NoError(t, p.Verify("192.0.2.1", first.Token, solve(t, first)))
+	assert.ErrorIs(t, p.Verify("192.0.2.1", second.Token, solve(t, second)), ErrChallengeTooEasy)
+
+	// So does the load of the server.
+	other, err := p.Issue("192.0.2.2")
+	require.NoError(t, err)
+	load = 2 * busyLoad
+	assert.ErrorIs(t, p.Verify("192.0.2.2", other.Token, solve(t, other)), ErrChallengeTooEasy)
+
+	// The challenge isn't used up by the rejection.
+	load = 0
+	assert.NoError(t, p.Verify("192.0.2.2", other.Token, solve(t, other)))
+}
+
+func TestProofOfWork_Expiry(t *testing.T) {
+	p, clock := newTestProofOfWork(4, nil)
+	c, err := p.Issue("192.0.2.1")
+	require.NoError(t, err)
+	solution := solve(t, c)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(challengeTTL)
+	assert.ErrorIs(t, p.Verify("192.0.2.1", c.Token, solution), ErrChallengeExpired)
+}
+
+func TestProofOfWork_AdaptiveDifficulty(t *testing.T) {
+	load := 0
+	p, clock := newTestProofOfWork(4, func() int { return load })
+
+	issue := func(ip string) Challenge {
+		t.Helper()
+		c, err := p.Issue(ip)
+		require.NoError(t, err)
+		return c
+	}
+
+	// Every doubling of the submissions from an IP address adds a bit.
+	wantDifficulties := []int{4, 5, 6, 6, 7}
+	for _, want := range wantDifficulties {
+		c := issue("192.0.2.1")
+		assert.Equal(t, want, c.Difficulty)
+		require.NoError(t, p.Verify("192.0.2.1", c.Token, solve(t, c)))
+	}
+	assert.Equal(t, 4, issue("192.0.2.2").Difficulty, "other addresses are not affected")
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(submissionWindow)
+	assert.Equal(t, 4, issue("192.0.2.1").Difficulty, "submissions are forgotten after the window")
+
+	// So does every doubling of the load past busyLoad.
+	load = busyLoad
+	assert.Equal(t, 4, issue("192.0.2.1").Difficulty)
+	load = 2 * busyLoad
+	assert.Equal(t, 6, issue("192.0.2.1").Difficulty)
+	load = 1 << 30
+	assert.Equal(t, MaxDifficulty, issue("192.0.2.1").Difficulty)
+}
+
+func TestProofOfWork_Evict(t *testing.T) {
+	p, clock := newTestProofOfWork(4, nil)
+	c, err := p.Issue("192.0.2.1")
+	require.NoError(t, err)
+	require.NoError(t, p.Verify("192.0.2.1", c.Token, solve(t, c)))
+
+	p.Evict()
+	assert.Len(t, p.spent, 1)
+	assert.Len(t, p.submissions, 1)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(challengeTTL)
+	p.Evict()
+	assert.Empty(t, p.spent)
+	assert.Len(t, p.submissions, 1)
+
+	clock.MockCurrentTime = clock.MockCurrentTime.Add(submissionWindow)
+	p.Evict()
+	assert.Empty(t, p.submissions)
+}
@@ -0,0 +1,683 @@
+package validation
+
+import (
+	"bufio"
+	"embed"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Pattern is a kind of guessable pattern found in passwords.
+type Pattern string
+
+const (
+	PatternDictionary Pattern = "dictionary"
+	PatternSequence   Pattern = "sequence"
+	PatternRepeat     Pattern = "repeat"
+	PatternKeyboard   Pattern = "keyboard"
+	PatternDate       Pattern = "date"
+	// patternBruteforce covers the characters which aren't part of any other
+	// pattern, which can only be guessed one by one.
+	patternBruteforce Pattern = ""
+)
+
+// Strength is the estimated strength of a password.
+type Strength struct {
+	// Entropy is the base 2 logarithm of the number of guesses needed to find
+	// the password.
+	Entropy float64
+	// Pattern is the most significant pattern making the password guessable,
+	// or an empty string if there is none.
+	Pattern Pattern
+}
+
+// The constants of the estimate, taken from zxcvbn.
+const (
+	// bruteforceCardinality is the number of guesses per character which isn't
+	// part of a pattern.
+	bruteforceCardinality = 10
+	// minGuessesBeforeGrowingSequence penalises splitting a password into more
+	// patterns, so that a short sequence of patterns wins over a long one.
+	minGuessesBeforeGrowingSequence = 10000
+	minYearSpace                    = 20
+	// maxEstimatedLength bounds the work of an estimate, the characters past it
+	// count as bruteforce.
+	maxEstimatedLength = 64
+)
+
+//go:embed wordlists/*.txt
+var wordlists embed.FS
+
+// loadRankedWords returns the words of the embedded lists, mapped to their rank
+// in the list where they are the most common, and the length of the longest one.
+var loadRankedWords = sync.OnceValues(func() (map[string]int, int) {
+	ranks := make(map[string]int)
+	maxLen := 0
+
+	files, err := wordlists.ReadDir("wordlists")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range files {
+		f, err := wordlists.Open(path.Join("wordlists", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for rank := 1; scanner.Scan(); rank++ {
+			word := scanner.Text()
+			if r, ok := ranks[word]; !ok || rank < r {
+				ranks[word] = rank
+			}
+			maxLen = max(maxLen, len(word))
+		}
+		f.Close()
+	}
+	return ranks, maxLen
+})
+
+// match is a pattern found in the runes i to j of a password, inclusive.
+type match struct {
+	i, j    int
+	guesses float64
+	pattern Pattern
+}
+
+// EstimateStrength estimates how many guesses an attacker who knows common
+// passwords, words, names, sequences, keyboard rows and dates would need to find
+// password, in the way zxcvbn does: it finds the sequence of patterns covering
+// the password which is the easiest to guess.
+func EstimateStrength(password string) Strength {
+	runes := []rune(password)
+	var extra float64
+	if len(runes) > maxEstimatedLength {
+		extra = float64(len(runes)-maxEstimatedLength) * math.Log2(bruteforceCardinality)
+		runes = runes[:maxEstimatedLength]
+	}
+
+	guesses, sequence := mostGuessableSequence(runes)
+	strength := Strength{Entropy: math.Log2(guesses) + extra}
+
+	// The longest pattern tells the most about what makes the password weak.
+	longest := 0
+	for _, m := range sequence {
+		if m.pattern != patternBruteforce && m.j-m.i+1 > longest {
+			strength.Pattern = m.pattern
+			longest = m.j - m.i + 1
+		}
+	}
+	return strength
+}
+
+func estimateGuesses(runes []rune) float64 {
+	guesses, _ := mostGuessableSequence(runes)
+	return guesses
+}
+
+// mostGuessableSequence returns the number of guesses needed to find the
+// password made of runes, and the sequence of matches which needs the fewest.
+func mostGuessableSequence(runes []rune) (float64, []match) {
+	n := len(runes)
+	if n == 0 {
+		return 1, nil
+	}
+
+	byEnd := make([][]match, n)
+	for _, m := range findMatches(runes) {
+		// Patterns are never cheaper to guess than a few characters, unless they
+		// are the whole password.
+		if length := m.j - m.i + 1; length < n {
+			m.guesses = max(m.guesses, minGuesses(length))
+		}
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// optimal[k][l] is the best sequence of l matches covering the runes up to k.
+	type step struct {
+		guesses float64 // of the sequence, penalised for its length
+		product float64 // of the guesses of its matches
+		m       match
+	}
+	optimal := make([]map[int]step, n)
+	for k := range optimal {
+		optimal[k] = make(map[int]step)
+	}
+
+	update := func(m match, l int) {
+		product := m.guesses
+		if l > 1 {
+			product *= optimal[m.i-1][l-1].product
+		}
+		guesses := factorial(l)*product + math.Pow(minGuessesBeforeGrowingSequence, float64(l-1))
+
+		for other, s := range optimal[m.j] {
+			if other <= l && s.guesses <= guesses {
+				return
+			}
+		}
+		optimal[m.j][l] = step{guesses: guesses, product: product, m: m}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i == 0 {
+				update(m, 1)
+				continue
+			}
+			for l := range optimal[m.i-1] {
+				update(m, l+1)
+			}
+		}
+
+		// Bruteforce the runes i to k, unless the sequence before i already ends
+		// with bruteforce, which would then be better extended.
+		update(bruteforceMatch(0, k), 1)
+		for i := 1; i <= k; i++ {
+			m := bruteforceMatch(i, k)
+			for l, s := range optimal[i-1] {
+				if s.m.pattern != patternBruteforce {
+					update(m, l+1)
+				}
+			}
+		}
+	}
+
+	bestL := 0
+	best := math.Inf(1)
+	for l, s := range optimal[n-1] {
+		if s.guesses < best {
+			best, bestL = s.guesses, l
+		}
+	}
+
+	sequence := make([]match, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := optimal[k][l].m
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return best, sequence
+}
+
+func minGuesses(length int) float64 {
+	if length == 1 {
+		return bruteforceCardinality
+	}
+	return 5 * bruteforceCardinality
+}
+
+func bruteforceMatch(i, j int) match {
+	length := j - i + 1
+	guesses := math.Pow(bruteforceCardinality, float64(length))
+	return match{i: i, j: j, guesses: max(guesses, minGuesses(length)+1), pattern: patternBruteforce}
+}
+
+func findMatches(runes []rune) []match {
+	var matches []match
+	matches = append(matches, dictionaryMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+// l33tTable lists the letters each character can stand for.
+var l33tTable = map[rune][]rune{
+	'4': {'a'}, '@': {'a'}, '8': {'b'}, '(': {'c'}, '{': {'c'}, '[': {'c'}, '<': {'c'},
+	'3': {'e'}, '6': {'g'}, '9': {'g'}, '1': {'i', 'l'}, '!': {'i'}, '|': {'i', 'l'},
+	'0': {'o'}, '$': {'s'}, '5': {'s'}, '+': {'t'}, '7': {'t'}, '%': {'x'}, '2': {'z'},
+}
+
+// dictionaryMatches finds the common words in a password, including reversed
+// and l33t-speak ones.
+func dictionaryMatches(runes []rune) []match {
+	ranks, maxLen := loadRankedWords()
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	var matches []match
+	for i := range lower {
+		for j := i; j < len(lower) && j-i < maxLen; j++ {
+			token := lower[i : j+1]
+			upper := uppercaseVariations(runes[i : j+1])
+
+			best := math.Inf(1)
+			if rank, ok := ranks[string(token)]; ok {
+				best = float64(rank) * upper
+			}
+			if rank, ok := ranks[reverse(token)]; ok && len(token) > 1 {
+				best = min(best, float64(rank)*upper*2)
+			}
+			for _, sub := range unl33t(token) {
+				if rank, ok := ranks[string(sub)]; ok {
+					best = min(best, float64(rank)*upper*l33tVariations(token, sub))
+				}
+			}
+
+			if !math.IsInf(best, 1) {
+				matches = append(matches, match{i: i, j: j, guesses: best, pattern: PatternDictionary})
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations is the number of ways to capitalise a word like token is,
+// where the first, the last or all letters being capitalised are the most likely.
+func uppercaseVariations(token []rune) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 1
+	case lower == 0,
+		upper == 1 && unicode.IsUpper(token[0]),
+		upper == 1 && unicode.IsUpper(token[len(token)-1]):
+		return 2
+	}
+	return sumBinomials(upper+lower, min(upper, lower))
+}
+
+// unl33t returns the words token could be written in l33t-speak for, or none if
+// it has no l33t-speak characters.
+func unl33t(token []rune) [][]rune {
+	subs := [][]rune{{}}
+	found := false
+	for _, r := range token {
+		letters, ok := l33tTable[r]
+		if !ok {
+			letters = []rune{r}
+		} else {
+			found = true
+		}
+
+		next := make([][]rune, 0, len(subs)*len(letters))
+		for _, sub := range subs {
+			for _, letter := range letters {
+				next = append(next, append(sub[:len(sub):len(sub)], letter))
+			}
+		}
+		// Few words have more than a couple of ambiguous characters, don't let
+		// the others blow up.
+		if len(next) > 8 {
+			next = next[:8]
+		}
+		subs = next
+	}
+
+	if !found {
+		return nil
+	}
+	return subs
+}
+
+// l33tVariations is the number of ways to write sub in l33t-speak like token is.
+func l33tVariations(token, sub []rune) float64 {
+	variations := 1.0
+	seen := make(map[[2]rune]bool)
+	for k, r := range token {
+		if r == sub[k] || seen[[2]rune{r, sub[k]}] {
+			continue
+		}
+		seen[[2]rune{r, sub[k]}] = true
+
+		var substituted, unsubstituted int
+		for m := range token {
+			if token[m] == r && sub[m] == sub[k] {
+				substituted++
+			} else if token[m] == sub[k] {
+				unsubstituted++
+			}
+		}
+		if unsubstituted == 0 {
+			variations *= 2
+		} else {
+			variations *= sumBinomials(substituted+unsubstituted, min(substituted, unsubstituted))
+		}
+	}
+	return variations
+}
+
+// sequenceMatches finds runs of characters with a constant step, like abc, 7531
+// or ZYX.
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes)-2; {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == delta && sameClass(runes[j+1], runes[i]) {
+			j++
+		}
+
+		if j-i >= 2 && delta != 0 && abs(delta) <= 5 && sameClass(runes[i+1], runes[i]) {
+			matches = append(matches, match{i: i, j: j, guesses: sequenceGuesses(runes[i], delta, j-i+1), pattern: PatternSequence})
+			i = j
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+func sequenceGuesses(first rune, delta rune, length int) float64 {
+	var base float64
+	switch {
+	case strings.ContainsRune("aAzZ019", first):
+		base = 4
+	case unicode.IsDigit(first):
+		base = 10
+	default:
+		base = 26
+	}
+	if delta < 0 {
+		base *= 2
+	}
+	return base * float64(length)
+}
+
+func sameClass(a, b rune) bool {
+	switch {
+	case unicode.IsDigit(a):
+		return unicode.IsDigit(b)
+	case unicode.IsLower(a):
+		return unicode.IsLower(b)
+	case unicode.IsUpper(a):
+		return unicode.IsUpper(b)
+	}
+	return false
+}
+
+// repeatMatches finds characters or groups of characters repeated several times,
+// like aaa or abcabc. The shortest group covering the most characters wins.
+func repeatMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes); {
+		bestSize, bestCount := 0, 0
+		for size := 1; i+2*size <= len(runes); size++ {
+			unit := string(runes[i : i+size])
+			count := 1
+			for i+(count+1)*size <= len(runes) && string(runes[i+count*size:i+(count+1)*size]) == unit {
+				count++
+			}
+			if count >= 2 && (size > 1 || count >= 3) && size*count > bestSize*bestCount {
+				bestSize, bestCount = size, count
+			}
+		}
+
+		if bestSize == 0 {
+			i++
+			continue
+		}
+		guesses := estimateGuesses(runes[i:i+bestSize]) * float64(bestCount)
+		matches = append(matches, match{i: i, j: i + bestSize*bestCount - 1, guesses: guesses, pattern: PatternRepeat})
+		i += bestSize * bestCount
+	}
+	return matches
+}
+
+// keyboardRows are the rows of a QWERTY keyboard, without and with shift.
+var keyboardRows = [][2]string{
+	{"`1234567890-=", "~!@#$%^&*()_+"},
+	{"qwertyuiop[]\\", "QWERTYUIOP{}|"},
+	{"asdfghjkl;'", "ASDFGHJKL:\""},
+	{"zxcvbnm,./", "ZXCVBNM<>?"},
+}
+
+type key struct {
+	row, col int
+	shifted  bool
+}
+
+var keyboard = func() map[rune]key {
+	keys := make(map[rune]key)
+	for row, chars := range keyboardRows {
+		for col, r := range chars[0] {
+			keys[r] = key{row, col, false}
+		}
+		for col, r := range chars[1] {
+			keys[r] = key{row, col, true}
+		}
+	}
+	return keys
+}()
+
+// keyboardDirection returns the direction from one key to an adjacent one, as
+// an index in the list of its neighbours, or -1 if they aren't adjacent. Each
+// row is offset to the right of the one below it.
+func keyboardDirection(from, to key) int {
+	neighbours := [6][2]int{{0, -1}, {0, 1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}}
+	for dir, d := range neighbours {
+		if to.row == from.row+d[0] && to.col == from.col+d[1] {
+			return dir
+		}
+	}
+	return -1
+}
+
+// keyboardStarts is the number of keys and keyboardDegree their average number
+// of neighbours.
+var keyboardStarts, keyboardDegree = func() (float64, float64) {
+	keys, neighbours := 0, 0
+	for _, chars := range keyboardRows {
+		for _, from := range chars[0] {
+			keys++
+			for _, to := range keyboardRows {
+				for _, r := range to[0] {
+					if keyboardDirection(keyboard[from], keyboard[r]) >= 0 {
+						neighbours++
+					}
+				}
+			}
+		}
+	}
+	return float64(keys), float64(neighbours) / float64(keys)
+}()
+
+// keyboardMatches finds runs of adjacent keys, like qwerty or 1qaz2wsx.
+func keyboardMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes)-2; {
+		turns, shifted := 0, 0
+		if k, ok := keyboard[runes[i]]; ok && k.shifted {
+			shifted++
+		}
+
+		j, last := i, -1
+		for ; j+1 < len(runes); j++ {
+			from, ok1 := keyboard[runes[j]]
+			to, ok2 := keyboard[runes[j+1]]
+			dir := keyboardDirection(from, to)
+			if !ok1 || !ok2 || dir < 0 {
+				break
+			}
+			if dir != last {
+				turns++
+				last = dir
+			}
+			if to.shifted {
+				shifted++
+			}
+		}
+
+		if length := j - i + 1; length >= 3 {
+			matches = append(matches, match{i: i, j: j, guesses: keyboardGuesses(length, turns, shifted), pattern: PatternKeyboard})
+			i = j
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+func keyboardGuesses(length, turns, shifted int) float64 {
+	var guesses float64
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= min(turns, i-1); j++ {
+			guesses += binomial(i-1, j-1) * keyboardStarts * math.Pow(keyboardDegree, float64(j))
+		}
+	}
+
+	unshifted := length - shifted
+	switch {
+	case shifted == 0:
+	case unshifted == 0:
+		guesses *= 2
+	default:
+		guesses *= sumBinomials(length, min(shifted, unshifted))
+	}
+	return guesses
+}
+
+var dateRX = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+
+// dateMatches finds years and dates, written with or without separators.
+func dateMatches(runes []rune) []match {
+	var matches []match
+	for i := range runes {
+		for j := i + 3; j < len(runes) && j-i < 10; j++ {
+			token := string(runes[i : j+1])
+
+			var guesses float64
+			var ok bool
+			if isDigits(token) {
+				guesses, ok = digitsDateGuesses(token)
+			} else if m := dateRX.FindStringSubmatch(token); m != nil && m[2] == m[4] {
+				guesses, ok = dateGuesses(m[1], m[3], m[5])
+				guesses *= 4
+			}
+			if ok {
+				matches = append(matches, match{i: i, j: j, guesses: guesses, pattern: PatternDate})
+			}
+		}
+	}
+	return matches
+}
+
+func digitsDateGuesses(token string) (float64, bool) {
+	if len(token) == 4 {
+		if year, _ := strconv.Atoi(token); year >= 1900 && year <= 2099 {
+			return yearSpace(year), true
+		}
+	}
+
+	best, found := math.Inf(1), false
+	for a := 1; a < len(token)-1; a++ {
+		for b := a + 1; b < len(token); b++ {
+			if guesses, ok := dateGuesses(token[:a], token[a:b], token[b:]); ok {
+				best, found = min(best, guesses), true
+			}
+		}
+	}
+	return best, found
+}
+
+// dateGuesses returns the guesses needed to find a date written as the three
+// given parts, with the year either first or last.
+func dateGuesses(p1, p2, p3 string) (float64, bool) {
+	for _, parts := range [][3]string{{p1, p2, p3}, {p3, p1, p2}} {
+		year, ok := parseYear(parts[0])
+		if !ok || !isDayMonth(parts[1], parts[2]) && !isDayMonth(parts[2], parts[1]) {
+			continue
+		}
+		return 365 * yearSpace(year), true
+	}
+	return 0, false
+}
+
+func parseYear(s string) (int, bool) {
+	year, err := strconv.Atoi(s)
+	switch {
+	case err != nil:
+		return 0, false
+	case len(s) == 2 && year > 50:
+		return 1900 + year, true
+	case len(s) == 2:
+		return 2000 + year, true
+	case len(s) == 4 && year >= 1000 && year <= 2050:
+		return year, true
+	}
+	return 0, false
+}
+
+func isDayMonth(day, month string) bool {
+	if len(day) > 2 || len(month) > 2 {
+		return false
+	}
+	d, _ := strconv.Atoi(day)
+	m, _ := strconv.Atoi(month)
+	return d >= 1 && d <= 31 && m >= 1 && m <= 12
+}
+
+func yearSpace(year int) float64 {
+	return max(math.Abs(float64(year-time.Now().Year())), minYearSpace)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func reverse(runes []rune) string {
+	reversed := make([]rune, len(runes))
+	for i, r := range runes {
+		reversed[len(runes)-1-i] = r
+	}
+	return string(reversed)
+}
+
+func abs(r rune) rune {
+	if r < 0 {
+		return -r
+	}
+	return r
+}
+
+func factorial(n int) float64 {
+	f := 1.0
+	for i := 2; i <= n; i++ {
+		f *= float64(i)
+	}
+	return f
+}
+
+func binomial(n, k int) float64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	c := 1.0
+	for i := 1; i <= k; i++ {
+		c = c * float64(n-k+i) / float64(i)
+	}
+	return c
+}
+
+// sumBinomials returns the sum of the binomial coefficients (n, i) for i from 1
+// to k.
+func sumBinomials(n, k int) float64 {
+	var sum float64
+	for i := 1; i <= k; i++ {
+		sum += binomial(n, i)
+	}
+	return sum
+}
@@ -0,0 +1,14 @@
+CREATE TABLE user_sessions
+(
+    id         bigserial PRIMARY KEY,
+    token      TEXT                        NOT NULL,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    user_agent TEXT                        NOT NULL,
+    ip         TEXT                        NOT NULL,
+    created    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    last_seen  timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE user_sessions ADD CONSTRAINT user_sessions_uc_token UNIQUE (token);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS user_identities;
+
+DELETE FROM users WHERE hashed_password IS NULL;
+
+ALTER TABLE users ALTER COLUMN hashed_password SET NOT NULL;
@@ -0,0 +1,11 @@
+-- Users who sign in with an OpenID Connect provider don't have a password.
+ALTER TABLE users ALTER COLUMN hashed_password DROP NOT NULL;
+
+CREATE TABLE user_identities
+(
+    issuer  TEXT                        NOT NULL,
+    subject TEXT                        NOT NULL,
+    user_id bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
@@ -0,0 +1,26 @@
+ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE reports
+(
+    id         bigserial PRIMARY KEY,
+    snippet_id bigint                      NOT NULL REFERENCES snippets ON DELETE CASCADE,
+    reason     TEXT                        NOT NULL,
+    details    TEXT                        NOT NULL DEFAULT '',
+    status     TEXT                        NOT NULL DEFAULT 'open',
+    created    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT reports_status_check CHECK (status IN ('open', 'dismissed', 'actioned'))
+);
+
+CREATE INDEX reports_snippet_id_idx ON reports (snippet_id);
+
+-- Moderation actions outlive the snippets they were taken on, so snippet_id
+-- is deliberately not a foreign key.
+CREATE TABLE moderation_actions
+(
+    id           bigserial PRIMARY KEY,
+    snippet_id   bigint                      NOT NULL,
+    moderator_id bigint                      NOT NULL REFERENCES users,
+    action       TEXT                        NOT NULL,
+    created      timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT moderation_actions_action_check CHECK (action IN ('dismiss', 'hide', 'delete'))
+);
@@ -0,0 +1,29 @@
+CREATE TABLE audit_events
+(
+    id         bigserial PRIMARY KEY,
+    type       TEXT                        NOT NULL,
+    -- actor_id is NULL for events without a known user, like a failed login.
+    -- It is not a foreign key so that events outlive the users they mention.
+    actor_id   bigint,
+    ip         TEXT                        NOT NULL DEFAULT '',
+    user_agent TEXT                        NOT NULL DEFAULT '',
+    payload    jsonb                       NOT NULL DEFAULT '{}',
+    created    timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX audit_events_type_idx ON audit_events (type);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
+
+-- The audit log is append-only.
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE
+    ON audit_events
+    FOR EACH ROW
+EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,7 @@
+ALTER TABLE snippets DROP COLUMN IF EXISTS team_id;
+
+DROP TABLE IF EXISTS team_invites;
+
+DROP TABLE IF EXISTS team_members;
+
+DROP TABLE IF EXISTS teams;
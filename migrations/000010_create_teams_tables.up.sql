@@ -0,0 +1,43 @@
+CREATE TABLE teams
+(
+    id      bigserial PRIMARY KEY,
+    name    varchar(100)                NOT NULL,
+    slug    varchar(100)                NOT NULL,
+    created timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE teams ADD CONSTRAINT teams_uc_slug UNIQUE (slug);
+
+CREATE TABLE team_members
+(
+    team_id bigint                      NOT NULL REFERENCES teams ON DELETE CASCADE,
+    user_id bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    role    TEXT                        NOT NULL DEFAULT 'member',
+    joined  timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (team_id, user_id),
+    CONSTRAINT team_members_role_check CHECK (role IN ('owner', 'member'))
+);
+
+CREATE INDEX team_members_user_id_idx ON team_members (user_id);
+
+-- An invite with an empty email can be accepted by anyone holding its link,
+-- otherwise only by the user with that email address. Only the SHA-256 hash
+-- of the link token is stored.
+CREATE TABLE team_invites
+(
+    id         bigserial PRIMARY KEY,
+    team_id    bigint                      NOT NULL REFERENCES teams ON DELETE CASCADE,
+    email      varchar(255)                NOT NULL DEFAULT '',
+    token_hash char(64)                    NOT NULL,
+    invited_by bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires    timestamp(0) with time zone NOT NULL
+);
+
+ALTER TABLE team_invites ADD CONSTRAINT team_invites_uc_token_hash UNIQUE (token_hash);
+
+-- Snippets without a team are public, the others are only visible to the
+-- members of their team.
+ALTER TABLE snippets ADD COLUMN team_id bigint REFERENCES teams ON DELETE CASCADE;
+
+CREATE INDEX snippets_team_id_idx ON snippets (team_id);
@@ -0,0 +1,11 @@
+-- A row is a token bucket of the rate limiter. Rows are deleted once their
+-- bucket is full again, at full_at, since a missing bucket is a full one.
+CREATE TABLE rate_limits
+(
+    key     TEXT                        PRIMARY KEY,
+    tokens  double precision            NOT NULL,
+    updated timestamp(0) with time zone NOT NULL,
+    full_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX rate_limits_full_at_idx ON rate_limits (full_at);
@@ -0,0 +1,6 @@
+-- Only bcrypt hashes fit, and were understood, before. The users with other
+-- hashes are left without a password, as if provisioned by an OpenID Connect
+-- provider.
+UPDATE users SET hashed_password = NULL WHERE hashed_password NOT LIKE '$2_$%';
+
+ALTER TABLE users ALTER COLUMN hashed_password TYPE char(60);
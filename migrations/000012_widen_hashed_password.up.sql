@@ -0,0 +1,3 @@
+-- Password hashes are PHC strings, whose length depends on the algorithm and its
+-- parameters, rather than 60 character bcrypt hashes.
+ALTER TABLE users ALTER COLUMN hashed_password TYPE TEXT;
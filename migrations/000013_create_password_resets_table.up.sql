@@ -0,0 +1,14 @@
+-- A password reset link lets its holder choose a new password for a user. Only
+-- the SHA-256 hash of the link token is stored.
+CREATE TABLE password_resets
+(
+    id         bigserial PRIMARY KEY,
+    user_id    bigint                      NOT NULL REFERENCES users ON DELETE CASCADE,
+    token_hash char(64)                    NOT NULL,
+    created    timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires    timestamp(0) with time zone NOT NULL
+);
+
+ALTER TABLE password_resets ADD CONSTRAINT password_resets_uc_token_hash UNIQUE (token_hash);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE rate_limits
+    ALTER COLUMN updated TYPE timestamp(0) with time zone,
+    ALTER COLUMN full_at TYPE timestamp(0) with time zone;
@@ -0,0 +1,5 @@
+-- Buckets refill from the time they were last updated, which was rounded to the
+-- second, so that every update could give back up to half a second of tokens.
+ALTER TABLE rate_limits
+    ALTER COLUMN updated TYPE timestamp(6) with time zone,
+    ALTER COLUMN full_at TYPE timestamp(6) with time zone;
@@ -0,0 +1,29 @@
+// Package migrations embeds the migrations of the database schema, one set for
+// each backend. Both sets have the same versions, so that a version means the
+// same schema whatever the backend.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed *.sql
+var postgres embed.FS
+
+//go:embed sqlite/*.sql
+var sqlite embed.FS
+
+// Postgres holds the migrations of Postgres databases.
+var Postgres fs.FS = postgres
+
+// SQLite holds the migrations of SQLite databases.
+var SQLite fs.FS = mustSub(sqlite, "sqlite")
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
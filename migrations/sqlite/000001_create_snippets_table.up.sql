@@ -0,0 +1,32 @@
+CREATE TABLE snippets
+(
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    title   VARCHAR(100) NOT NULL,
+    content TEXT         NOT NULL,
+    created DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires DATETIME     NOT NULL DEFAULT (datetime('now', '+365 days'))
+);
+
+INSERT INTO snippets (title, content)
+VALUES ('An old silent pond',
+        'An old silent pond...
+A frog jumps into the pond,
+splash! Silence again.
+
+– Matsuo Bashō');
+
+INSERT INTO snippets (title, content)
+VALUES ('Over the wintry forest',
+        'Over the wintry
+forest, winds howl in rage
+with no leaves to blow.
+
+– Natsume Soseki');
+
+INSERT INTO snippets (title, content)
+VALUES ('First autumn morning',
+        'First autumn morning
+the mirror I stare into
+shows my father''s face.
+
+– Murakami Kijo');
@@ -0,0 +1,11 @@
+-- Unlike in Postgres, hashed_password is nullable from the start, since SQLite
+-- can't drop the NOT NULL constraint of a column in 000006.
+CREATE TABLE users
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    name            VARCHAR(255) NOT NULL,
+    email           VARCHAR(255) NOT NULL,
+    hashed_password TEXT,
+    created         DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT users_uc_email UNIQUE (email)
+);
@@ -0,0 +1,9 @@
+-- The layout of the scs SQLite store, which keeps the expiry as a Julian day.
+CREATE TABLE sessions
+(
+    token  TEXT PRIMARY KEY,
+    data   BLOB NOT NULL,
+    expiry REAL NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
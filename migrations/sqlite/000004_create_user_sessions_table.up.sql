@@ -0,0 +1,13 @@
+CREATE TABLE user_sessions
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    token      TEXT     NOT NULL,
+    user_id    INTEGER  NOT NULL REFERENCES users ON DELETE CASCADE,
+    user_agent TEXT     NOT NULL,
+    ip         TEXT     NOT NULL,
+    created    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT user_sessions_uc_token UNIQUE (token)
+);
+
+CREATE INDEX user_sessions_user_id_idx ON user_sessions (user_id);
@@ -0,0 +1,13 @@
+ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE user_recovery_codes
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id     INTEGER  NOT NULL REFERENCES users ON DELETE CASCADE,
+    hashed_code CHAR(64) NOT NULL,
+    used        BOOLEAN  NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX user_recovery_codes_user_id_idx ON user_recovery_codes (user_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS user_identities;
+
+DELETE FROM users WHERE hashed_password IS NULL;
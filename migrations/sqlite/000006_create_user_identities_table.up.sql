@@ -0,0 +1,10 @@
+-- Users who sign in with an OpenID Connect provider don't have a password,
+-- which users.hashed_password allows since 000002.
+CREATE TABLE user_identities
+(
+    issuer  TEXT     NOT NULL,
+    subject TEXT     NOT NULL,
+    user_id INTEGER  NOT NULL REFERENCES users ON DELETE CASCADE,
+    created DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (issuer, subject)
+);
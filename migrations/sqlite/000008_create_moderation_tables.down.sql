@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS moderation_actions;
+
+DROP TABLE IF EXISTS reports;
+
+ALTER TABLE snippets DROP COLUMN hidden;
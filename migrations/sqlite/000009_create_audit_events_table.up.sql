@@ -0,0 +1,30 @@
+CREATE TABLE audit_events
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    type       TEXT     NOT NULL,
+    -- actor_id is NULL for events without a known user, like a failed login.
+    -- It is not a foreign key so that events outlive the users they mention.
+    actor_id   INTEGER,
+    ip         TEXT     NOT NULL DEFAULT '',
+    user_agent TEXT     NOT NULL DEFAULT '',
+    payload    TEXT     NOT NULL DEFAULT '{}' CHECK (json_valid(payload)),
+    created    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_events_type_idx ON audit_events (type);
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id);
+
+-- The audit log is append-only.
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE
+    ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete
+    BEFORE DELETE
+    ON audit_events
+BEGIN
+    SELECT RAISE(ABORT, 'audit_events is append-only');
+END;
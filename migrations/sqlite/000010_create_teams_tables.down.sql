@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS snippets_team_id_idx;
+
+ALTER TABLE snippets DROP COLUMN team_id;
+
+DROP TABLE IF EXISTS team_invites;
+
+DROP TABLE IF EXISTS team_members;
+
+DROP TABLE IF EXISTS teams;
@@ -0,0 +1,41 @@
+CREATE TABLE teams
+(
+    id      INTEGER PRIMARY KEY AUTOINCREMENT,
+    name    VARCHAR(100) NOT NULL,
+    slug    VARCHAR(100) NOT NULL,
+    created DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT teams_uc_slug UNIQUE (slug)
+);
+
+CREATE TABLE team_members
+(
+    team_id INTEGER  NOT NULL REFERENCES teams ON DELETE CASCADE,
+    user_id INTEGER  NOT NULL REFERENCES users ON DELETE CASCADE,
+    role    TEXT     NOT NULL DEFAULT 'member',
+    joined  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (team_id, user_id),
+    CONSTRAINT team_members_role_check CHECK (role IN ('owner', 'member'))
+);
+
+CREATE INDEX team_members_user_id_idx ON team_members (user_id);
+
+-- An invite with an empty email can be accepted by anyone holding its link,
+-- otherwise only by the user with that email address. Only the SHA-256 hash
+-- of the link token is stored.
+CREATE TABLE team_invites
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    team_id    INTEGER      NOT NULL REFERENCES teams ON DELETE CASCADE,
+    email      VARCHAR(255) NOT NULL DEFAULT '',
+    token_hash CHAR(64)     NOT NULL,
+    invited_by INTEGER      NOT NULL REFERENCES users ON DELETE CASCADE,
+    created    DATETIME     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires    DATETIME     NOT NULL,
+    CONSTRAINT team_invites_uc_token_hash UNIQUE (token_hash)
+);
+
+-- Snippets without a team are public, the others are only visible to the
+-- members of their team.
+ALTER TABLE snippets ADD COLUMN team_id INTEGER REFERENCES teams ON DELETE CASCADE;
+
+CREATE INDEX snippets_team_id_idx ON snippets (team_id);
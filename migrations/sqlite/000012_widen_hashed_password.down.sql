@@ -0,0 +1,3 @@
+-- Only bcrypt hashes were understood before. The users with other hashes are
+-- left without a password, as if provisioned by an OpenID Connect provider.
+UPDATE users SET hashed_password = NULL WHERE hashed_password NOT LIKE '$2_$%';
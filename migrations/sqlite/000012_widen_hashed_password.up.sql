@@ -0,0 +1,2 @@
+-- SQLite doesn't limit the length of users.hashed_password, which is TEXT since
+-- 000002. This migration is empty so that the versions of both schemas match.
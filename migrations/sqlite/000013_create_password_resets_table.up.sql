@@ -0,0 +1,13 @@
+-- A password reset link lets its holder choose a new password for a user. Only
+-- the SHA-256 hash of the link token is stored.
+CREATE TABLE password_resets
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER  NOT NULL REFERENCES users ON DELETE CASCADE,
+    token_hash CHAR(64) NOT NULL,
+    created    DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires    DATETIME NOT NULL,
+    CONSTRAINT password_resets_uc_token_hash UNIQUE (token_hash)
+);
+
+CREATE INDEX password_resets_user_id_idx ON password_resets (user_id);
@@ -0,0 +1,3 @@
+-- The time step of the last TOTP code a user logged in with. Codes of this step
+-- or an earlier one are refused, so that a code can't be used twice.
+ALTER TABLE users ADD COLUMN totp_last_counter INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Only the postgres rate limiter keeps its buckets in the database, and it needs
+-- Postgres. This migration is empty so that the versions of both schemas match.
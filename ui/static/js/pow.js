@@ -0,0 +1,67 @@
+// Solves the proof-of-work challenge of a form when it is submitted, by finding a
+// counter such that the SHA-256 hash of "<challenge>:<counter>" starts with as
+// many zero bits as the server asked for.
+var batchSize = 256;
+
+function leadingZeroBits(hash) {
+	var bits = 0;
+	for (var i = 0; i < hash.length; i++) {
+		if (hash[i] != 0) {
+			return bits + Math.clz32(hash[i]) - 24;
+		}
+		bits += 8;
+	}
+	return bits;
+}
+
+// solve hashes the counters in batches, which is much faster than waiting for
+// each hash in turn.
+function solve(challenge, difficulty, start) {
+	var encoder = new TextEncoder();
+	var hashes = [];
+	for (var i = 0; i < batchSize; i++) {
+		hashes.push(crypto.subtle.digest("SHA-256", encoder.encode(challenge + ":" + (start + i))));
+	}
+	return Promise.all(hashes).then(function (results) {
+		for (var i = 0; i < results.length; i++) {
+			if (leadingZeroBits(new Uint8Array(results[i])) >= difficulty) {
+				return start + i;
+			}
+		}
+		return solve(challenge, difficulty, start + batchSize);
+	});
+}
+
+var challenges = document.querySelectorAll("input[name='pow_challenge']");
+for (var i = 0; i < challenges.length; i++) {
+	(function (challenge) {
+		var form = challenge.form;
+		var solution = form.querySelector("input[name='pow_solution']");
+		var solving = false;
+
+		form.addEventListener("submit", function (event) {
+			// Without Web Crypto, the form is sent as is and the server asks to
+			// try again from a browser which has it.
+			if (solution.value != "" || !window.crypto || !crypto.subtle) {
+				return;
+			}
+			event.preventDefault();
+			if (solving) {
+				return;
+			}
+			solving = true;
+
+			var submit = form.querySelector("input[type='submit']");
+			var label = submit.value;
+			submit.disabled = true;
+			submit.value = "Checking you're not a robot...";
+
+			solve(challenge.value, parseInt(challenge.dataset.difficulty, 10), 0).then(function (counter) {
+				solution.value = counter;
+				submit.disabled = false;
+				submit.value = label;
+				form.submit();
+			});
+		});
+	})(challenges[i]);
+}